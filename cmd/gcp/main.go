@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/client"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/transfer"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How often the progress gets updated.
+const progressInterval = 200 * time.Millisecond
+
+func main() {
+	log.WithField("args", os.Args).Traceln("--> gcp.main")
+	configPath := flag.String("conf", common.CONFIGPATH, "Config path.")
+	authPath := flag.String("auth", common.AUTHPATH, "Authorized keys path.")
+	port := flag.Int("P", 0, "Port to connect to.")
+	recursive := flag.Bool("r", false, "Copy directories recursively.")
+	resume := flag.Bool("c", false, "Continue interrupted transfers of files that were copied partially.")
+	quiet := flag.Bool("q", false, "Do not show the progress.")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [options] source... [user@]host:target\n       %s [options] [user@]host:source... target\n",
+			os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	log.WithFields(log.Fields{
+		"configPath": *configPath,
+		"authPath":   *authPath,
+		"args":       flag.Args(),
+	}).Debugln("Parsed arguments.")
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sources, target := flag.Args()[:flag.NArg()-1], flag.Arg(flag.NArg()-1)
+	server, upload, err := remoteServer(sources, target)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gcp: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	config := client.LoadConfig(*configPath)
+	config.Set("Authentication.KeyStore", *authPath)
+	if *port != 0 {
+		config.Set("Client.Port", *port)
+	}
+	clnt := client.NewClient(config)
+	if err := clnt.ParseArgument(server); err != nil {
+		os.Exit(1)
+	}
+	conn, err := clnt.DialSubsystem(transfer.Subsystem)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gcp: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer utils.CloseConn(conn)
+
+	progress := &progress{}
+	options := transfer.Options{
+		Recursive: *recursive,
+		Continue:  *resume,
+		Failed: func(err error) {
+			progress.done()
+			_, _ = fmt.Fprintf(os.Stderr, "gcp: %s\n", err.Error())
+		},
+	}
+	if !*quiet && terminal.IsTerminal(int(os.Stderr.Fd())) {
+		options.Progress = progress.show
+	}
+	if upload {
+		_, path := client.SplitRemote(target)
+		err = transfer.Upload(conn, sources, path, options)
+	} else if info, statErr := os.Stat(target); len(sources) > 1 && (statErr != nil || !info.IsDir()) {
+		err = fmt.Errorf("%s is not a directory", target)
+	} else {
+		for _, source := range sources {
+			_, path := client.SplitRemote(source)
+			if downloadErr := transfer.Download(conn, path, target, options); downloadErr != nil {
+				err = downloadErr
+			}
+			// Failures of single files do not stop the others.
+			if err != nil && !errors.Is(err, transfer.ErrIncomplete) {
+				break
+			}
+		}
+	}
+	progress.done()
+	if err != nil {
+		if !errors.Is(err, transfer.ErrIncomplete) {
+			_, _ = fmt.Fprintf(os.Stderr, "gcp: %s\n", err.Error())
+		}
+		utils.CloseConn(conn)
+		os.Exit(1)
+	}
+}
+
+// remoteServer returns the server to connect to and whether the files get uploaded to it. Either the target or all the
+// sources have to be on the same server.
+func remoteServer(sources []string, target string) (string, bool, error) {
+	if server, _ := client.SplitRemote(target); server != "" {
+		for _, source := range sources {
+			if remote, _ := client.SplitRemote(source); remote != "" {
+				return "", false, errors.New("copying between servers is not supported")
+			}
+		}
+		return server, true, nil
+	}
+	var server string
+	for _, source := range sources {
+		remote, _ := client.SplitRemote(source)
+		if remote == "" || (server != "" && remote != server) {
+			return "", false, errors.New("either the target or all sources have to be on the same server")
+		}
+		server = remote
+	}
+	return server, false, nil
+}
+
+// progress shows the progress of the file being transferred on a single line.
+type progress struct {
+	name    string
+	started time.Time
+	start   int64
+	shown   time.Time
+	showing bool
+}
+
+func (progress *progress) show(name string, done int64, size int64) {
+	now := time.Now()
+	if name != progress.name {
+		progress.done()
+		progress.name, progress.started, progress.start = name, now, done
+	} else if done < size && now.Sub(progress.shown) < progressInterval {
+		return
+	}
+	progress.shown, progress.showing = now, true
+	percent := int64(100)
+	if size > 0 {
+		percent = done * 100 / size
+	}
+	rate := float64(0)
+	if elapsed := now.Sub(progress.started).Seconds(); elapsed > 0 {
+		rate = float64(done-progress.start) / elapsed
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "\r%-40s %3d%% %9s %9s/s", filepath.Base(name), percent, formatBytes(float64(done)),
+		formatBytes(rate))
+}
+
+// done ends the line of the progress, if any.
+func (progress *progress) done() {
+	if progress.showing {
+		_, _ = fmt.Fprint(os.Stderr, "\n")
+	}
+	progress.name, progress.showing = "", false
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", n, units[unit])
+}
+
+func init() {
+	_ = os.Setenv("GODEBUG", os.Getenv("GODEBUG")+",tls13=1")
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/client"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/remotefs"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const prompt = "gosh-ftp> "
+
+func main() {
+	log.WithField("args", os.Args).Traceln("--> gosh-ftp.main")
+	configPath := flag.String("conf", common.CONFIGPATH, "Config path.")
+	authPath := flag.String("auth", common.AUTHPATH, "Authorized keys path.")
+	port := flag.Int("P", 0, "Port to connect to.")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [user@]host\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	log.WithFields(log.Fields{
+		"configPath": *configPath,
+		"authPath":   *authPath,
+		"args":       flag.Args(),
+	}).Debugln("Parsed arguments.")
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config := client.LoadConfig(*configPath)
+	config.Set("Authentication.KeyStore", *authPath)
+	if *port != 0 {
+		config.Set("Client.Port", *port)
+	}
+	clnt := client.NewClient(config)
+	if err := clnt.ParseArgument(flag.Arg(0)); err != nil {
+		os.Exit(1)
+	}
+	conn, err := clnt.DialSubsystem(remotefs.Subsystem)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gosh-ftp: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer utils.CloseConn(conn)
+
+	shell := &shell{client: remotefs.NewClient(conn), out: os.Stdout}
+	if shell.home, err = shell.client.RealPath("."); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gosh-ftp: %s\n", err.Error())
+		utils.CloseConn(conn)
+		os.Exit(1)
+	}
+	shell.cwd = shell.home
+	shell.run(os.Stdin, terminal.IsTerminal(int(os.Stdin.Fd())))
+}
+
+// A shell runs the commands the user enters on the remote files. Relative remote paths are relative to cwd.
+type shell struct {
+	client *remotefs.Client
+	out    io.Writer
+	home   string
+	cwd    string
+}
+
+type command struct {
+	usage string
+	run   func(shell *shell, args []string) error
+	min   int // The number of arguments required.
+	max   int // The number of arguments allowed.
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":    {"help", (*shell).help, 0, 0},
+		"ls":      {"ls [-l] [path]", (*shell).ls, 0, 2},
+		"cd":      {"cd [path]", (*shell).cd, 0, 1},
+		"pwd":     {"pwd", (*shell).pwd, 0, 0},
+		"lcd":     {"lcd path", (*shell).lcd, 1, 1},
+		"lpwd":    {"lpwd", (*shell).lpwd, 0, 0},
+		"get":     {"get remote [local]", (*shell).get, 1, 2},
+		"put":     {"put local [remote]", (*shell).put, 1, 2},
+		"stat":    {"stat path", (*shell).stat, 1, 1},
+		"mkdir":   {"mkdir path", (*shell).mkdir, 1, 1},
+		"rmdir":   {"rmdir path", (*shell).remove, 1, 1},
+		"rm":      {"rm path", (*shell).remove, 1, 1},
+		"rename":  {"rename old new", (*shell).rename, 2, 2},
+		"symlink": {"symlink target path", (*shell).symlink, 2, 2},
+		"exit":    {"exit", nil, 0, 0},
+	}
+}
+
+// run reads commands from in until it ends or the user exits.
+func (shell *shell) run(in io.Reader, interactive bool) {
+	log.Traceln("--> gosh-ftp.shell.run")
+	scanner := bufio.NewScanner(in)
+	for {
+		if interactive {
+			_, _ = fmt.Fprint(shell.out, prompt)
+		}
+		if !scanner.Scan() {
+			if interactive {
+				_, _ = fmt.Fprintln(shell.out)
+			}
+			return
+		}
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "gosh-ftp: %s\n", err.Error())
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" || args[0] == "bye" {
+			return
+		}
+		cmd, ok := commands[args[0]]
+		if !ok {
+			_, _ = fmt.Fprintf(os.Stderr, "gosh-ftp: unknown command %s, try help\n", args[0])
+			continue
+		}
+		if len(args)-1 < cmd.min || len(args)-1 > cmd.max {
+			_, _ = fmt.Fprintf(os.Stderr, "usage: %s\n", cmd.usage)
+			continue
+		}
+		if err := cmd.run(shell, args[1:]); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "gosh-ftp: %s\n", err.Error())
+		}
+	}
+}
+
+// splitArgs splits the line at whitespace. Double quotes and backslashes keep whitespace within arguments.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted, escaped := false, false, false
+	for _, char := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(char)
+			escaped = false
+		case char == '\\':
+			inArg, escaped = true, true
+		case char == '"':
+			inArg, quoted = true, !quoted
+		case !quoted && (char == ' ' || char == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(char)
+			inArg = true
+		}
+	}
+	if quoted || escaped {
+		return nil, errors.New("unterminated argument")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// remote returns the remote path of the name, which is relative to the working directory unless it is absolute.
+func (shell *shell) remote(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		return path.Join(shell.home, name[1:])
+	}
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(shell.cwd, name)
+}
+
+func (shell *shell) help(args []string) error {
+	_, _ = fmt.Fprintln(shell.out, "Commands:")
+	for _, name := range []string{"ls", "cd", "pwd", "lcd", "lpwd", "get", "put", "stat", "mkdir", "rmdir", "rm",
+		"rename", "symlink", "exit"} {
+		_, _ = fmt.Fprintf(shell.out, "  %s\n", commands[name].usage)
+	}
+	return nil
+}
+
+func (shell *shell) ls(args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return errors.New("usage: " + commands["ls"].usage)
+	}
+	name := "."
+	if len(args) == 1 {
+		name = args[0]
+	}
+	target := shell.remote(name)
+	info, err := shell.client.Stat(target)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return shell.list(target, info, long)
+	}
+	entries, err := shell.client.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := shell.list(path.Join(target, entry.Name()), info, long); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list prints the file, with its attributes if long.
+func (shell *shell) list(name string, info fs.FileInfo, long bool) error {
+	if !long {
+		_, _ = fmt.Fprintln(shell.out, info.Name())
+		return nil
+	}
+	line := fmt.Sprintf("%s %10d %s %s", info.Mode(), info.Size(), info.ModTime().Format("Jan _2 15:04 2006"),
+		info.Name())
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := shell.client.Readlink(name); err == nil {
+			line += " -> " + target
+		}
+	}
+	_, _ = fmt.Fprintln(shell.out, line)
+	return nil
+}
+
+func (shell *shell) cd(args []string) error {
+	target := shell.home
+	if len(args) == 1 {
+		target = shell.remote(args[0])
+	}
+	info, err := shell.client.Stat(target)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", target)
+	}
+	shell.cwd = target
+	return nil
+}
+
+func (shell *shell) pwd(args []string) error {
+	_, _ = fmt.Fprintln(shell.out, shell.cwd)
+	return nil
+}
+
+func (shell *shell) lcd(args []string) error {
+	return os.Chdir(args[0])
+}
+
+func (shell *shell) lpwd(args []string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(shell.out, dir)
+	return nil
+}
+
+// get downloads the remote file into the local file or directory, with the same permissions and mtime.
+func (shell *shell) get(args []string) error {
+	source := shell.remote(args[0])
+	target := path.Base(source)
+	if len(args) == 2 {
+		target = args[1]
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			target = filepath.Join(target, path.Base(source))
+		}
+	}
+	in, err := shell.client.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", source)
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, time.Now(), info.ModTime())
+}
+
+// put uploads the local file into the remote file or directory, with the same permissions.
+func (shell *shell) put(args []string) error {
+	source := args[0]
+	target := shell.remote(filepath.Base(source))
+	if len(args) == 2 {
+		target = shell.remote(args[1])
+		if info, err := shell.client.Stat(target); err == nil && info.IsDir() {
+			target = path.Join(target, filepath.Base(source))
+		}
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", source)
+	}
+	out, err := shell.client.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (shell *shell) stat(args []string) error {
+	name := shell.remote(args[0])
+	info, err := shell.client.Lstat(name)
+	if err != nil {
+		return err
+	}
+	return shell.list(name, info, true)
+}
+
+func (shell *shell) mkdir(args []string) error {
+	return shell.client.Mkdir(shell.remote(args[0]), 0755)
+}
+
+func (shell *shell) remove(args []string) error {
+	return shell.client.Remove(shell.remote(args[0]))
+}
+
+func (shell *shell) rename(args []string) error {
+	return shell.client.Rename(shell.remote(args[0]), shell.remote(args[1]))
+}
+
+// symlink creates the link with the target as given, so relative targets stay relative to the link.
+func (shell *shell) symlink(args []string) error {
+	return shell.client.Symlink(args[0], shell.remote(args[1]))
+}
+
+func init() {
+	_ = os.Setenv("GODEBUG", os.Getenv("GODEBUG")+",tls13=1")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/client"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/deltasync"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"os"
+	"strings"
+)
+
+func main() {
+	log.WithField("args", os.Args).Traceln("--> gosh-sync.main")
+	configPath := flag.String("conf", common.CONFIGPATH, "Config path.")
+	authPath := flag.String("auth", common.AUTHPATH, "Authorized keys path.")
+	port := flag.Int("P", 0, "Port to connect to.")
+	var rules []deltasync.Rule
+	flag.Var(&ruleFlag{rules: &rules, include: true}, "include",
+		"Include paths matching the pattern, even if a later -exclude matches them.")
+	flag.Var(&ruleFlag{rules: &rules}, "exclude", "Exclude paths matching the pattern.")
+	deleteExtraneous := flag.Bool("delete", false, "Delete files the source does not have, unless they are excluded.")
+	var dryRun bool
+	flag.BoolVar(&dryRun, "n", false, "Only show what would change.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only show what would change.")
+	quiet := flag.Bool("q", false, "Do not show the changes.")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [options] source [user@]host:target\n       %s [options] [user@]host:source target\n"+
+				"Synchronizes the contents of the source directory into the target directory.\n",
+			os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	log.WithFields(log.Fields{
+		"configPath": *configPath,
+		"authPath":   *authPath,
+		"args":       flag.Args(),
+		"rules":      rules,
+	}).Debugln("Parsed arguments.")
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	source, target := flag.Arg(0), flag.Arg(1)
+	sourceServer, sourcePath := client.SplitRemote(source)
+	targetServer, targetPath := client.SplitRemote(target)
+	if (sourceServer == "") == (targetServer == "") {
+		_, _ = fmt.Fprintln(os.Stderr, "gosh-sync: either the source or the target has to be on a server")
+		os.Exit(2)
+	}
+
+	config := client.LoadConfig(*configPath)
+	config.Set("Authentication.KeyStore", *authPath)
+	if *port != 0 {
+		config.Set("Client.Port", *port)
+	}
+	clnt := client.NewClient(config)
+	if err := clnt.ParseArgument(sourceServer + targetServer); err != nil {
+		os.Exit(1)
+	}
+	conn, err := clnt.DialSubsystem(deltasync.Subsystem)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gosh-sync: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer utils.CloseConn(conn)
+
+	options := deltasync.Options{Rules: rules, Delete: *deleteExtraneous, DryRun: dryRun}
+	options.Changed = func(change deltasync.Change) {
+		if change.Action == deltasync.ActionFailed {
+			_, _ = fmt.Fprintf(os.Stderr, "gosh-sync: %s: %s\n", change.Path, change.Err.Error())
+		} else if !*quiet {
+			_, _ = fmt.Println(change.String())
+		}
+	}
+	var stats deltasync.Stats
+	if targetServer != "" {
+		stats, err = deltasync.Push(conn, sourcePath, targetPath, options)
+	} else {
+		stats, err = deltasync.Pull(conn, sourcePath, targetPath, options)
+	}
+	if err != nil && !errors.Is(err, deltasync.ErrIncomplete) {
+		_, _ = fmt.Fprintf(os.Stderr, "gosh-sync: %s\n", err.Error())
+		utils.CloseConn(conn)
+		os.Exit(1)
+	}
+	if !*quiet && !dryRun {
+		_, _ = fmt.Printf("%d files transferred, %d bytes sent, %d bytes matched, %d deleted\n", stats.Files,
+			stats.Literal, stats.Matched, stats.Deleted)
+	}
+	if err != nil {
+		utils.CloseConn(conn)
+		os.Exit(1)
+	}
+}
+
+// ruleFlag adds a rule for every value of a flag, in the order of all such flags.
+type ruleFlag struct {
+	rules   *[]deltasync.Rule
+	include bool
+}
+
+func (flag *ruleFlag) String() string {
+	if flag.rules == nil {
+		return ""
+	}
+	var patterns []string
+	for _, rule := range *flag.rules {
+		if rule.Include == flag.include {
+			patterns = append(patterns, rule.Pattern)
+		}
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (flag *ruleFlag) Set(value string) error {
+	*flag.rules = append(*flag.rules, deltasync.Rule{Include: flag.include, Pattern: value})
+	return nil
+}
+
+func init() {
+	_ = os.Setenv("GODEBUG", os.Getenv("GODEBUG")+",tls13=1")
+}
@@ -8,21 +8,57 @@ import (
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
+	"strings"
+	"syscall"
 )
 
 func main() {
 	log.WithField("args", os.Args).Traceln("--> gosh.main")
 	configPath := flag.String("conf", common.CONFIGPATH, "Config path.")
 	authPath := flag.String("auth", common.AUTHPATH, "Authorized keys path.")
+	attachId := flag.String("attach", "", "Attach to the detached session with this id.")
+	listSessions := flag.Bool("sessions", false, "List the detached sessions.")
+	join := flag.String("join", "", "Join the session with this id, which you got invited to.")
+	invite := flag.String("invite", "", "Invite a user to your session as user[:ro|:rw], or uninvite as user:none.")
+	sessionId := flag.String("session", os.Getenv(common.ENV_GOSH_SESSION_ID), "The session to invite to.")
+	escapeChar := flag.String("e", "", "Escape character, or none to disable escapes.")
+	var localForwards stringList
+	flag.Var(&localForwards, "L",
+		"Forward connections to [bind:]port or a socket on to host:hostport or a socket the server dials.")
+	var remoteForwards stringList
+	flag.Var(&remoteForwards, "R",
+		"Forward connections to [bind:]port or a socket on the server to host:hostport or a socket dialed locally.")
+	var dynamicForwards stringList
+	flag.Var(&dynamicForwards, "D", "Run a SOCKS5 proxy on [bind:]port, whose connections the server dials.")
 
 	flag.Parse()
 	log.WithFields(log.Fields{
 		"configPath": *configPath,
 		"authPath":   *authPath,
+		"attachId":   *attachId,
 	}).Debugln("Parsed arguments.")
 
 	config := client.LoadConfig(*configPath)
 	config.Set("Authentication.KeyStore", *authPath)
+	config.Set("Client.Attach", *attachId)
+	if *listSessions {
+		config.Set("Client.Attach", common.ATTACH_LIST)
+	}
+	config.Set("Client.Join", *join)
+	config.Set("Client.Invite", *invite)
+	config.Set("Client.Session", *sessionId)
+	if *escapeChar != "" {
+		config.Set("Client.EscapeChar", *escapeChar)
+	}
+	if len(localForwards) > 0 {
+		config.Set("Client.LocalForward", []string(localForwards))
+	}
+	if len(remoteForwards) > 0 {
+		config.Set("Client.RemoteForward", []string(remoteForwards))
+	}
+	if len(dynamicForwards) > 0 {
+		config.Set("Client.DynamicForward", []string(dynamicForwards))
+	}
 	clnt := client.NewClient(config)
 
 	if err := clnt.ParseArgument(flag.Arg(0)); err != nil {
@@ -35,9 +71,9 @@ func main() {
 	if err != nil {
 		os.Exit(1)
 	}
-	defer utils.CloseConn(conn)
 	err = clnt.PerformTransfer(conn, conn)
 	if err != nil {
+		utils.CloseConn(conn)
 		os.Exit(1)
 	}
 	oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
@@ -53,8 +89,33 @@ func main() {
 		}
 	}()
 
-	go utils.Forward(os.Stdin, conn, "stdin", "server")
-	utils.Forward(conn, os.Stdout, "server", "stdout")
+	clnt.OnSuspend(func() {
+		if err := terminal.Restore(int(os.Stdin.Fd()), oldState); err != nil {
+			log.WithError(err).Errorln("Failed to set terminal into cooked mode.")
+		}
+		// Stops gosh until the shell continues it.
+		if err := syscall.Kill(0, syscall.SIGTSTP); err != nil {
+			log.WithError(err).Errorln("Failed to suspend.")
+		}
+		if _, err := terminal.MakeRaw(int(os.Stdin.Fd())); err != nil {
+			log.WithError(err).Errorln("Failed to set terminal into raw mode.")
+		}
+	})
+	if err := clnt.Interact(conn, os.Stdin, os.Stdout); err != nil {
+		log.WithError(err).Errorln("Session ended abnormally.")
+	}
+}
+
+// stringList collects the values of a flag that can be given several times.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
 }
 
 func init() {
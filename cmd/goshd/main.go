@@ -1,6 +1,5 @@
 package main
 
-import "C"
 import (
 	"flag"
 	"fmt"
@@ -33,52 +32,78 @@ func main() {
 	config := server.LoadConfig(*configPath)
 	config.Set("Authentication.KeyStore", *authPath)
 	srvr := server.NewServer(config)
+	if err := srvr.LoadCertKeyPair(*certFile, *keyFile); err != nil {
+		log.WithError(err).Warnln("Cannot tell rejected clients why they got rejected.")
+	}
 
-	var children []*exec.Cmd
 	go func() {
-		sigChan := make(chan os.Signal)
-		signal.Notify(sigChan, unix.SIGINT)
-		log.WithField("sig", (<-sigChan).String()).Warnln("Received signal. Shutting down.")
-		cleanup(children)
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, unix.SIGINT, unix.SIGTERM, unix.SIGHUP)
+		for sig := range sigChan {
+			if sig == unix.SIGHUP {
+				log.WithField("sig", sig.String()).Infoln("Received signal. Reloading config.")
+				if err := server.ReloadConfig(config, *configPath); err != nil {
+					log.WithError(err).Errorln("Keeping the current config.")
+				}
+				continue
+			}
+			log.WithField("sig", sig.String()).Warnln("Received signal. Shutting down.")
+			srvr.Stop()
+		}
 	}()
 	remoteChan := make(chan server.RemoteHandle)
-	defer close(remoteChan)
 	go srvr.AwaitConnections(remoteChan)
 	for remote := range remoteChan {
 		log.WithField("remote", remote).Debugln("Got a remote handle.")
+		connFile := os.NewFile(remote.Fd, "conn")
+		controlFds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to create control channel.")
+			_ = connFile.Close()
+			srvr.Release(remote.RemoteAddr)
+			continue
+		}
+		control := os.NewFile(uintptr(controlFds[0]), "control")
+		hostControl := os.NewFile(uintptr(controlFds[1]), "control")
 		host := exec.Command(path.Join(path.Dir(os.Args[0]), "goshh"),
 			"--conf", *configPath,
 			"--auth", *authPath,
 			"--cert", *certFile,
 			"--key", *keyFile,
-			"--fd", strconv.FormatUint(uint64(remote.Fd), 10),
+			"--fd", "3",
+			"--control", "4",
 			"--remote", remote.RemoteAddr.String())
+		if remote.Cred != nil {
+			host.Args = append(host.Args,
+				"--peer-pid", strconv.Itoa(int(remote.Cred.Pid)),
+				"--peer-uid", strconv.Itoa(int(remote.Cred.Uid)),
+				"--peer-gid", strconv.Itoa(int(remote.Cred.Gid)))
+		}
 		host.Env = []string{fmt.Sprintf("LOG_LEVEL=%s", log.GetLevel().String())}
 		host.Stdin = os.Stdin
 		host.Stdout = os.Stdout
 		host.Stderr = os.Stderr
-		err := host.Start()
+		host.ExtraFiles = []*os.File{connFile, hostControl}
+		err = host.Start()
+		_ = connFile.Close()
+		_ = hostControl.Close()
 		if err != nil {
 			log.WithError(err).Errorln("Failed to start child")
+			_ = control.Close()
+			srvr.Release(remote.RemoteAddr)
 		} else {
 			log.WithField("pid", host.Process.Pid).Infoln("Started child.")
-			children = append(children, host)
-			log.WithFields(log.Fields{
-				"children": children,
-				"host":     host,
-			}).Debugln("Added child process to array.")
+			go func(host *exec.Cmd, control *os.File, remote server.RemoteHandle) {
+				srvr.Track(host, control, remote.RemoteAddr)
+				_ = control.Close()
+			}(host, control, remote)
 		}
 	}
+	cleanup(srvr)
 }
 
-func cleanup(children []*exec.Cmd) {
-	log.WithField("children", children).Traceln("--> main.cleanup")
-	for _, host := range children {
-		if err := host.Process.Signal(unix.SIGINT); err != nil {
-			log.WithError(err).WithField("host", host).Warnln("Failed to interrupt to child.")
-		} else {
-			log.WithField("host", host).Infoln("Sent interrupt to child.")
-		}
-	}
+func cleanup(srvr *server.Server) {
+	log.Traceln("--> main.cleanup")
+	srvr.Shutdown()
 	os.Exit(0)
 }
@@ -1,6 +1,5 @@
 package main
 
-import "C"
 import (
 	"flag"
 	"fmt"
@@ -11,8 +10,12 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"time"
 )
 
+// The environment goshh was started with, before init changed it, to pass on to the commands it executes.
+var environment = os.Environ()
+
 func main() {
 	log.WithField("args", os.Args).Traceln("--> goshh.main")
 	configPath := flag.String("conf", common.CONFIGPATH, "Config path.")
@@ -20,35 +23,84 @@ func main() {
 	certFile := flag.String("cert", common.CERTFILE, "Certificate file.")
 	keyFile := flag.String("key", common.KEYFILE, "Key file.")
 	fd := flag.Uint("fd", 0, "The file descriptor for the connection.")
+	controlFd := flag.Uint("control", 0, "The file descriptor of the control channel to goshd.")
 	rAddr := flag.String("remote", fmt.Sprintf("%s:%d", common.LOCALHOST, common.PORT), "The address of the remote.")
+	peerPid := flag.Int("peer-pid", -1, "The process id of a remote connected over a Unix socket.")
+	peerUid := flag.Int("peer-uid", -1, "The user id of a remote connected over a Unix socket.")
+	peerGid := flag.Int("peer-gid", -1, "The group id of a remote connected over a Unix socket.")
+	subsystem := flag.String("subsystem", "", "Run this subsystem on stdin and stdout instead of hosting a connection.")
+	limits := flag.String("limits", "", "Execute the command after -- with these resource limits.")
+	credential := flag.String("as", "", "The uid:gid:groups to execute the command with resource limits as.")
+	dir := flag.String("dir", "", "The directory to execute the command with resource limits in.")
 
 	flag.Parse()
+	if *limits != "" {
+		// goshh starts itself this way as root to set the limits before the shell or subsystem of the user runs.
+		err := server.ExecLimited(*limits, *credential, *dir, flag.Args(), environment)
+		log.WithError(err).Fatalln("Failed to execute with resource limits.")
+	}
+	if *subsystem != "" {
+		// goshh starts itself this way as the user who logged in.
+		if err := server.RunSubsystem(*subsystem, os.Stdin, os.Stdout); err != nil {
+			log.WithError(err).Fatalln("Subsystem failed.")
+		}
+		return
+	}
 	log.WithFields(log.Fields{
 		"certFile":   *certFile,
 		"keyFile":    *keyFile,
 		"configPath": *configPath,
 		"authPath":   *authPath,
 		"fd":         *fd,
+		"controlFd":  *controlFd,
 		"rAddr":      *rAddr,
+		"peerPid":    *peerPid,
+		"peerUid":    *peerUid,
+		"peerGid":    *peerGid,
 	}).Debugln("Parsed arguments.")
 
 	config := server.LoadConfig(*configPath)
 	config.Set("Authentication.KeyStore", *authPath)
 	host := server.NewHost(config)
+	for _, inherited := range []uint{*fd, *controlFd} {
+		if inherited != 0 {
+			// Keep the connection and the control channel away from the user's shell.
+			unix.CloseOnExec(int(inherited))
+		}
+	}
+	if *controlFd != 0 {
+		host.SetControl(os.NewFile(uintptr(*controlFd), "control"))
+	}
 
 	go func() {
-		sigChan := make(chan os.Signal)
-		signal.Notify(sigChan, unix.SIGINT)
-		log.WithField("sig", (<-sigChan).String()).Warnln("Received signal. Shutting down.")
-		cleanup(host)
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, unix.SIGINT, unix.SIGTERM)
+		for sig := range sigChan {
+			if sig == unix.SIGTERM {
+				grace := time.Duration(config.GetInt("Serve.ShutdownGraceTime")) * time.Second
+				if host.Shutdown(grace) {
+					log.WithField("sig", sig.String()).Warnln("Received signal. Notified client about shutdown.")
+					continue
+				}
+			}
+			log.WithField("sig", sig.String()).Warnln("Received signal. Shutting down.")
+			cleanup(host)
+		}
 	}()
 
 	if err := host.LoadCertKeyPair(*certFile, *keyFile); err != nil {
 		log.WithError(err).Fatalln("Failed to prepare hosting.")
 	}
-	peerAddr, err := net.ResolveTCPAddr(common.TCP, *rAddr)
-	if err != nil {
-		log.WithError(err).Fatalln("Failed to resolve remote client address.")
+	var peerAddr net.Addr
+	if *peerUid >= 0 {
+		peerAddr = &net.UnixAddr{Name: *rAddr, Net: common.UNIX}
+		host.SetPeerCredentials(&unix.Ucred{Pid: int32(*peerPid), Uid: uint32(*peerUid), Gid: uint32(*peerGid)})
+	} else {
+		tcpAddr, err := net.ResolveTCPAddr(common.TCP, *rAddr)
+		if err != nil {
+			log.WithError(err).Fatalln("Failed to resolve remote client address.")
+		}
+		peerAddr = tcpAddr
 	}
 	if err := host.Connect(uintptr(*fd), peerAddr); err != nil {
 		log.WithError(err).Fatalln("Failed to prepare hosting.")
@@ -0,0 +1,40 @@
+package accounting
+
+import (
+	"net"
+	"time"
+)
+
+// Default locations of the accounting files.
+const (
+	UTMP    = "/var/run/utmp"
+	WTMP    = "/var/log/wtmp"
+	LASTLOG = "/var/log/lastlog"
+)
+
+// An Entry describes a session on a terminal line.
+type Entry struct {
+	Pid  int
+	Line string // The terminal without the /dev/ prefix, e.g. pts/3.
+	User string
+	Uid  uint32
+	Host string
+	Addr net.IP
+	Time time.Time
+}
+
+// A LastLogin is the previous login of a user as recorded in lastlog.
+type LastLogin struct {
+	Time time.Time
+	Line string
+	Host string
+}
+
+// Accounting records sessions so that who, last and lastlog know about them.
+type Accounting interface {
+	// Login records the start of the session in utmp and wtmp and returns the previous login of the user from
+	// lastlog, which then gets replaced by the session. The previous login is nil if there was none.
+	Login(entry Entry) (*LastLogin, error)
+	// Logout records the end of the session in utmp and wtmp.
+	Logout(entry Entry) error
+}
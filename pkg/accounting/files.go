@@ -0,0 +1,289 @@
+package accounting
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"time"
+)
+
+// Record types of utmp entries.
+const (
+	emptyRecord = 0
+	userProcess = 7
+	deadProcess = 8
+)
+
+// Sizes of the records and their fields.
+const (
+	utmpSize    = 384
+	lastlogSize = 292
+	lineSize    = 32
+	idSize      = 4
+	userSize    = 32
+	hostSize    = 256
+)
+
+// The records are laid out as glibc does on little-endian Linux, where the time fields are 32 bit wide even on 64 bit
+// systems.
+var byteOrder = binary.LittleEndian
+
+type utmpRecord struct {
+	Type    int16
+	_       int16
+	Pid     int32
+	Line    [lineSize]byte
+	Id      [idSize]byte
+	User    [userSize]byte
+	Host    [hostSize]byte
+	Exit    [2]int16
+	Session int32
+	Sec     int32
+	Usec    int32
+	Addr    [4]uint32
+	_       [20]byte
+}
+
+type lastlogRecord struct {
+	Time int32
+	Line [lineSize]byte
+	Host [hostSize]byte
+}
+
+// Files keeps the accounting in the utmp, wtmp and lastlog files. An empty path disables the file.
+type Files struct {
+	Utmp    string
+	Wtmp    string
+	Lastlog string
+}
+
+func NewFiles(utmp string, wtmp string, lastlog string) *Files {
+	log.WithFields(log.Fields{
+		"utmp":    utmp,
+		"wtmp":    wtmp,
+		"lastlog": lastlog,
+	}).Traceln("--> accounting.NewFiles")
+	return &Files{Utmp: utmp, Wtmp: wtmp, Lastlog: lastlog}
+}
+
+func (files Files) Login(entry Entry) (*LastLogin, error) {
+	log.WithField("entry", entry).Traceln("--> accounting.Files.Login")
+	ErrorMsg := "Failed to account login."
+	record := newUtmpRecord(userProcess, entry)
+	if err := files.writeUtmp(record); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	if err := files.appendWtmp(record); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	lastLogin, err := files.swapLastlog(entry)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"user": entry.User,
+		"line": entry.Line,
+	}).Infoln("Accounted login.")
+	return lastLogin, nil
+}
+
+func (files Files) Logout(entry Entry) error {
+	log.WithField("entry", entry).Traceln("--> accounting.Files.Logout")
+	ErrorMsg := "Failed to account logout."
+	// Logout records carry neither user nor host, which is how last tells them apart.
+	record := newUtmpRecord(deadProcess, Entry{Pid: entry.Pid, Line: entry.Line, Time: entry.Time})
+	if err := files.writeUtmp(record); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	if err := files.appendWtmp(record); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	log.WithFields(log.Fields{
+		"user": entry.User,
+		"line": entry.Line,
+	}).Infoln("Accounted logout.")
+	return nil
+}
+
+func newUtmpRecord(recordType int16, entry Entry) utmpRecord {
+	record := utmpRecord{
+		Type: recordType,
+		Pid:  int32(entry.Pid),
+		Sec:  int32(entry.Time.Unix()),
+		Usec: int32(entry.Time.Nanosecond() / 1000),
+	}
+	copy(record.Line[:], entry.Line)
+	// Like login and sshd, the id is made of the last characters of the line.
+	id := entry.Line
+	if len(id) > idSize {
+		id = id[len(id)-idSize:]
+	}
+	copy(record.Id[:], id)
+	copy(record.User[:], entry.User)
+	copy(record.Host[:], entry.Host)
+	// The address is kept in network byte order.
+	if ip4 := entry.Addr.To4(); ip4 != nil {
+		record.Addr[0] = byteOrder.Uint32(ip4)
+	} else if ip16 := entry.Addr.To16(); ip16 != nil {
+		for i := range record.Addr {
+			record.Addr[i] = byteOrder.Uint32(ip16[4*i:])
+		}
+	}
+	return record
+}
+
+// writeUtmp replaces the record of the same terminal line in utmp, or takes a free slot for it.
+func (files Files) writeUtmp(record utmpRecord) error {
+	log.WithField("line", cString(record.Line[:])).Traceln("--> accounting.Files.writeUtmp")
+	if files.Utmp == "" {
+		return nil
+	}
+	// Like glibc, leave utmp alone unless the system set it up at boot.
+	file, err := openLocked(files.Utmp, os.O_RDWR)
+	if os.IsNotExist(err) {
+		log.WithField("utmp", files.Utmp).Debugln("Skipped utmp, which does not exist.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer closeLocked(file)
+	slot, free := int64(-1), int64(-1)
+	for index := int64(0); ; index++ {
+		var existing utmpRecord
+		if err := binary.Read(file, byteOrder, &existing); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		if existing.Line == record.Line && (existing.Type == userProcess || existing.Type == deadProcess) {
+			slot = index
+			break
+		}
+		if free < 0 && (existing.Type == emptyRecord || existing.Type == deadProcess) {
+			free = index
+		}
+	}
+	if slot < 0 {
+		slot = free
+	}
+	if slot < 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		slot = info.Size() / utmpSize
+	}
+	return writeRecord(file, slot*utmpSize, record)
+}
+
+func (files Files) appendWtmp(record utmpRecord) error {
+	log.WithField("line", cString(record.Line[:])).Traceln("--> accounting.Files.appendWtmp")
+	if files.Wtmp == "" {
+		return nil
+	}
+	file, err := openLocked(files.Wtmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	defer closeLocked(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	// A torn record of an earlier writer would shift every record after it.
+	if size := info.Size(); size%utmpSize != 0 {
+		if err := file.Truncate(size - size%utmpSize); err != nil {
+			return err
+		}
+	}
+	return binary.Write(file, byteOrder, record)
+}
+
+// swapLastlog returns the previous login of the user and records the entry in its place.
+func (files Files) swapLastlog(entry Entry) (*LastLogin, error) {
+	log.WithField("uid", entry.Uid).Traceln("--> accounting.Files.swapLastlog")
+	if files.Lastlog == "" {
+		return nil, nil
+	}
+	file, err := openLocked(files.Lastlog, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLocked(file)
+	offset := int64(entry.Uid) * lastlogSize
+	var previous lastlogRecord
+	var lastLogin *LastLogin
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(file, byteOrder, &previous); err == nil && previous.Time != 0 {
+		lastLogin = &LastLogin{
+			Time: time.Unix(int64(previous.Time), 0),
+			Line: cString(previous.Line[:]),
+			Host: cString(previous.Host[:]),
+		}
+	} else if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	record := lastlogRecord{Time: int32(entry.Time.Unix())}
+	copy(record.Line[:], entry.Line)
+	copy(record.Host[:], entry.Host)
+	return lastLogin, writeRecord(file, offset, record)
+}
+
+// openLocked opens an accounting file for writing and takes the write lock on it. glibc and the login tools lock the
+// files with fcntl, which does not exclude flock.
+func openLocked(filePath string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(filePath, flag, 0664)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file, unix.F_WRLCK); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// lockFile sets a lock of the type on the whole file, waiting for conflicting locks to go away.
+func lockFile(file *os.File, lockType int16) error {
+	lock := unix.Flock_t{Type: lockType, Whence: io.SeekStart}
+	return unix.FcntlFlock(file.Fd(), unix.F_SETLKW, &lock)
+}
+
+func closeLocked(file *os.File) {
+	_ = lockFile(file, unix.F_UNLCK)
+	if err := file.Close(); err != nil {
+		log.WithError(err).WithField("file", file.Name()).Warnln("Failed to close accounting file.")
+	}
+}
+
+func writeRecord(file *os.File, offset int64, record interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, byteOrder, record); err != nil {
+		return err
+	}
+	n, err := file.WriteAt(buf.Bytes(), offset)
+	if err == nil && n != buf.Len() {
+		err = errors.New("short write")
+	}
+	return err
+}
+
+func cString(field []byte) string {
+	if nulIdx := bytes.IndexByte(field, 0); nulIdx >= 0 {
+		field = field[:nulIdx]
+	}
+	return string(field)
+}
@@ -0,0 +1,126 @@
+package accounting
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// newTestFiles returns files in a temporary directory. Only utmp exists, as the system creates it at boot.
+func newTestFiles(t *testing.T) *Files {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "utmp"), nil, 0664); err != nil {
+		t.Fatal(err)
+	}
+	return NewFiles(path.Join(dir, "utmp"), path.Join(dir, "wtmp"), path.Join(dir, "lastlog"))
+}
+
+func readUtmp(t *testing.T, filePath string) []utmpRecord {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []utmpRecord
+	for {
+		var record utmpRecord
+		if err := binary.Read(file, byteOrder, &record); err != nil {
+			return records
+		}
+		records = append(records, record)
+	}
+}
+
+func TestRecordSizes(t *testing.T) {
+	if size := binary.Size(utmpRecord{}); size != utmpSize {
+		t.Errorf("utmp record has %d bytes instead of %d.", size, utmpSize)
+	}
+	if size := binary.Size(lastlogRecord{}); size != lastlogSize {
+		t.Errorf("lastlog record has %d bytes instead of %d.", size, lastlogSize)
+	}
+}
+
+func TestFiles_LoginLogout(t *testing.T) {
+	files := newTestFiles(t)
+	entry := Entry{
+		Pid:  4242,
+		Line: "pts/7",
+		User: "alice",
+		Uid:  1000,
+		Host: "192.0.2.1",
+		Addr: net.IPv4(192, 0, 2, 1),
+		Time: time.Unix(1600000000, 0),
+	}
+	lastLogin, err := files.Login(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastLogin != nil {
+		t.Error("First login had a previous login.")
+	}
+	utmp := readUtmp(t, files.Utmp)
+	if len(utmp) != 1 || utmp[0].Type != userProcess || cString(utmp[0].User[:]) != "alice" ||
+		cString(utmp[0].Line[:]) != "pts/7" || cString(utmp[0].Id[:]) != "ts/7" || utmp[0].Pid != 4242 {
+		t.Fatalf("Unexpected utmp %+v.", utmp)
+	}
+	if addr := utmp[0].Addr[0]; net.IP([]byte{byte(addr), byte(addr >> 8), byte(addr >> 16), byte(addr >> 24)}).String() != "192.0.2.1" {
+		t.Error("Address is not in network byte order.")
+	}
+
+	entry.Time = entry.Time.Add(time.Hour)
+	if err := files.Logout(entry); err != nil {
+		t.Fatal(err)
+	}
+	utmp = readUtmp(t, files.Utmp)
+	if len(utmp) != 1 || utmp[0].Type != deadProcess || cString(utmp[0].User[:]) != "" {
+		t.Errorf("Login record was not replaced: %+v", utmp)
+	}
+	wtmp := readUtmp(t, files.Wtmp)
+	if len(wtmp) != 2 || wtmp[0].Type != userProcess || wtmp[1].Type != deadProcess {
+		t.Errorf("Unexpected wtmp %+v.", wtmp)
+	}
+
+	entry.Line = "pts/8"
+	lastLogin, err = files.Login(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastLogin == nil || lastLogin.Line != "pts/7" || lastLogin.Host != "192.0.2.1" || lastLogin.Time.Unix() != 1600000000 {
+		t.Errorf("Unexpected previous login %+v.", lastLogin)
+	}
+	if utmp = readUtmp(t, files.Utmp); len(utmp) != 1 || cString(utmp[0].Line[:]) != "pts/8" {
+		t.Errorf("Free utmp slot was not reused: %+v", utmp)
+	}
+	if info, err := os.Stat(files.Lastlog); err != nil || info.Size() != 1001*lastlogSize {
+		t.Error("lastlog record is not at the offset of the uid.")
+	}
+}
+
+func TestFiles_Disabled(t *testing.T) {
+	files := NewFiles("", "", "")
+	if _, err := files.Login(Entry{Line: "pts/1", User: "alice"}); err != nil {
+		t.Error(err)
+	}
+	if err := files.Logout(Entry{Line: "pts/1"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFiles_MissingUtmp(t *testing.T) {
+	files := newTestFiles(t)
+	if err := os.Remove(files.Utmp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files.Login(Entry{Pid: 4242, Line: "pts/1", User: "alice", Time: time.Unix(1600000000, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(files.Utmp); !os.IsNotExist(err) {
+		t.Errorf("Created utmp: %v", err)
+	}
+	if wtmp := readUtmp(t, files.Wtmp); len(wtmp) != 1 {
+		t.Errorf("Got %d wtmp records.", len(wtmp))
+	}
+}
@@ -1,7 +1,6 @@
 package client
 
 import (
-	"bufio"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -9,39 +8,70 @@ import (
 	"github.com/spf13/viper"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/connection"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
 	"io"
 	"net"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// ErrRejected is returned when the server rejected the connection, which makes retrying pointless.
+var ErrRejected = errors.New("rejected by server")
+
 type Client struct {
-	config *viper.Viper
-	rUri   *url.URL
+	config   *viper.Viper
+	rUri     *url.URL
+	session  string // The token to resume the session, if the server keeps it resumable.
+	received int64  // How much output of the session got received.
+	suspend  func()
+	// The server multiplexes channels over the connection once the transfer is done.
+	multiplexed   bool
+	channels      *mux.Session
+	channelsMutex *sync.Mutex // Guards channels, which forwarded connections open their channels on.
+	forwards      []*portForward
 }
 
 func NewClient(config *viper.Viper) *Client {
 	log.WithField("config", config).Traceln("--> client.NewClient")
-	return &Client{config: config}
+	return &Client{config: config, channelsMutex: &sync.Mutex{}}
 }
 
 func (client Client) Dial() (net.Conn, error) {
 	log.Traceln("--> client.Client.Dial")
 	address := client.rUri
+	host := address.Host
+	if isUnixScheme(address.Scheme) {
+		host = address.Path
+	}
 	log.WithFields(log.Fields{
 		"scheme": address.Scheme,
-		"host":   address.Host,
+		"host":   host,
 	}).Infoln("Dialing server.")
-	//TODO: Don't skip unsecure certificates. Ask the user instead.
-	tlsConfig := &tls.Config{InsecureSkipVerify: true}
-	conn, err := tls.Dial(address.Scheme, address.Host, tlsConfig)
-	//conn, err := net.Dial(rUri.Scheme, rUri.Host)
+	tlsConfig, err := client.tlsConfig(host)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to set up TLS.")
+		return nil, err
+	}
+	rawConn, err := client.dialTransport(address.Scheme, host)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"protocol": address.Scheme,
-			"host":     address.Host,
+			"host":     host,
+			"error":    err.Error(),
+		}).Errorln("Failed to connect to host.")
+		return nil, err
+	}
+	conn := tls.Client(rawConn, tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		utils.CloseConn(rawConn)
+		log.WithFields(log.Fields{
+			"protocol": address.Scheme,
+			"host":     host,
 			"error":    err.Error(),
 		}).Errorln("Failed to connect to host.")
 		return nil, err
@@ -50,14 +80,47 @@ func (client Client) Dial() (net.Conn, error) {
 	return conn, nil
 }
 
-func (client Client) PerformTransfer(in io.Reader, out io.Writer) error {
+// dialTransport connects to the server, through Client.ProxyCommand if one is set for a TCP server.
+func (client Client) dialTransport(scheme string, host string) (net.Conn, error) {
+	command := client.config.GetString("Client.ProxyCommand")
+	if command == "" || isUnixScheme(scheme) {
+		return net.Dial(scheme, host)
+	}
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	return dialProxy(command, hostname, port)
+}
+
+// DialSubsystem connects to the server and has it run the subsystem with the name instead of a shell. The connection
+// belongs to the subsystem once it is returned.
+func (client *Client) DialSubsystem(name string) (net.Conn, error) {
+	log.WithField("name", name).Traceln("--> client.Client.DialSubsystem")
+	client.config.Set("Client.Subsystem", name)
+	if err := client.Setup(); err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.PerformTransfer(conn, conn); err != nil {
+		utils.CloseConn(conn)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (client *Client) PerformTransfer(in io.Reader, out io.Writer) error {
 	log.WithFields(log.Fields{
 		"in":  &in,
 		"out": &out,
 	}).Traceln("--> client.PerformTransfer")
-	bIn := bufio.NewReader(in)
+	client.multiplexed = false
 	for {
-		str, err := bIn.ReadString('\n')
+		// The session follows right after the last packet, so nothing may be read ahead.
+		str, err := readLine(in)
 		if err != nil {
 			log.WithError(err).Errorln("Failed to read from server.")
 			return err
@@ -70,12 +133,27 @@ func (client Client) PerformTransfer(in io.Reader, out io.Writer) error {
 			return err
 		}
 		if packet.Done() {
+			if reject, ok := packet.(connection.RejectPacket); ok {
+				return fmt.Errorf("%w: %s", ErrRejected, reject.Ask(in, out).Error())
+			}
 			return nil
 		}
 		switch pckt := packet.(type) {
+		case connection.BannerPacket:
+			err = pckt.Ask(in, os.Stderr)
+		case connection.SessionPacket:
+			log.Debugln("Server keeps the session resumable.")
+			client.session = pckt.Token
+			atomic.StoreInt64(&client.received, pckt.Offset)
+		case connection.MuxPacket:
+			log.Debugln("Server multiplexes the connection.")
+			client.multiplexed = true
 		case connection.RsaPacket:
 			log.Debugln("Detected RSA packet.")
-			pckt.KeyPath = client.config.GetString("Authentication.KeyStore")
+			pckt.KeyPath = expandHome(client.config.GetString("Authentication.KeyStore"))
+			if keyFile := client.config.GetString("Client.KeyFile"); keyFile != "" {
+				pckt.KeyFile = expandHome(keyFile)
+			}
 			err = pckt.Ask(in, out)
 		default:
 			err = pckt.Ask(os.Stdin, out)
@@ -134,11 +212,20 @@ func (client *Client) ParseArgument(arg string) error {
 	if arg == "" {
 		arg = fmt.Sprintf("%s:%d", common.LOCALHOST, client.config.GetInt("Client.Port"))
 	}
-	rUri, err := url.ParseRequestURI("tcp://" + arg)
+	if !strings.HasPrefix(arg, common.UNIX+"://") && !strings.HasPrefix(arg, common.UNIXPACKET+"://") {
+		arg = "tcp://" + arg
+	}
+	rUri, err := url.ParseRequestURI(arg)
 	if err != nil {
 		log.WithError(err).Errorln(ErrorMsg)
 		return err
 	}
+	if !isUnixScheme(rUri.Scheme) {
+		if err = client.applyHost(rUri); err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			return err
+		}
+	}
 	if err = client.checkUrl(rUri); err != nil {
 		log.WithError(err).Errorln(ErrorMsg)
 		return err
@@ -149,6 +236,31 @@ func (client *Client) ParseArgument(arg string) error {
 	return nil
 }
 
+// applyHost applies the [[Host]] blocks matching the host as given, which may be an alias for the one in HostName.
+func (client *Client) applyHost(rUri *url.URL) error {
+	log.WithField("rUri", rUri).Traceln("--> client.Client.applyHost")
+	settings, err := hostSettings(client.config, rUri.Hostname())
+	if err != nil {
+		return err
+	}
+	if err := mergeHostOptions(client.config, settings); err != nil {
+		return err
+	}
+	if hostName, ok := settings["hostname"].(string); ok && hostName != "" {
+		if port := rUri.Port(); port != "" {
+			rUri.Host = net.JoinHostPort(hostName, port)
+		} else if strings.Contains(hostName, ":") {
+			rUri.Host = "[" + hostName + "]"
+		} else {
+			rUri.Host = hostName
+		}
+	}
+	if user, ok := settings["user"].(string); ok && user != "" && rUri.User == nil {
+		rUri.User = url.User(user)
+	}
+	return nil
+}
+
 func (client *Client) checkUrl(rUri *url.URL) error {
 	log.WithField("rUri", rUri).Traceln("--> client.Client.checkUrl")
 	ErrorMsg := "Failed to check url"
@@ -172,6 +284,14 @@ func (client *Client) checkUrl(rUri *url.URL) error {
 		log.WithError(err).WithField("RawQuery", rUri.RawQuery).Errorln(ErrorMsg)
 		return err
 	}
+	if isUnixScheme(rUri.Scheme) {
+		if rUri.Host != "" || rUri.Path == "" {
+			err := errors.New("unix address needs a socket path and no host")
+			log.WithError(err).WithField("rUri", rUri).Errorln(ErrorMsg)
+			return err
+		}
+		return nil
+	}
 	if rUri.Port() == "" {
 		newUri, _ := url.ParseRequestURI(fmt.Sprintf("%s:%d", rUri.String(), client.config.GetInt("Client.Port")))
 		rUri.Host = newUri.Host
@@ -180,6 +300,10 @@ func (client *Client) checkUrl(rUri *url.URL) error {
 	return nil
 }
 
+func isUnixScheme(scheme string) bool {
+	return scheme == common.UNIX || scheme == common.UNIXPACKET
+}
+
 func (client Client) Setup() error {
 	log.Traceln("--> client.Client.Setup")
 	if err := os.Setenv(common.ENV_GOSH_USER, client.rUri.User.Username()); err != nil {
@@ -193,6 +317,86 @@ func (client Client) Setup() error {
 			return err
 		}
 	}
+	// A session only gets resumed on reconnects.
+	if err := os.Unsetenv(common.ENV_GOSH_SESSION); err != nil {
+		log.WithError(err).Errorln(fmt.Sprintf("Failed to unset %s environment variable.", common.ENV_GOSH_SESSION))
+		return err
+	}
+	if err := os.Setenv(common.ENV_GOSH_ATTACH, client.config.GetString("Client.Attach")); err != nil {
+		log.WithError(err).Errorln(fmt.Sprintf("Failed to set %s environment variable.", common.ENV_GOSH_ATTACH))
+		return err
+	}
+	if _, err := parseEscapeChar(client.config.GetString("Client.EscapeChar")); err != nil {
+		log.WithError(err).Errorln("Failed to set up escapes.")
+		return err
+	}
+	if err := os.Setenv(common.ENV_GOSH_SUBSYSTEM, client.config.GetString("Client.Subsystem")); err != nil {
+		log.WithError(err).Errorln(fmt.Sprintf("Failed to set %s environment variable.", common.ENV_GOSH_SUBSYSTEM))
+		return err
+	}
+	if err := os.Setenv(common.ENV_GOSH_MUX, "1"); err != nil {
+		log.WithError(err).Errorln(fmt.Sprintf("Failed to set %s environment variable.", common.ENV_GOSH_MUX))
+		return err
+	}
+	if err := checkTrustPolicy(client.config.GetString("Client.TrustPolicy")); err != nil {
+		log.WithError(err).Errorln("Failed to set up TLS.")
+		return err
+	}
+	if _, err := client.configuredForwards(); err != nil {
+		log.WithError(err).Errorln("Failed to set up forwarding.")
+		return err
+	}
+	share, err := client.shareRequest()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to set up sharing.")
+		return err
+	}
+	if err := os.Setenv(common.ENV_GOSH_SHARE, share); err != nil {
+		log.WithError(err).Errorln(fmt.Sprintf("Failed to set %s environment variable.", common.ENV_GOSH_SHARE))
+		return err
+	}
 	//TODO: Load the public key and set it as environment variable.
 	return nil
 }
+
+// shareRequest builds the request to join the session in Client.Join, or to invite the user in Client.Invite, given as
+// user[:ro|:rw|:none], to the session in Client.Session.
+func (client Client) shareRequest() (string, error) {
+	if join := client.config.GetString("Client.Join"); join != "" {
+		return "join " + join, nil
+	}
+	invite := client.config.GetString("Client.Invite")
+	if invite == "" {
+		return "", nil
+	}
+	user, mode := invite, "ro"
+	if colonIdx := strings.LastIndex(invite, ":"); colonIdx >= 0 {
+		user, mode = invite[:colonIdx], invite[colonIdx+1:]
+	}
+	if user == "" || strings.ContainsAny(user, " \t") {
+		return "", fmt.Errorf("invalid user %q", user)
+	}
+	if mode != "ro" && mode != "rw" && mode != "none" {
+		return "", fmt.Errorf("access mode has to be either ro, rw or none, not %q", mode)
+	}
+	session := client.config.GetString("Client.Session")
+	if session == "" {
+		return "", errors.New("no session to invite to")
+	}
+	return fmt.Sprintf("invite %s %s %s", session, user, mode), nil
+}
+
+// readLine reads up to and including the next newline byte by byte.
+func readLine(in io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(in, b); err != nil {
+			return "", err
+		}
+		line = append(line, b[0])
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +66,24 @@ func TestClient_ParseArgument_No_Nothing(t *testing.T) {
 	checkUrl(t, clnt.rUri, "", "", "localhost", 2222)
 }
 
+func TestClient_ParseArgument_Unix(t *testing.T) {
+	clnt := NewClient(config)
+	if err := clnt.ParseArgument("unix://test@/run/goshd.sock"); err != nil {
+		t.FailNow()
+	}
+	checkUrl(t, clnt.rUri, "test", "", "", 0)
+	if clnt.rUri.Scheme != common.UNIX || clnt.rUri.Path != "/run/goshd.sock" {
+		t.Error(fmt.Sprintf("Unix address mismatch: %s", clnt.rUri))
+	}
+}
+
+func TestClient_ParseArgument_Unix_No_Path(t *testing.T) {
+	clnt := NewClient(config)
+	if err := clnt.ParseArgument("unix://localhost"); err == nil {
+		t.Error("Unix address without a path got accepted.")
+	}
+}
+
 func checkUrl(t *testing.T, url *url.URL, user string, password string, host string, port uint) {
 	if user != "" {
 		uUser := url.User.Username()
@@ -116,3 +137,49 @@ func TestClient_Setup(t *testing.T) {
 	_ = os.Unsetenv(common.ENV_GOSH_USER)
 	_ = os.Unsetenv(common.ENV_GOSH_PASSWORD)
 }
+
+func TestClient_PerformTransfer_Session(t *testing.T) {
+	clnt := NewClient(config)
+	in := strings.NewReader("?S:0123456789abcdef:secret:42\n?D1:\nshell output")
+	if err := clnt.PerformTransfer(in, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if clnt.session != "0123456789abcdef:secret" || clnt.received != 42 {
+		t.Errorf("Got session token %q at offset %d.", clnt.session, clnt.received)
+	}
+	if rest, _ := ioutil.ReadAll(in); string(rest) != "shell output" {
+		t.Errorf("Read ahead into the session, %q is left.", rest)
+	}
+}
+
+func TestClient_PerformTransfer_Reject(t *testing.T) {
+	clnt := NewClient(config)
+	err := clnt.PerformTransfer(strings.NewReader("?R:too many sessions\n"), ioutil.Discard)
+	if !errors.Is(err, ErrRejected) {
+		t.Errorf("Got %v instead of a rejection.", err)
+	}
+}
+
+func TestClient_ShareRequest(t *testing.T) {
+	clnt := NewClient(LoadConfig(""))
+	clnt.config.Set("Client.Invite", "bob:rw")
+	if _, err := clnt.shareRequest(); err == nil {
+		t.Error("Invited without a session.")
+	}
+	clnt.config.Set("Client.Session", "0123456789abcdef")
+	if share, err := clnt.shareRequest(); err != nil || share != "invite 0123456789abcdef bob rw" {
+		t.Errorf("Got %q: %v", share, err)
+	}
+	clnt.config.Set("Client.Invite", "bob")
+	if share, err := clnt.shareRequest(); err != nil || share != "invite 0123456789abcdef bob ro" {
+		t.Errorf("Got %q: %v", share, err)
+	}
+	clnt.config.Set("Client.Invite", "bob:admin")
+	if _, err := clnt.shareRequest(); err == nil {
+		t.Error("Invited with an unknown access mode.")
+	}
+	clnt.config.Set("Client.Join", "0123456789abcdef")
+	if share, err := clnt.shareRequest(); err != nil || share != "join 0123456789abcdef" {
+		t.Errorf("Got %q: %v", share, err)
+	}
+}
@@ -1,39 +1,62 @@
 package client
 
 import (
-	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"os"
 )
 
 func setDefaults(config *viper.Viper) {
 	log.WithField("config", config).Traceln("--> client.setDefaults")
 	config.SetDefault("Client.Port", common.PORT)
 	config.SetDefault("Client.Protocol", common.TCP)
+	config.SetDefault("Client.Resume", true)
+	config.SetDefault("Client.ResumeTimeout", 600)
+	config.SetDefault("Client.EscapeChar", defaultEscapeChar)
+	config.SetDefault("Client.Attach", "")
+	config.SetDefault("Client.Join", "")
+	config.SetDefault("Client.Invite", "")
+	config.SetDefault("Client.Session", "")
+	config.SetDefault("Client.LocalForward", []string{})
+	config.SetDefault("Client.RemoteForward", []string{})
+	config.SetDefault("Client.DynamicForward", []string{})
+	config.SetDefault("Client.Subsystem", "")
+	config.SetDefault("Client.KeyFile", "")
+	config.SetDefault("Client.ProxyCommand", "")
+	config.SetDefault("Client.TrustPolicy", trustNone)
+	config.SetDefault("Client.KnownHosts", "~/.gosh/known_hosts")
 	config.SetDefault("Logging.LogLevel", "info")
 	config.SetDefault("Authentication.KeyStore", "~/.gosh")
 }
 
+// LoadConfig reads the system config in configpath and merges the config of the user in common.USERCONFIG over it.
 func LoadConfig(configpath string) *viper.Viper {
 	log.WithField("configpath", configpath).Traceln("--> client.LoadConfig")
+	return loadConfig(configpath, expandHome(common.USERCONFIG))
+}
+
+func loadConfig(configpath string, userConfigPath string) *viper.Viper {
+	log.WithFields(log.Fields{
+		"configpath":     configpath,
+		"userConfigPath": userConfigPath,
+	}).Traceln("--> client.loadConfig")
 	config := viper.New()
 	config.SetConfigName(common.CLIENTNAME + "_config")
 	config.AddConfigPath(configpath)
 	config.SetConfigType(common.CONFIGFORMAT)
 	setDefaults(config)
-	err := config.ReadInConfig()
-	if err != nil {
+	if err := config.ReadInConfig(); err != nil {
 		log.WithFields(log.Fields{
 			"error": err.Error(),
 		}).Warnln("Failed to read config file.")
-		return config
 	}
-	config.WatchConfig()
-	config.OnConfigChange(func(e fsnotify.Event) {
+	// The config is not watched for changes, since reading it again would drop the config of the user.
+	if err := mergeUserConfig(config, userConfigPath); err != nil && !os.IsNotExist(err) {
 		log.WithFields(log.Fields{
-			"name": e.Name,
-		}).Warnln("LoadConfig file changed.")
-	})
+			"path":  userConfigPath,
+			"error": err.Error(),
+		}).Warnln("Failed to read user config file.")
+	}
 	return config
 }
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"fmt"
+)
+
+// The default escape character. Escapes are only recognized at the beginning of a line.
+const defaultEscapeChar = "~"
+
+// Escape commands, typed after the escape character.
+const (
+	escapeDisconnect = '.'
+	escapeSuspend    = 0x1a // ^Z
+	escapeForwards   = '#'
+	escapeInfo       = '?'
+	escapeDetach     = 'd'
+)
+
+var escapeCommands = []struct {
+	command     byte
+	description string
+}{
+	{escapeDisconnect, "disconnect"},
+	{escapeSuspend, "suspend gosh"},
+	{escapeForwards, "list forwarded connections"},
+	{escapeDetach, "detach from the session"},
+	{escapeInfo, "show this session information"},
+}
+
+// An escapeFilter picks escape commands out of the input to the session. Typing the escape character twice sends it
+// once, followed by anything else that is no command it gets sent along with the character. A filter without an
+// escape character lets all the input through.
+type escapeFilter struct {
+	char        byte
+	atLineStart bool
+	escaped     bool // The escape character was typed at the beginning of a line.
+}
+
+func newEscapeFilter(char byte) *escapeFilter {
+	return &escapeFilter{char: char, atLineStart: true}
+}
+
+// parseEscapeChar parses the escape character, which is either a single character, a control character written as ^
+// followed by a letter, or none to disable escapes, which gives 0.
+func parseEscapeChar(str string) (byte, error) {
+	switch {
+	case str == "none":
+		return 0, nil
+	case len(str) == 1 && str[0] > ' ' && str[0] < 0x7f:
+		return str[0], nil
+	case len(str) == 2 && str[0] == '^' && str[1] >= '@' && str[1] <= '_':
+		return str[1] & 0x1f, nil
+	case len(str) == 2 && str[0] == '^' && str[1] >= 'a' && str[1] <= 'z':
+		return str[1] & 0x1f, nil
+	}
+	return 0, fmt.Errorf("invalid escape character %q", str)
+}
+
+// Filter returns the input to send up to the first escape command, the command, and the input after it that still
+// needs filtering. The command is 0 if there was none.
+func (filter *escapeFilter) Filter(input []byte) ([]byte, byte, []byte) {
+	if filter.char == 0 {
+		return input, 0, nil
+	}
+	output := make([]byte, 0, len(input))
+	for i, b := range input {
+		if filter.escaped {
+			filter.escaped = false
+			if isEscapeCommand(b) {
+				filter.atLineStart = false
+				return output, b, input[i+1:]
+			}
+			if b != filter.char {
+				output = append(output, filter.char)
+			}
+		} else if filter.atLineStart && b == filter.char {
+			filter.escaped = true
+			continue
+		}
+		output = append(output, b)
+		filter.atLineStart = b == '\r' || b == '\n'
+	}
+	return output, 0, nil
+}
+
+// Help describes the escape commands.
+func (filter *escapeFilter) Help() []string {
+	if filter.char == 0 {
+		return []string{"Escapes are disabled."}
+	}
+	help := []string{"Supported escape sequences:"}
+	for _, escape := range escapeCommands {
+		help = append(help, fmt.Sprintf(" %s%s - %s", printableChar(filter.char), printableChar(escape.command),
+			escape.description))
+	}
+	help = append(help, fmt.Sprintf(" %s%s - send the escape character by typing it twice",
+		printableChar(filter.char), printableChar(filter.char)))
+	return append(help, "(Escapes are only recognized at the beginning of a line.)")
+}
+
+func isEscapeCommand(b byte) bool {
+	for _, escape := range escapeCommands {
+		if escape.command == b {
+			return true
+		}
+	}
+	return false
+}
+
+func printableChar(b byte) string {
+	if b < ' ' {
+		return "^" + string(b|0x40)
+	}
+	return string(b)
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeFilter_Filter(t *testing.T) {
+	filter := newEscapeFilter('~')
+	output, command, rest := filter.Filter([]byte("a~d\r~~d\r~x"))
+	if string(output) != "a~d\r~d\r~x" || command != 0 || rest != nil {
+		t.Errorf("Got %q, command %q, rest %q.", output, command, rest)
+	}
+	output, command, rest = filter.Filter([]byte("\r~"))
+	if string(output) != "\r" || command != 0 {
+		t.Errorf("Got %q, command %q.", output, command)
+	}
+	// The escape character carries over to the next input.
+	output, command, rest = filter.Filter([]byte("dls\r"))
+	if len(output) != 0 || command != escapeDetach || string(rest) != "ls\r" {
+		t.Errorf("Got %q, command %q, rest %q.", output, command, rest)
+	}
+	output, command, rest = filter.Filter([]byte("\n~\x1a"))
+	if string(output) != "\n" || command != escapeSuspend || len(rest) != 0 {
+		t.Errorf("Got %q, command %q, rest %q.", output, command, rest)
+	}
+}
+
+func TestEscapeFilter_Filter_None(t *testing.T) {
+	filter := newEscapeFilter(0)
+	if output, command, _ := filter.Filter([]byte("~.")); string(output) != "~." || command != 0 {
+		t.Errorf("Got %q, command %q.", output, command)
+	}
+	if help := filter.Help(); len(help) != 1 {
+		t.Errorf("Got help %q.", help)
+	}
+}
+
+func TestParseEscapeChar(t *testing.T) {
+	for str, expected := range map[string]byte{"~": '~', "^]": 0x1d, "^a": 0x01, "none": 0} {
+		if char, err := parseEscapeChar(str); err != nil || char != expected {
+			t.Errorf("Parsed %q as %q: %v", str, char, err)
+		}
+	}
+	for _, str := range []string{"", " ", "~~", "\t", "^1"} {
+		if _, err := parseEscapeChar(str); err == nil {
+			t.Errorf("Parsed invalid escape character %q.", str)
+		}
+	}
+	if help := newEscapeFilter(0x1d).Help(); !strings.HasPrefix(help[1], " ^]. - ") {
+		t.Errorf("Got help %q.", help)
+	}
+}
@@ -0,0 +1,386 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How long dialing the destination of a connection the server forwards may take.
+const forwardDialTimeout = 10 * time.Second
+
+// Kinds of forwards, named after their flags.
+const (
+	localForward   = "L" // Listens here, the server dials the destination.
+	remoteForward  = "R" // Listens on the server, the destination gets dialed here.
+	dynamicForward = "D" // Listens here as a SOCKS proxy, the server dials the destinations the SOCKS clients ask for.
+)
+
+// A portForward forwards connections accepted on the bind address to the destination.
+type portForward struct {
+	kind               string
+	bind               string       // The address or Unix socket to listen on.
+	bindNetwork        string       // Either common.TCP or common.UNIX.
+	destination        string       // The host:port or Unix socket to dial. Dynamic forwards have none.
+	destinationNetwork string       // Either common.TCP or common.UNIX.
+	listener           net.Listener // Listens for local forwards.
+	listening          int32        // Whether the server listens for a remote forward.
+	open               int32        // Connections forwarded right now.
+}
+
+// parseForward parses a forward given as [bind:]port:host:hostport. IPv6 addresses go in brackets. Without a bind
+// address, the forward listens on localhost only. A bind address of * or an empty one listens on all interfaces. Either
+// [bind:]port or host:hostport may be the path of a Unix socket instead, which has to contain a slash and must not
+// contain colons or whitespace.
+func parseForward(spec string, kind string) (*portForward, error) {
+	log.WithFields(log.Fields{
+		"spec": spec,
+		"kind": kind,
+	}).Traceln("--> client.parseForward")
+	expected := "[bind:]port:host:hostport"
+	if kind == dynamicForward {
+		expected = "[bind:]port"
+	}
+	parts := splitForwardSpec(spec)
+	forward := &portForward{kind: kind}
+	if kind != dynamicForward {
+		destination, network, err := parseForwardEndpoint(spec, parts[len(parts)-1:])
+		if err != nil && len(parts) > 1 {
+			destination, network, err = parseForwardEndpoint(spec, parts[len(parts)-2:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid forward %q, expected %s", spec, expected)
+		}
+		if network == common.TCP && strings.HasPrefix(destination, ":") {
+			return nil, fmt.Errorf("forward %q has no destination host", spec)
+		}
+		if network == common.UNIX {
+			parts = parts[:len(parts)-1]
+		} else {
+			parts = parts[:len(parts)-2]
+		}
+		forward.destination, forward.destinationNetwork = destination, network
+	}
+	if len(parts) == 1 && !isSocketPath(parts[0]) {
+		parts = append([]string{common.LOCALHOST}, parts...)
+	}
+	bind, network, err := parseForwardEndpoint(spec, parts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid forward %q, expected %s", spec, expected)
+	}
+	forward.bind, forward.bindNetwork = bind, network
+	return forward, nil
+}
+
+// parseForwardEndpoint parses the parts of a forward that are either host and port or a Unix socket path. An empty
+// host or one of * stands for all interfaces.
+func parseForwardEndpoint(spec string, parts []string) (string, string, error) {
+	if len(parts) == 1 && isSocketPath(parts[0]) {
+		return parts[0], common.UNIX, nil
+	}
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid forward %q", spec)
+	}
+	host := strings.Trim(parts[0], "[]")
+	if host == "*" {
+		host = ""
+	}
+	if n, err := strconv.Atoi(parts[1]); err != nil || n <= 0 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q in forward %q", parts[1], spec)
+	}
+	return net.JoinHostPort(host, parts[1]), common.TCP, nil
+}
+
+// isSocketPath tells whether a part of a forward is the path of a Unix socket rather than a host or port.
+func isSocketPath(part string) bool {
+	return strings.Contains(part, "/") && !strings.ContainsAny(part, "[] \t\r\n")
+}
+
+// splitForwardSpec splits at the colons outside of brackets.
+func splitForwardSpec(spec string) []string {
+	var parts []string
+	start, bracketed := 0, false
+	for i, r := range spec {
+		switch {
+		case r == '[':
+			bracketed = true
+		case r == ']':
+			bracketed = false
+		case r == ':' && !bracketed:
+			parts = append(parts, spec[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, spec[start:])
+}
+
+// configuredForwards parses the forwards in Client.LocalForward, Client.RemoteForward and Client.DynamicForward.
+func (client Client) configuredForwards() ([]*portForward, error) {
+	var forwards []*portForward
+	for _, kind := range []string{localForward, remoteForward, dynamicForward} {
+		key := map[string]string{
+			localForward:   "Client.LocalForward",
+			remoteForward:  "Client.RemoteForward",
+			dynamicForward: "Client.DynamicForward",
+		}[kind]
+		for _, spec := range client.config.GetStringSlice(key) {
+			forward, err := parseForward(spec, kind)
+			if err != nil {
+				return nil, err
+			}
+			forwards = append(forwards, forward)
+		}
+	}
+	return forwards, nil
+}
+
+// startForwards starts listening for the connections of local and dynamic forwards and asks the server to listen for
+// those of remote forwards. Forwards that cannot listen get skipped.
+func (client *Client) startForwards(out io.Writer) error {
+	log.Traceln("--> client.Client.startForwards")
+	forwards, err := client.configuredForwards()
+	if err != nil {
+		log.WithError(err).Errorln("Failed to start forwarding.")
+		return err
+	}
+	if len(forwards) > 0 && client.channels == nil {
+		err := errors.New("server does not support forwarding")
+		log.WithError(err).Warnln("Failed to start forwarding.")
+		return err
+	}
+	for _, forward := range forwards {
+		if forward.kind == remoteForward {
+			client.forwards = append(client.forwards, forward)
+			continue
+		}
+		forward.listener, err = listenForward(forward)
+		if err != nil {
+			log.WithError(err).WithField("bind", forward.bind).Warnln("Failed to listen for forwarded connections.")
+			_, _ = fmt.Fprintf(out, "gosh: Cannot forward %s: %s.\r\n", forward.bind, err.Error())
+			continue
+		}
+		log.WithFields(log.Fields{
+			"bind":        forward.listener.Addr(),
+			"destination": forward.destination,
+		}).Infoln("Forwarding connections.")
+		client.forwards = append(client.forwards, forward)
+		go client.acceptForwards(forward)
+	}
+	client.requestRemoteForwards(out)
+	return nil
+}
+
+// listenForward listens on the bind address of the forward. A stale socket in the way of a Unix socket gets removed,
+// the new one is accessible by the user only.
+func listenForward(forward *portForward) (net.Listener, error) {
+	if forward.bindNetwork != common.UNIX {
+		return net.Listen(common.TCP, forward.bind)
+	}
+	if err := utils.RemoveStaleSocket(forward.bind); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen(common.UNIX, forward.bind)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(forward.bind, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// requestRemoteForwards asks the server to listen for the remote forwards on the current connection and serves the
+// connections it forwards. The server listens as long as the channel of the request is open, so a new connection needs
+// new requests.
+func (client *Client) requestRemoteForwards(out io.Writer) {
+	log.Traceln("--> client.Client.requestRemoteForwards")
+	if client.channels != nil {
+		go serveChannels(client.channels, client.forwards)
+	}
+	for _, forward := range client.forwards {
+		if forward.kind != remoteForward {
+			continue
+		}
+		atomic.StoreInt32(&forward.listening, 0)
+		if client.channels == nil {
+			continue
+		}
+		kind := common.CHANNEL_TCPIP_FORWARD
+		if forward.bindNetwork == common.UNIX {
+			kind = common.CHANNEL_STREAMLOCAL_FORWARD
+		}
+		if _, err := client.channels.Open(kind, []byte(forward.bind)); err != nil {
+			log.WithError(err).WithField("bind", forward.bind).Warnln("Server refused to listen for forwarding.")
+			_, _ = fmt.Fprintf(out, "gosh: Cannot forward %s on the server: %s.\r\n", forward.bind, err.Error())
+			continue
+		}
+		atomic.StoreInt32(&forward.listening, 1)
+		log.WithFields(log.Fields{
+			"bind":        forward.bind,
+			"destination": forward.destination,
+		}).Infoln("Server forwards connections.")
+	}
+}
+
+// stopForwards stops listening. Connections forwarded already end along with the connection to the server.
+func (client *Client) stopForwards() {
+	log.Traceln("--> client.Client.stopForwards")
+	for _, forward := range client.forwards {
+		if forward.listener != nil {
+			_ = forward.listener.Close()
+		}
+	}
+	client.forwards = nil
+}
+
+func (client *Client) acceptForwards(forward *portForward) {
+	for {
+		conn, err := forward.listener.Accept()
+		if err != nil {
+			log.WithError(err).WithField("bind", forward.bind).Debugln("Stopped accepting forwarded connections.")
+			return
+		}
+		if forward.kind == dynamicForward {
+			go client.forwardSocks(forward, conn)
+		} else {
+			go client.forwardLocal(forward, conn)
+		}
+	}
+}
+
+// forwardLocal opens a channel to the destination of the forward on the current connection and relays conn to it.
+func (client *Client) forwardLocal(forward *portForward, conn net.Conn) {
+	fields := log.Fields{
+		"originator":  conn.RemoteAddr(),
+		"destination": forward.destination,
+	}
+	log.WithFields(fields).Traceln("--> client.Client.forwardLocal")
+	channel, err := client.openDirect(forward.destinationNetwork, forward.destination, conn.RemoteAddr())
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln("Failed to forward connection.")
+		_ = conn.Close()
+		return
+	}
+	atomic.AddInt32(&forward.open, 1)
+	defer atomic.AddInt32(&forward.open, -1)
+	log.WithFields(fields).Debugln("Forwarding connection.")
+	mux.Relay(channel, conn)
+}
+
+// openDirect opens a channel to the destination on the network, which the server dials, on the current connection.
+func (client *Client) openDirect(network string, destination string, originator net.Addr) (*mux.Channel, error) {
+	client.channelsMutex.Lock()
+	channels := client.channels
+	client.channelsMutex.Unlock()
+	if channels == nil {
+		return nil, errors.New("server does not support forwarding")
+	}
+	kind := common.CHANNEL_DIRECT_TCPIP
+	if network == common.UNIX {
+		kind = common.CHANNEL_DIRECT_STREAMLOCAL
+	}
+	data := destination
+	// Connections accepted on Unix sockets have no originator worth telling.
+	if originator != nil && originator.String() != "" {
+		data += " " + originator.String()
+	}
+	return channels.Open(kind, []byte(data))
+}
+
+// serveChannels handles the channels the server opens on the connection until it ends.
+func serveChannels(channels *mux.Session, forwards []*portForward) {
+	log.Traceln("--> client.serveChannels")
+	for {
+		request, err := channels.Accept()
+		if err != nil {
+			log.WithError(err).Debugln("Stopped serving channels.")
+			return
+		}
+		if request.Kind != common.CHANNEL_FORWARDED_TCPIP && request.Kind != common.CHANNEL_FORWARDED_STREAMLOCAL {
+			log.WithField("kind", request.Kind).Warnln("Server requested an unknown channel.")
+			_ = request.Reject("unknown channel kind " + request.Kind)
+			continue
+		}
+		go forwardRemote(request, forwards)
+	}
+}
+
+// forwardRemote dials the destination of the remote forward the server accepted a connection for, given as
+// "bind [originator]", and relays the channel to it.
+func forwardRemote(request *mux.Request, forwards []*portForward) {
+	args := strings.Fields(string(request.Data))
+	network := common.TCP
+	if request.Kind == common.CHANNEL_FORWARDED_STREAMLOCAL {
+		network = common.UNIX
+	}
+	var forward *portForward
+	for _, candidate := range forwards {
+		if candidate.kind == remoteForward && candidate.bindNetwork == network && len(args) > 0 &&
+			candidate.bind == args[0] {
+			forward = candidate
+		}
+	}
+	if forward == nil {
+		log.WithField("data", string(request.Data)).Warnln("Server forwarded a connection nobody asked for.")
+		_ = request.Reject("no such forward")
+		return
+	}
+	fields := log.Fields{
+		"bind":        forward.bind,
+		"destination": forward.destination,
+	}
+	if len(args) > 1 {
+		fields["originator"] = args[1]
+	}
+	log.WithFields(fields).Traceln("--> client.forwardRemote")
+	conn, err := net.DialTimeout(forward.destinationNetwork, forward.destination, forwardDialTimeout)
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln("Failed to forward connection.")
+		_ = request.Reject(err.Error())
+		return
+	}
+	channel, err := request.Accept()
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln("Failed to forward connection.")
+		_ = conn.Close()
+		return
+	}
+	atomic.AddInt32(&forward.open, 1)
+	defer atomic.AddInt32(&forward.open, -1)
+	log.WithFields(fields).Debugln("Forwarding connection.")
+	mux.Relay(channel, conn)
+}
+
+// describeForwards describes the forwards along with how many connections they forward right now.
+func (client *Client) describeForwards() []string {
+	if len(client.forwards) == 0 {
+		return []string{"No forwarded connections."}
+	}
+	lines := []string{"Forwarded connections:"}
+	for _, forward := range client.forwards {
+		open := atomic.LoadInt32(&forward.open)
+		if forward.kind == localForward {
+			lines = append(lines, fmt.Sprintf(" -L %s -> %s (%d open)", forward.listener.Addr(), forward.destination,
+				open))
+		} else if forward.kind == dynamicForward {
+			lines = append(lines, fmt.Sprintf(" -D %s -> SOCKS (%d open)", forward.listener.Addr(), open))
+		} else if atomic.LoadInt32(&forward.listening) == 1 {
+			lines = append(lines, fmt.Sprintf(" -R %s -> %s (%d open)", forward.bind, forward.destination, open))
+		} else {
+			lines = append(lines, fmt.Sprintf(" -R %s -> %s (refused by the server)", forward.bind,
+				forward.destination))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"bytes"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseForward(t *testing.T) {
+	for spec, expected := range map[string][2]string{
+		"8080:db:5432":             {"localhost:8080", "db:5432"},
+		"0.0.0.0:8080:db:5432":     {"0.0.0.0:8080", "db:5432"},
+		"*:8080:db:5432":           {":8080", "db:5432"},
+		"[::1]:8080:[fd00::1]:443": {"[::1]:8080", "[fd00::1]:443"},
+	} {
+		forward, err := parseForward(spec, localForward)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", spec, err)
+			continue
+		}
+		if forward.bind != expected[0] || forward.destination != expected[1] {
+			t.Errorf("Parsed %q as %s -> %s.", spec, forward.bind, forward.destination)
+		}
+	}
+	for _, spec := range []string{"", "8080", "8080:db", "x:db:5432", "8080::5432", "8080:db:70000", "a:b:c:d:e"} {
+		if _, err := parseForward(spec, localForward); err == nil {
+			t.Errorf("Parsed %q.", spec)
+		}
+	}
+	for spec, expected := range map[string][4]string{
+		"5432:/run/postgresql/.s.PGSQL.5432":    {"localhost:5432", "tcp", "/run/postgresql/.s.PGSQL.5432", "unix"},
+		"/tmp/docker.sock:/var/run/docker.sock": {"/tmp/docker.sock", "unix", "/var/run/docker.sock", "unix"},
+		"./db.sock:db:5432":                     {"./db.sock", "unix", "db:5432", "tcp"},
+	} {
+		forward, err := parseForward(spec, localForward)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", spec, err)
+			continue
+		}
+		if actual := [4]string{forward.bind, forward.bindNetwork, forward.destination,
+			forward.destinationNetwork}; actual != expected {
+			t.Errorf("Parsed %q as %v.", spec, actual)
+		}
+	}
+	for _, spec := range []string{"/tmp/docker.sock", "8080:/tmp/my socket", "x:/tmp/a.sock:/tmp/b.sock"} {
+		if _, err := parseForward(spec, localForward); err == nil {
+			t.Errorf("Parsed %q.", spec)
+		}
+	}
+	for spec, expected := range map[string]string{
+		"1080":          "localhost:1080",
+		"/tmp/socks":    "/tmp/socks",
+		"*:1080":        ":1080",
+		"[::1]:1080":    "[::1]:1080",
+		"10.0.0.1:1080": "10.0.0.1:1080",
+	} {
+		if forward, err := parseForward(spec, dynamicForward); err != nil || forward.bind != expected {
+			t.Errorf("Parsed dynamic forward %q as %+v: %v", spec, forward, err)
+		}
+	}
+	for _, spec := range []string{"", "socks", "1:2:1080", "8080:db:5432"} {
+		if _, err := parseForward(spec, dynamicForward); err == nil {
+			t.Errorf("Parsed dynamic forward %q.", spec)
+		}
+	}
+}
+
+func TestClient_Interact_LocalForward(t *testing.T) {
+	// Finds a free port to forward from.
+	listener, err := net.Listen(common.TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	clnt := NewClient(LoadConfig(""))
+	clnt.config.Set("Client.LocalForward", []string{"127.0.0.1:" + strconv.Itoa(port) + ":db:5432"})
+	clnt.multiplexed = true
+	conn, serverConn := net.Pipe()
+	server := mux.Server(serverConn)
+	defer server.Close()
+	served := make(chan string, 1)
+	go func() {
+		request, err := server.Accept()
+		if err != nil {
+			return
+		}
+		served <- request.Kind + " " + strings.Fields(string(request.Data))[0]
+		channel, err := request.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(channel, channel)
+		_ = channel.Close()
+	}()
+
+	input, typing := io.Pipe()
+	out := &bytes.Buffer{}
+	done := make(chan error)
+	go func() {
+		done <- clnt.Interact(conn, input, out)
+	}()
+	// The forward listens once Interact started.
+	var forwarded net.Conn
+	for forwarded == nil {
+		forwarded, _ = net.Dial(common.TCP, "127.0.0.1:"+strconv.Itoa(port))
+	}
+	_, _ = forwarded.Write([]byte("ping"))
+	_ = forwarded.(*net.TCPConn).CloseWrite()
+	if echo, err := ioutil.ReadAll(forwarded); err != nil || string(echo) != "ping" {
+		t.Errorf("Got %q back: %v", echo, err)
+	}
+	_ = forwarded.Close()
+	if request := <-served; request != "direct-tcpip db:5432" {
+		t.Errorf("Server got request %q.", request)
+	}
+	_, _ = typing.Write([]byte("~#"))
+	_, _ = typing.Write([]byte("\r~."))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "-L 127.0.0.1:"+strconv.Itoa(port)+" -> db:5432") {
+		t.Errorf("Forwards are missing from %q.", out.String())
+	}
+}
+
+func TestClient_Interact_RemoteForward(t *testing.T) {
+	destination, err := net.Listen(common.TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destination.Close()
+	go func() {
+		conn, err := destination.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("hello"))
+		_ = conn.Close()
+	}()
+
+	clnt := NewClient(LoadConfig(""))
+	clnt.config.Set("Client.RemoteForward", []string{"8080:" + destination.Addr().String()})
+	clnt.multiplexed = true
+	conn, serverConn := net.Pipe()
+	server := mux.Server(serverConn)
+	defer server.Close()
+	greeted := make(chan string, 1)
+	go func() {
+		request, err := server.Accept()
+		if err != nil {
+			return
+		}
+		if request.Kind != common.CHANNEL_TCPIP_FORWARD || string(request.Data) != "localhost:8080" {
+			t.Errorf("Server got request %s %q.", request.Kind, request.Data)
+		}
+		if _, err := request.Accept(); err != nil {
+			return
+		}
+		channel, err := server.Open(common.CHANNEL_FORWARDED_TCPIP, []byte("localhost:8080 10.0.0.1:4321"))
+		if err != nil {
+			t.Error(err)
+			greeted <- ""
+			return
+		}
+		greeting, _ := ioutil.ReadAll(channel)
+		greeted <- string(greeting)
+		if _, err := server.Open(common.CHANNEL_FORWARDED_TCPIP, []byte("localhost:9090")); err == nil {
+			t.Error("Client accepted a connection for a forward it did not ask for.")
+		}
+	}()
+
+	input, typing := io.Pipe()
+	out := &bytes.Buffer{}
+	done := make(chan error)
+	go func() {
+		done <- clnt.Interact(conn, input, out)
+	}()
+	if greeting := <-greeted; greeting != "hello" {
+		t.Errorf("Got %q from the destination.", greeting)
+	}
+	_, _ = typing.Write([]byte("~#"))
+	_, _ = typing.Write([]byte("\r~."))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "-R localhost:8080 -> "+destination.Addr().String()) {
+		t.Errorf("Forwards are missing from %q.", out.String())
+	}
+}
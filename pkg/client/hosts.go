@@ -0,0 +1,228 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hostOptions maps the keys a [[Host]] block may set, besides Match, HostName and User, to their option in [Client].
+var hostOptions = map[string]string{
+	"port":           "Port",
+	"keyfile":        "KeyFile",
+	"localforward":   "LocalForward",
+	"remoteforward":  "RemoteForward",
+	"dynamicforward": "DynamicForward",
+	"proxycommand":   "ProxyCommand",
+	"trustpolicy":    "TrustPolicy",
+	"knownhosts":     "KnownHosts",
+	"escapechar":     "EscapeChar",
+	"resume":         "Resume",
+	"resumetimeout":  "ResumeTimeout",
+}
+
+// hostBlock holds the settings of a [[Host]] block by lowercase key.
+type hostBlock map[string]interface{}
+
+// hostBlocks returns the [[Host]] blocks of the config in the order they apply in.
+func hostBlocks(config *viper.Viper) ([]hostBlock, error) {
+	log.Traceln("--> client.hostBlocks")
+	var blocks []hostBlock
+	switch raw := config.Get("Host").(type) {
+	case nil:
+	case []interface{}:
+		for _, entry := range raw {
+			settings, ok := entry.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("host blocks have to be tables")
+			}
+			blocks = append(blocks, settings)
+		}
+	case []map[string]interface{}:
+		for _, settings := range raw {
+			blocks = append(blocks, settings)
+		}
+	default:
+		return nil, errors.New("host blocks have to be tables")
+	}
+	for i, block := range blocks {
+		normalized := hostBlock{}
+		for key, value := range block {
+			normalized[strings.ToLower(key)] = value
+		}
+		if err := normalized.check(); err != nil {
+			return nil, fmt.Errorf("host block %d: %w", i+1, err)
+		}
+		blocks[i] = normalized
+	}
+	return blocks, nil
+}
+
+// check makes sure the block has valid patterns and only known options of the right type.
+func (block hostBlock) check() error {
+	patterns, err := block.patterns()
+	if err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	for key, value := range block {
+		var ok bool
+		switch key {
+		case "match":
+			ok = true
+		case "hostname", "user", "keyfile", "proxycommand", "trustpolicy", "knownhosts", "escapechar":
+			_, ok = value.(string)
+		case "port", "resumetimeout":
+			_, ok = value.(int64)
+			if !ok {
+				_, ok = value.(int)
+			}
+		case "resume":
+			_, ok = value.(bool)
+		case "localforward", "remoteforward", "dynamicforward":
+			_, err := stringList(value)
+			ok = err == nil
+		default:
+			return fmt.Errorf("unknown option %s", key)
+		}
+		if !ok {
+			return fmt.Errorf("option %s has the wrong type", key)
+		}
+	}
+	return nil
+}
+
+// patterns returns the globs of Match, either a single string or a list of them.
+func (block hostBlock) patterns() ([]string, error) {
+	match, ok := block["match"]
+	if !ok {
+		return nil, errors.New("no Match patterns")
+	}
+	if pattern, ok := match.(string); ok {
+		return []string{pattern}, nil
+	}
+	patterns, err := stringList(match)
+	if err != nil || len(patterns) == 0 {
+		return nil, errors.New("Match has to be a pattern or a list of them")
+	}
+	return patterns, nil
+}
+
+// matches tells whether any pattern of the block matches the host alias.
+func (block hostBlock) matches(alias string) bool {
+	patterns, _ := block.patterns()
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, alias); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList converts a list of strings from the config.
+func stringList(value interface{}) ([]string, error) {
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		strs := make([]string, 0, len(list))
+		for _, entry := range list {
+			str, ok := entry.(string)
+			if !ok {
+				return nil, errors.New("not a list of strings")
+			}
+			strs = append(strs, str)
+		}
+		return strs, nil
+	}
+	return nil, errors.New("not a list of strings")
+}
+
+// hostSettings collects the settings of the blocks matching the host alias. Like with ssh, the first block setting an
+// option wins, so the more specific blocks have to come first.
+func hostSettings(config *viper.Viper, alias string) (hostBlock, error) {
+	log.WithField("alias", alias).Traceln("--> client.hostSettings")
+	blocks, err := hostBlocks(config)
+	if err != nil {
+		return nil, err
+	}
+	settings := hostBlock{}
+	for _, block := range blocks {
+		if !block.matches(alias) {
+			continue
+		}
+		for key, value := range block {
+			if _, set := settings[key]; !set && key != "match" {
+				settings[key] = value
+			}
+		}
+	}
+	return settings, nil
+}
+
+// mergeHostOptions merges the options of the host settings into the config. They only take the place of the values of
+// the config files, so options set on the command line still win.
+func mergeHostOptions(config *viper.Viper, settings hostBlock) error {
+	log.WithField("settings", settings).Traceln("--> client.mergeHostOptions")
+	options := map[string]interface{}{}
+	for key, value := range settings {
+		if option, ok := hostOptions[key]; ok {
+			options[option] = value
+		}
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return config.MergeConfigMap(map[string]interface{}{"Client": options})
+}
+
+// mergeUserConfig merges the config file at userPath over the system one. Its [[Host]] blocks come before the ones of
+// the system, so they apply first.
+func mergeUserConfig(config *viper.Viper, userPath string) error {
+	log.WithField("userPath", userPath).Traceln("--> client.mergeUserConfig")
+	userConfig := viper.New()
+	userConfig.SetConfigFile(userPath)
+	userConfig.SetConfigType("toml")
+	if err := userConfig.ReadInConfig(); err != nil {
+		return err
+	}
+	var hosts []interface{}
+	for _, layer := range []*viper.Viper{userConfig, config} {
+		blocks, err := hostBlocks(layer)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			hosts = append(hosts, map[string]interface{}(block))
+		}
+	}
+	if err := config.MergeConfigMap(userConfig.AllSettings()); err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	return config.MergeConfigMap(map[string]interface{}{"Host": hosts})
+}
+
+// expandHome replaces a leading ~ of the path with the home directory of the user.
+func expandHome(filePath string) string {
+	if filePath != "~" && !strings.HasPrefix(filePath, "~/") {
+		return filePath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.WithError(err).Warnln("Failed to find the home directory.")
+		return filePath
+	}
+	return filepath.Join(home, filePath[1:])
+}
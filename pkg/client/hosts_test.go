@@ -0,0 +1,136 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const systemConfig = `
+[Client]
+Port = 2222
+TrustPolicy = "none"
+
+[[Host]]
+Match = "*.example.com"
+User = "system"
+Port = 2200
+`
+
+const userConfig = `
+[Client]
+EscapeChar = "^]"
+
+[[Host]]
+Match = "prod-db"
+HostName = "db1.example.com"
+KeyFile = "~/.gosh/prod.pem"
+
+[[Host]]
+Match = ["prod-*", "staging-*"]
+User = "deploy"
+TrustPolicy = "tofu"
+LocalForward = ["5432:localhost:5432"]
+`
+
+// writeConfigs writes the config files into a temporary directory and loads them.
+func writeConfigs(t *testing.T, system string, user string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "gosh_config.toml"), []byte(system), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(user), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadConfig_User(t *testing.T) {
+	dir := writeConfigs(t, systemConfig, userConfig)
+	config := loadConfig(dir, filepath.Join(dir, "config"))
+	if config.GetString("Client.EscapeChar") != "^]" || config.GetInt("Client.Port") != 2222 {
+		t.Errorf("Did not merge the user config: %v", config.AllSettings())
+	}
+	blocks, err := hostBlocks(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || blocks[0]["match"] != "prod-db" || blocks[2]["user"] != "system" {
+		t.Errorf("Got host blocks %v.", blocks)
+	}
+}
+
+func TestClient_ParseArgument_Host(t *testing.T) {
+	dir := writeConfigs(t, systemConfig, userConfig)
+	clnt := NewClient(loadConfig(dir, filepath.Join(dir, "config")))
+	if err := clnt.ParseArgument("prod-db"); err != nil {
+		t.Fatal(err)
+	}
+	// Blocks match the host as given, so the one of the system for *.example.com does not apply.
+	checkUrl(t, clnt.rUri, "deploy", "", "db1.example.com", 2222)
+	if clnt.config.GetString("Client.TrustPolicy") != trustTofu {
+		t.Errorf("Got trust policy %s.", clnt.config.GetString("Client.TrustPolicy"))
+	}
+	if forwards := clnt.config.GetStringSlice("Client.LocalForward"); len(forwards) != 1 {
+		t.Errorf("Got local forwards %v.", forwards)
+	}
+	if keyFile := clnt.config.GetString("Client.KeyFile"); keyFile != "~/.gosh/prod.pem" {
+		t.Errorf("Got key file %s.", keyFile)
+	}
+}
+
+func TestClient_ParseArgument_Host_Override(t *testing.T) {
+	dir := writeConfigs(t, systemConfig, userConfig)
+	config := loadConfig(dir, filepath.Join(dir, "config"))
+	// Options on the command line and in the argument win over the host blocks.
+	config.Set("Client.TrustPolicy", trustStrict)
+	clnt := NewClient(config)
+	if err := clnt.ParseArgument("admin@staging-web:2300"); err != nil {
+		t.Fatal(err)
+	}
+	checkUrl(t, clnt.rUri, "admin", "", "staging-web", 2300)
+	if clnt.config.GetString("Client.TrustPolicy") != trustStrict {
+		t.Errorf("Got trust policy %s.", clnt.config.GetString("Client.TrustPolicy"))
+	}
+}
+
+func TestClient_ParseArgument_Host_None(t *testing.T) {
+	dir := writeConfigs(t, systemConfig, userConfig)
+	clnt := NewClient(loadConfig(dir, filepath.Join(dir, "missing")))
+	if err := clnt.ParseArgument("other.org"); err != nil {
+		t.Fatal(err)
+	}
+	checkUrl(t, clnt.rUri, "", "", "other.org", 2222)
+}
+
+func TestHostBlocks_Invalid(t *testing.T) {
+	for _, block := range []string{
+		`Port = 22`,
+		`Match = "["`,
+		`Match = []`,
+		"Match = \"a\"\nPrt = 22",
+		"Match = \"a\"\nPort = \"22\"",
+		"Match = \"a\"\nLocalForward = [1]",
+	} {
+		dir := writeConfigs(t, "[[Host]]\n"+block, "")
+		clnt := NewClient(loadConfig(dir, filepath.Join(dir, "config")))
+		if err := clnt.ParseArgument("a"); err == nil {
+			t.Errorf("Accepted host block %q.", block)
+		}
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("No home directory.")
+	}
+	if expanded := expandHome("~/.gosh/config"); expanded != filepath.Join(home, ".gosh/config") {
+		t.Errorf("Expanded to %s.", expanded)
+	}
+	for _, filePath := range []string{"/etc/gosh", "~other/file", "relative"} {
+		if expanded := expandHome(filePath); expanded != filePath {
+			t.Errorf("Expanded %s to %s.", filePath, expanded)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyExitTimeout is how long a proxy command gets to exit after its stdin got closed, before it is killed.
+const proxyExitTimeout = time.Second
+
+// proxyConn is a connection to the server over the stdin and stdout of a proxy command.
+type proxyConn struct {
+	cmd       *exec.Cmd
+	stdout    *os.File // Reads what the command writes.
+	stdin     *os.File // Writes what the command reads.
+	addr      proxyAddr
+	closeOnce sync.Once
+}
+
+// proxyAddr names the proxy command, which serves as both addresses of the connection.
+type proxyAddr string
+
+func (addr proxyAddr) Network() string {
+	return "proxy"
+}
+
+func (addr proxyAddr) String() string {
+	return string(addr)
+}
+
+// shellSafe matches the values that the shell takes literally.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// proxyCommandLine substitutes %h with the host, %p with the port and %% with % in the command. The host and port are
+// quoted for the shell unless they are plain, so that a crafted host name cannot run commands.
+func proxyCommandLine(command string, host string, port string) string {
+	return strings.NewReplacer("%%", "%", "%h", shellQuote(host), "%p", shellQuote(port)).Replace(command)
+}
+
+// shellQuote quotes the value in single quotes, unless the shell takes it literally anyway.
+func shellQuote(value string) string {
+	if shellSafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// dialProxy starts the proxy command through the shell, which is connected to the server at host:port.
+func dialProxy(command string, host string, port string) (net.Conn, error) {
+	log.WithFields(log.Fields{
+		"command": command,
+		"host":    host,
+		"port":    port,
+	}).Traceln("--> client.dialProxy")
+	commandLine := proxyCommandLine(command, host, port)
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+	cmd := exec.Command("/bin/sh", "-c", commandLine)
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	// The command has its own copies of its ends now.
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+		return nil, err
+	}
+	return &proxyConn{cmd: cmd, stdout: stdoutReader, stdin: stdinWriter, addr: proxyAddr(commandLine)}, nil
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	return conn.stdout.Read(b)
+}
+
+func (conn *proxyConn) Write(b []byte) (int, error) {
+	return conn.stdin.Write(b)
+}
+
+// Close closes stdin of the command and waits a moment for it to exit before killing it.
+func (conn *proxyConn) Close() error {
+	conn.closeOnce.Do(func() {
+		_ = conn.stdin.Close()
+		exited := make(chan struct{})
+		go func() {
+			_ = conn.cmd.Wait()
+			close(exited)
+		}()
+		select {
+		case <-exited:
+		case <-time.After(proxyExitTimeout):
+			_ = conn.cmd.Process.Kill()
+			<-exited
+		}
+		_ = conn.stdout.Close()
+	})
+	return nil
+}
+
+func (conn *proxyConn) LocalAddr() net.Addr {
+	return conn.addr
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+func (conn *proxyConn) SetDeadline(t time.Time) error {
+	if err := conn.stdout.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.stdin.SetWriteDeadline(t)
+}
+
+func (conn *proxyConn) SetReadDeadline(t time.Time) error {
+	return conn.stdout.SetReadDeadline(t)
+}
+
+func (conn *proxyConn) SetWriteDeadline(t time.Time) error {
+	return conn.stdin.SetWriteDeadline(t)
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestProxyCommandLine(t *testing.T) {
+	commandLine := proxyCommandLine("nc -X 5 -x proxy:%p %h %p # 100%%", "db1", "2222")
+	if commandLine != "nc -X 5 -x proxy:2222 db1 2222 # 100%" {
+		t.Errorf("Got command line %q.", commandLine)
+	}
+	commandLine = proxyCommandLine("nc %h %p", "db1;touch /tmp/pwned", "it's")
+	if commandLine != `nc 'db1;touch /tmp/pwned' 'it'\''s'` {
+		t.Errorf("Got command line %q.", commandLine)
+	}
+}
+
+func TestDialProxy(t *testing.T) {
+	conn, err := dialProxy("echo %h:%p; cat", "db1", "2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, len("db1:2222\nping\n"))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "db1:2222\nping\n" {
+		t.Errorf("Read %q.", data)
+	}
+	if err := conn.Close(); err != nil {
+		t.Error(err)
+	}
+	// The command exits once its input is closed.
+	if _, err := conn.Read(data); err == nil {
+		t.Error("Read from a closed connection.")
+	}
+}
+
+func TestDialProxy_Quoted(t *testing.T) {
+	conn, err := dialProxy("printf '%s\\n' %h", "$(echo injected) 'x'", "2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	output, _ := io.ReadAll(conn)
+	if string(output) != "$(echo injected) 'x'\n" {
+		t.Errorf("Read %q.", output)
+	}
+}
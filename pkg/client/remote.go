@@ -0,0 +1,50 @@
+package client
+
+import (
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"strings"
+)
+
+// SplitRemote splits an argument like [user[:password]@]host:path or unix:///path/to/socket:path into the server and
+// the path on it, which defaults to the home directory. Local paths have no server, which tells them by a slash before
+// the first colon.
+func SplitRemote(arg string) (string, string) {
+	if strings.HasPrefix(arg, common.UNIX+"://") {
+		rest := strings.TrimPrefix(arg, common.UNIX+"://")
+		colonIdx := strings.Index(rest, ":")
+		if colonIdx <= 0 {
+			return "", arg
+		}
+		return common.UNIX + "://" + rest[:colonIdx], remotePath(rest[colonIdx+1:])
+	}
+	userInfo, rest := "", arg
+	head := arg
+	if slashIdx := strings.Index(arg, "/"); slashIdx >= 0 {
+		head = arg[:slashIdx]
+	}
+	if atIdx := strings.LastIndex(head, "@"); atIdx >= 0 {
+		userInfo, rest = arg[:atIdx+1], arg[atIdx+1:]
+	}
+	hostEnd := 0
+	if strings.HasPrefix(rest, "[") {
+		// The colons of IPv6 addresses are within brackets.
+		hostEnd = strings.Index(rest, "]") + 1
+	}
+	colonIdx := strings.Index(rest[hostEnd:], ":")
+	if colonIdx < 0 {
+		return "", arg
+	}
+	host := rest[:hostEnd+colonIdx]
+	if host == "" || strings.Contains(host, "/") {
+		return "", arg
+	}
+	return userInfo + host, remotePath(rest[hostEnd+colonIdx+1:])
+}
+
+// remotePath defaults to the home directory, where subsystems run.
+func remotePath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
@@ -0,0 +1,29 @@
+package client
+
+import "testing"
+
+func TestSplitRemote(t *testing.T) {
+	tests := []struct {
+		arg    string
+		server string
+		path   string
+	}{
+		{"host:file", "host", "file"},
+		{"host:", "host", "."},
+		{"user@host:/abs/file", "user@host", "/abs/file"},
+		{"user:secret@host:dir/", "user:secret@host", "dir/"},
+		{"[::1]:file", "[::1]", "file"},
+		{"user@[::1]:", "user@[::1]", "."},
+		{"unix:///run/gosh.sock:file", "unix:///run/gosh.sock", "file"},
+		{"local/file", "", "local/file"},
+		{"./with:colon", "", "./with:colon"},
+		{"dir/user@host:file", "", "dir/user@host:file"},
+		{"file", "", "file"},
+		{":file", "", ":file"},
+	}
+	for _, test := range tests {
+		if server, path := SplitRemote(test.arg); server != test.server || path != test.path {
+			t.Errorf("Split %q into %q and %q.", test.arg, server, path)
+		}
+	}
+}
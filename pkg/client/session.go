@@ -0,0 +1,261 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How long a write to the server may take before the connection counts as lost.
+	writeTimeout = 10 * time.Second
+	// How long to wait between attempts to resume the session.
+	resumeRetryInterval = 2 * time.Second
+)
+
+// These errors end the connection on purpose of the user.
+var (
+	errDetached     = errors.New("detached from session")
+	errDisconnected = errors.New("disconnected")
+)
+
+// Interact connects the terminal to the session on conn until the session ends or the user detaches from it. If the
+// server keeps the session resumable and the connection drops, the client reconnects and resumes it. Interact closes
+// conn.
+func (client *Client) Interact(conn net.Conn, in io.Reader, out io.Writer) error {
+	log.WithFields(log.Fields{
+		"in":  &in,
+		"out": &out,
+	}).Traceln("--> client.Client.Interact")
+	escapeChar, err := parseEscapeChar(client.config.GetString("Client.EscapeChar"))
+	if err != nil {
+		log.WithError(err).Errorln("Failed to interact with session.")
+		utils.CloseConn(conn)
+		return err
+	}
+	conn = client.multiplex(conn)
+	if err := client.startForwards(out); err != nil {
+		_, _ = fmt.Fprintf(out, "gosh: %s.\r\n", err.Error())
+	}
+	defer client.stopForwards()
+	input := make(chan []byte)
+	go readInput(in, input)
+	filter := newEscapeFilter(escapeChar)
+	for {
+		err := client.pump(conn, input, filter, out)
+		switch err {
+		case errDetached:
+			_, _ = fmt.Fprintf(out, "\r\ngosh: Detached from session %s.\r\n", client.sessionId())
+			log.WithField("id", client.sessionId()).Infoln("Detached from session.")
+			return nil
+		case errDisconnected:
+			_, _ = fmt.Fprint(out, "\r\ngosh: Connection closed.\r\n")
+			log.Infoln("Disconnected from server.")
+			return nil
+		}
+		if err == nil || client.session == "" || !client.config.GetBool("Client.Resume") {
+			return err
+		}
+		log.WithError(err).Warnln("Lost connection to server.")
+		if conn, err = client.resume(out); err != nil {
+			return err
+		}
+		conn = client.multiplex(conn)
+		client.requestRemoteForwards(out)
+	}
+}
+
+// multiplex returns the connection of the terminal session, which is a channel of its own if the server multiplexes
+// the connection. Forwarded connections open their channels on the latest connection.
+func (client *Client) multiplex(conn net.Conn) net.Conn {
+	log.WithField("multiplexed", client.multiplexed).Traceln("--> client.Client.multiplex")
+	var channels *mux.Session
+	if client.multiplexed {
+		channels = mux.Client(conn)
+		conn = channels.Stream()
+	}
+	client.channelsMutex.Lock()
+	defer client.channelsMutex.Unlock()
+	client.channels = channels
+	return conn
+}
+
+// pump forwards input to the server and its output to out until the connection ends. A connection the server closed
+// properly ends without an error.
+func (client *Client) pump(conn net.Conn, input <-chan []byte, filter *escapeFilter, out io.Writer) error {
+	log.WithField("remote", conn.RemoteAddr()).Traceln("--> client.Client.pump")
+	received := make(chan error, 1)
+	go client.receive(conn, out, received)
+	for {
+		select {
+		case chunk, ok := <-input:
+			if !ok {
+				// Without input the session goes on until the server ends it.
+				input = nil
+				continue
+			}
+			for len(chunk) > 0 {
+				var command byte
+				var output []byte
+				output, command, chunk = filter.Filter(chunk)
+				if len(output) > 0 {
+					_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+					if _, err := conn.Write(output); err != nil {
+						_ = conn.Close()
+						<-received
+						return err
+					}
+				}
+				if err := client.escape(command, filter, conn, out); err != nil {
+					utils.CloseConn(conn)
+					<-received
+					return err
+				}
+			}
+		case err := <-received:
+			utils.CloseConn(conn)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// escape carries out the escape command. It returns an error if the connection has to end.
+func (client *Client) escape(command byte, filter *escapeFilter, conn net.Conn, out io.Writer) error {
+	log.WithField("command", command).Traceln("--> client.Client.escape")
+	var lines []string
+	switch command {
+	case 0:
+		return nil
+	case escapeDisconnect:
+		return errDisconnected
+	case escapeDetach:
+		if client.session != "" {
+			return errDetached
+		}
+		lines = []string{"This session cannot be detached."}
+	case escapeSuspend:
+		if client.suspend == nil {
+			lines = []string{"gosh cannot be suspended."}
+		} else {
+			client.suspend()
+		}
+	case escapeForwards:
+		lines = client.describeForwards()
+	case escapeInfo:
+		lines = append(client.info(conn), filter.Help()...)
+	}
+	for _, line := range lines {
+		_, _ = fmt.Fprintf(out, "\r\n%s", line)
+	}
+	if len(lines) > 0 {
+		_, _ = fmt.Fprint(out, "\r\n")
+	}
+	return nil
+}
+
+// info describes the connection and the session.
+func (client *Client) info(conn net.Conn) []string {
+	info := []string{fmt.Sprintf("Connected to %s.", conn.RemoteAddr())}
+	if client.session != "" {
+		info = append(info, fmt.Sprintf("Session %s is resumable, received %d bytes of output.", client.sessionId(),
+			atomic.LoadInt64(&client.received)))
+	} else {
+		info = append(info, "The session is not resumable.")
+	}
+	return info
+}
+
+// OnSuspend sets what suspends gosh when the user asks for it. It has to return once gosh continues.
+func (client *Client) OnSuspend(suspend func()) {
+	client.suspend = suspend
+}
+
+// receive copies the output of the session to out and counts it, so that a resumed session knows what to replay.
+func (client *Client) receive(conn net.Conn, out io.Writer, done chan<- error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			atomic.AddInt64(&client.received, int64(n))
+			if _, err := out.Write(buf[:n]); err != nil {
+				log.WithError(err).Errorln("Failed to write output.")
+			}
+		}
+		if err != nil {
+			done <- err
+			return
+		}
+	}
+}
+
+// resume reconnects to the server and resumes the session until it succeeds, the server refuses to resume it or
+// Client.ResumeTimeout runs out.
+func (client *Client) resume(out io.Writer) (net.Conn, error) {
+	log.Traceln("--> client.Client.resume")
+	ErrorMsg := "Failed to resume session."
+	_, _ = fmt.Fprint(out, "\r\ngosh: Connection lost. Reconnecting...\r\n")
+	deadline := time.Now().Add(time.Duration(client.config.GetInt("Client.ResumeTimeout")) * time.Second)
+	for {
+		token := fmt.Sprintf("%s:%d", client.session, atomic.LoadInt64(&client.received))
+		if err := os.Setenv(common.ENV_GOSH_SESSION, token); err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			return nil, err
+		}
+		conn, err := client.Dial()
+		if err == nil {
+			if err = client.PerformTransfer(conn, conn); err == nil {
+				_, _ = fmt.Fprint(out, "gosh: Resumed session.\r\n")
+				log.Infoln("Resumed session.")
+				return conn, nil
+			}
+			utils.CloseConn(conn)
+			if errors.Is(err, ErrRejected) {
+				_, _ = fmt.Fprintf(out, "gosh: %s\r\n", err.Error())
+				log.WithError(err).Errorln(ErrorMsg)
+				return nil, err
+			}
+		}
+		if time.Now().After(deadline) {
+			err = errors.New("gave up resuming the session")
+			_, _ = fmt.Fprintf(out, "gosh: %s\r\n", err.Error())
+			log.WithError(err).Errorln(ErrorMsg)
+			return nil, err
+		}
+		time.Sleep(resumeRetryInterval)
+	}
+}
+
+// sessionId returns the id of the resumable session.
+func (client *Client) sessionId() string {
+	return strings.SplitN(client.session, ":", 2)[0]
+}
+
+// readInput sends chunks read from in to the channel and closes it at the end of the input.
+func readInput(in io.Reader, input chan<- []byte) {
+	defer close(input)
+	for {
+		buf := make([]byte, 4096)
+		n, err := in.Read(buf)
+		if n > 0 {
+			input <- buf[:n]
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.WithError(err).Errorln("Failed to read input.")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClient_Interact(t *testing.T) {
+	clnt := NewClient(config)
+	clnt.session = "0123456789abcdef:secret"
+	conn, server := net.Pipe()
+	go func() {
+		input := make([]byte, 3)
+		if _, err := server.Read(input); err != nil || string(input) != "ls\n" {
+			t.Errorf("Server got %q: %v", input, err)
+		}
+		_, _ = server.Write([]byte("file\r\n"))
+		_ = server.Close()
+	}()
+	out := &bytes.Buffer{}
+	// A session the server ends is not resumed.
+	if err := clnt.Interact(conn, strings.NewReader("ls\n"), out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "file\r\n" || clnt.received != int64(out.Len()) {
+		t.Errorf("Got %q, counted %d bytes.", out.String(), clnt.received)
+	}
+}
+
+func TestClient_Interact_Detach(t *testing.T) {
+	clnt := NewClient(config)
+	clnt.session = "0123456789abcdef:secret"
+	conn, server := net.Pipe()
+	go func() {
+		input := make([]byte, 3)
+		if _, err := server.Read(input); err != nil || string(input) != "ls\r" {
+			t.Errorf("Server got %q: %v", input, err)
+		}
+		if _, err := server.Read(input); err == nil {
+			t.Error("Connection stayed open after detaching.")
+		}
+	}()
+	out := &bytes.Buffer{}
+	if err := clnt.Interact(conn, strings.NewReader("ls\r~d"), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Detached from session 0123456789abcdef.") {
+		t.Errorf("Got %q.", out.String())
+	}
+}
+
+func TestClient_Interact_Disconnect(t *testing.T) {
+	clnt := NewClient(config)
+	conn, server := net.Pipe()
+	go func() {
+		_, _ = io.Copy(io.Discard, server)
+	}()
+	out := &bytes.Buffer{}
+	if err := clnt.Interact(conn, strings.NewReader("~?\r~."), out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"The session is not resumable.", "~. - disconnect", "Connection closed."} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Output is missing %q: %q", expected, out.String())
+		}
+	}
+}
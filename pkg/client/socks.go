@@ -0,0 +1,146 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SOCKS5 as of RFC 1928. Only CONNECT without authentication is supported.
+const (
+	socksVersion             = 5
+	socksMethodNone          = 0x00
+	socksMethodNoAcceptable  = 0xff
+	socksCommandConnect      = 1
+	socksAddressIPv4         = 1
+	socksAddressDomain       = 3
+	socksAddressIPv6         = 4
+	socksSucceeded           = 0
+	socksGeneralFailure      = 1
+	socksCommandNotSupported = 7
+	socksAddressNotSupported = 8
+)
+
+// How long a SOCKS client may take to tell where it wants to connect to.
+const socksHandshakeTimeout = 30 * time.Second
+
+// forwardSocks serves a SOCKS client of the dynamic forward. The server resolves and dials the destination the SOCKS
+// client asks for, just like it does for local forwards.
+func (client *Client) forwardSocks(forward *portForward, conn net.Conn) {
+	fields := log.Fields{
+		"originator": conn.RemoteAddr(),
+		"bind":       forward.bind,
+	}
+	log.WithFields(fields).Traceln("--> client.Client.forwardSocks")
+	ErrorMsg := "Failed to forward SOCKS connection."
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	destination, err := socksHandshake(conn)
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln(ErrorMsg)
+		_ = conn.Close()
+		return
+	}
+	fields["destination"] = destination
+	channel, err := client.openDirect(common.TCP, destination, conn.RemoteAddr())
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln(ErrorMsg)
+		_ = socksReply(conn, socksGeneralFailure)
+		_ = conn.Close()
+		return
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		log.WithError(err).WithFields(fields).Warnln(ErrorMsg)
+		_ = channel.Close()
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	atomic.AddInt32(&forward.open, 1)
+	defer atomic.AddInt32(&forward.open, -1)
+	log.WithFields(fields).Debugln("Forwarding SOCKS connection.")
+	mux.Relay(channel, conn)
+}
+
+// socksHandshake negotiates with the SOCKS client and returns the host:port it wants to connect to. Requests that are
+// not supported get answered before the error returns.
+func socksHandshake(conn io.ReadWriter) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, offered := range methods {
+		if offered == socksMethodNone {
+			method = socksMethodNone
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksMethodNoAcceptable {
+		return "", errors.New("SOCKS client requires authentication")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	if request[1] != socksCommandConnect {
+		_ = socksReply(conn, socksCommandNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case socksAddressIPv4, socksAddressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = socksReply(conn, socksAddressNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply answers the request of the SOCKS client. The bound address is not disclosed.
+func socksReply(conn io.Writer, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksAddressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSocksHandshake(t *testing.T) {
+	for request, expected := range map[string]string{
+		"\x05\x01\x00\x05\x01\x00\x03\x0bdb.internal\x15\x38":                        "db.internal:5432",
+		"\x05\x02\x02\x00\x05\x01\x00\x01\x0a\x00\x00\x01\x00\x50":                   "10.0.0.1:80",
+		"\x05\x01\x00\x05\x01\x00\x04" + strings.Repeat("\x00", 15) + "\x01\x00\x16": "[::1]:22",
+	} {
+		conn := &socksConn{Reader: strings.NewReader(request)}
+		if destination, err := socksHandshake(conn); err != nil || destination != expected {
+			t.Errorf("Got %q instead of %q: %v", destination, expected, err)
+		}
+		if conn.String() != "\x05\x00" {
+			t.Errorf("Answered %q.", conn.String())
+		}
+	}
+	for request, answer := range map[string]string{
+		// Authentication only.
+		"\x05\x01\x02": "\x05\xff",
+		// BIND.
+		"\x05\x01\x00\x05\x02\x00\x01\x0a\x00\x00\x01\x00\x50": "\x05\x00\x05\x07\x00\x01\x00\x00\x00\x00\x00\x00",
+		// SOCKS4.
+		"\x04\x01\x00\x50\x0a\x00\x00\x01\x00": "",
+	} {
+		conn := &socksConn{Reader: strings.NewReader(request)}
+		if _, err := socksHandshake(conn); err == nil {
+			t.Errorf("Accepted %q.", request)
+		}
+		if conn.String() != answer {
+			t.Errorf("Answered %q instead of %q.", conn.String(), answer)
+		}
+	}
+}
+
+// A socksConn reads the requests of a SOCKS client and records the answers.
+type socksConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (conn *socksConn) Read(p []byte) (int, error) {
+	return conn.Reader.Read(p)
+}
+
+func (conn *socksConn) Write(p []byte) (int, error) {
+	return conn.Buffer.Write(p)
+}
+
+func TestClient_Interact_DynamicForward(t *testing.T) {
+	// Finds a free port for the proxy.
+	listener, err := net.Listen(common.TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	_ = listener.Close()
+
+	clnt := NewClient(LoadConfig(""))
+	clnt.config.Set("Client.DynamicForward", []string{"127.0.0.1:" + port})
+	clnt.multiplexed = true
+	conn, serverConn := net.Pipe()
+	server := mux.Server(serverConn)
+	defer server.Close()
+	go func() {
+		for {
+			request, err := server.Accept()
+			if err != nil {
+				return
+			}
+			if string(request.Data[:len("db.internal:5432 ")]) != "db.internal:5432 " {
+				_ = request.Reject("forwarding to " + string(request.Data) + " is not permitted")
+				continue
+			}
+			if channel, err := request.Accept(); err == nil {
+				_, _ = channel.Write([]byte("hello"))
+				_ = channel.Close()
+			}
+		}
+	}()
+
+	input, typing := io.Pipe()
+	out := &bytes.Buffer{}
+	done := make(chan error)
+	go func() {
+		done <- clnt.Interact(conn, input, out)
+	}()
+	connect := func(host string) []byte {
+		var proxied net.Conn
+		for proxied == nil {
+			proxied, _ = net.Dial(common.TCP, "127.0.0.1:"+port)
+		}
+		defer proxied.Close()
+		request := append([]byte{5, 1, 0, 5, 1, 0, 3, byte(len(host))}, host...)
+		_, _ = proxied.Write(append(request, 0x15, 0x38))
+		reply, _ := ioutil.ReadAll(proxied)
+		return reply
+	}
+	if reply := connect("db.internal"); string(reply) != "\x05\x00\x05\x00\x00\x01\x00\x00\x00\x00\x00\x00hello" {
+		t.Errorf("Got %q.", reply)
+	}
+	if reply := connect("web.internal"); string(reply) != "\x05\x00\x05\x01\x00\x01\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("Got %q for a refused destination.", reply)
+	}
+	_, _ = typing.Write([]byte("~#"))
+	_, _ = typing.Write([]byte("\r~."))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "-D 127.0.0.1:"+port+" -> SOCKS") {
+		t.Errorf("Forwards are missing from %q.", out.String())
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The policies on which server certificates to trust.
+const (
+	trustNone   = "none"   // Accepts any certificate.
+	trustTofu   = "tofu"   // Pins the certificate of a server in KnownHosts on first use and rejects any other later.
+	trustStrict = "strict" // Only accepts the certificates pinned in KnownHosts already.
+	trustCA     = "ca"     // Verifies the certificate against the roots of the system.
+)
+
+func checkTrustPolicy(policy string) error {
+	switch policy {
+	case trustNone, trustTofu, trustStrict, trustCA:
+		return nil
+	}
+	return fmt.Errorf("trust policy has to be either none, tofu, strict or ca, not %q", policy)
+}
+
+// tlsConfig returns the TLS config to connect to the server at host, which is either host:port or a socket path.
+func (client Client) tlsConfig(host string) (*tls.Config, error) {
+	log.WithField("host", host).Traceln("--> client.Client.tlsConfig")
+	policy := client.config.GetString("Client.TrustPolicy")
+	if err := checkTrustPolicy(policy); err != nil {
+		return nil, err
+	}
+	if policy == trustCA {
+		serverName, _, err := net.SplitHostPort(host)
+		if err != nil {
+			serverName = host
+		}
+		return &tls.Config{ServerName: serverName}, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if policy == trustNone {
+		return tlsConfig, nil
+	}
+	knownHosts := expandHome(client.config.GetString("Client.KnownHosts"))
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		return verifyKnownHost(knownHosts, host, state.PeerCertificates[0], policy == trustTofu)
+	}
+	return tlsConfig, nil
+}
+
+// fingerprint returns the hex encoded SHA-256 of the certificate.
+func fingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyKnownHost checks the certificate against the one pinned for host in the known hosts file. Unknown hosts get
+// pinned if pin is set, and rejected otherwise.
+func verifyKnownHost(knownHosts string, host string, certificate *x509.Certificate, pin bool) error {
+	log.WithFields(log.Fields{
+		"knownHosts": knownHosts,
+		"host":       host,
+	}).Traceln("--> client.verifyKnownHost")
+	pinned, err := readKnownHosts(knownHosts)
+	if err != nil {
+		return err
+	}
+	actual := fingerprint(certificate)
+	if expected, ok := pinned[host]; ok {
+		if expected != actual {
+			return fmt.Errorf("certificate of %s changed to %s, remove it from %s if this is expected", host, actual,
+				knownHosts)
+		}
+		return nil
+	}
+	if !pin {
+		return fmt.Errorf("%s is not in %s", host, knownHosts)
+	}
+	if err := addKnownHost(knownHosts, host, actual); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"host":        host,
+		"fingerprint": actual,
+	}).Warnln("Pinned the certificate of a new host.")
+	return nil
+}
+
+// readKnownHosts reads the fingerprints by host from the file, which has a host and a fingerprint on each line.
+func readKnownHosts(knownHosts string) (map[string]string, error) {
+	pinned := map[string]string{}
+	file, err := os.Open(knownHosts)
+	if os.IsNotExist(err) {
+		return pinned, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a host and a fingerprint", knownHosts, lineNr)
+		}
+		pinned[fields[0]] = fields[1]
+	}
+	return pinned, scanner.Err()
+}
+
+func addKnownHost(knownHosts string, host string, fingerprint string) error {
+	if err := os.MkdirAll(filepath.Dir(knownHosts), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(knownHosts, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(file, "%s %s\n", host, fingerprint); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a self-signed certificate for localhost.
+func newCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// handshake performs a TLS handshake with a server presenting the certificate. The connection goes over loopback, since
+// both ends may write at once when the client rejects the certificate, which an unbuffered net.Pipe cannot take.
+func handshake(t *testing.T, tlsConfig *tls.Config, certificate tls.Certificate) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{certificate}})
+		_ = server.Handshake()
+		_ = server.Close()
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tls.Client(conn, tlsConfig).Handshake()
+	_ = conn.Close()
+	<-done
+	return err
+}
+
+func TestClient_TlsConfig_Tofu(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "gosh", "known_hosts")
+	config.Set("Client.KnownHosts", knownHosts)
+	config.Set("Client.TrustPolicy", trustTofu)
+	defer config.Set("Client.TrustPolicy", trustNone)
+	clnt := NewClient(config)
+	tlsConfig, err := clnt.tlsConfig("localhost:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := newCertificate(t)
+
+	// The first connection pins the certificate, later ones have to present the same.
+	if err := handshake(t, tlsConfig, certificate); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, tlsConfig, certificate); err != nil {
+		t.Error(err)
+	}
+	if err := handshake(t, tlsConfig, newCertificate(t)); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("Accepted a changed certificate: %v", err)
+	}
+	data, _ := os.ReadFile(knownHosts)
+	if string(data) != "localhost:2222 "+fingerprint(certificate.Leaf)+"\n" {
+		t.Errorf("Got known hosts %q.", data)
+	}
+}
+
+func TestClient_TlsConfig_Strict(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	certificate := newCertificate(t)
+	content := "# pinned\n\nlocalhost:2222 " + fingerprint(certificate.Leaf) + "\n"
+	if err := os.WriteFile(knownHosts, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Set("Client.KnownHosts", knownHosts)
+	config.Set("Client.TrustPolicy", trustStrict)
+	defer config.Set("Client.TrustPolicy", trustNone)
+	clnt := NewClient(config)
+
+	tlsConfig, err := clnt.tlsConfig("localhost:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, tlsConfig, certificate); err != nil {
+		t.Error(err)
+	}
+	// Unknown hosts do not get pinned.
+	tlsConfig, err = clnt.tlsConfig("localhost:2223")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, tlsConfig, certificate); err == nil {
+		t.Error("Accepted an unknown host.")
+	}
+	if data, _ := os.ReadFile(knownHosts); string(data) != content {
+		t.Errorf("Changed the known hosts to %q.", data)
+	}
+}
+
+func TestClient_TlsConfig_Policies(t *testing.T) {
+	defer config.Set("Client.TrustPolicy", trustNone)
+	certificate := newCertificate(t)
+	config.Set("Client.TrustPolicy", trustNone)
+	tlsConfig, err := NewClient(config).tlsConfig("localhost:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, tlsConfig, certificate); err != nil {
+		t.Error(err)
+	}
+	// A self-signed certificate is not signed by the roots of the system.
+	config.Set("Client.TrustPolicy", trustCA)
+	tlsConfig, err = NewClient(config).tlsConfig("localhost:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != "localhost" {
+		t.Errorf("Got server name %s.", tlsConfig.ServerName)
+	}
+	if err := handshake(t, tlsConfig, certificate); err == nil {
+		t.Error("Accepted a self-signed certificate.")
+	}
+	config.Set("Client.TrustPolicy", "always")
+	if _, err := NewClient(config).tlsConfig("localhost:2222"); err == nil {
+		t.Error("Accepted an unknown trust policy.")
+	}
+}
@@ -21,11 +21,43 @@ const (
 	KEYFILE           = "/etc/gosh/key.pem"
 	CONFIGPATH        = "/etc/gosh"
 	AUTHPATH          = "~/.gosh"
+	USERCONFIG        = "~/.gosh/config" // The config of the user, merged over the one in CONFIGPATH.
 	AUTHKEYSDIR       = "authorized_keys"
 	SECRET_LENGTH     = 64
 	DEFAULT_LOG_LEVEL = log.InfoLevel
 	ENV_GOSH_USER     = "GOSH_USER"
 	ENV_GOSH_PASSWORD = "GOSH_PASSWORD"
+	ENV_GOSH_SESSION  = "GOSH_SESSION"
+	ENV_GOSH_ATTACH   = "GOSH_ATTACH"
+	ENV_GOSH_SHARE    = "GOSH_SHARE"
+	ENV_GOSH_MUX      = "GOSH_MUX"
+	ATTACH_LIST       = "list" // Asks to list the detached sessions instead of attaching to one.
+)
+
+// The id of a resumable session, set in the environment of its shell.
+const ENV_GOSH_SESSION_ID = "GOSH_SESSION_ID"
+
+// The subsystem the client asks for instead of a terminal session, like gcp.
+const ENV_GOSH_SUBSYSTEM = "GOSH_SUBSYSTEM"
+
+// Kinds of channels multiplexed over the connection.
+const (
+	// Opened by the client for a connection the server forwards to the host:port given in the data.
+	CHANNEL_DIRECT_TCPIP = "direct-tcpip"
+	// Opened by the client to have the server listen on the bind address given in the data for as long as the channel
+	// is open.
+	CHANNEL_TCPIP_FORWARD = "tcpip-forward"
+	// Opened by the server for a connection it accepted for a remote forward. The data is the bind address the client
+	// asked for and the originator.
+	CHANNEL_FORWARDED_TCPIP = "forwarded-tcpip"
+	// Opened by the client for a connection the server forwards to the Unix socket at the path given in the data.
+	CHANNEL_DIRECT_STREAMLOCAL = "direct-streamlocal"
+	// Opened by the client to have the server listen on the Unix socket at the path given in the data for as long as
+	// the channel is open.
+	CHANNEL_STREAMLOCAL_FORWARD = "streamlocal-forward"
+	// Opened by the server for a connection it accepted on a Unix socket for a remote forward. The data is the path
+	// the client asked for.
+	CHANNEL_FORWARDED_STREAMLOCAL = "forwarded-streamlocal"
 )
 
 //TODO: Use global loggers
@@ -21,6 +21,27 @@ func Parse(str string) (Packet, error) {
 		return DonePacket{Success: true}, nil
 	} else if str == "?D0:" {
 		return DonePacket{Success: false}, nil
+	} else if strings.HasPrefix(str, "?R:") {
+		return RejectPacket{str[3:]}, nil
+	} else if strings.HasPrefix(str, "?B") && strings.HasSuffix(str, ":") {
+		length, err := strconv.Atoi(str[2 : len(str)-1])
+		if err != nil || length < 0 || length > MaxBannerLength {
+			err = errors.New("invalid banner length")
+			log.WithError(err).WithField("str", str).Errorln("Failed to parse length from packet")
+			return nil, err
+		}
+		return BannerPacket{Length: length}, nil
+	} else if strings.HasPrefix(str, "?S:") {
+		colonIdx := strings.LastIndex(str, ":")
+		offset, err := strconv.ParseInt(str[colonIdx+1:], 10, 64)
+		if colonIdx < 3 || err != nil || offset < 0 {
+			err = errors.New("invalid session offset")
+			log.WithError(err).WithField("str", str).Errorln("Failed to parse offset from packet")
+			return nil, err
+		}
+		return SessionPacket{Token: str[3:colonIdx], Offset: offset}, nil
+	} else if str == "?M:" {
+		return MuxPacket{}, nil
 	} else if strings.HasPrefix(str, "?E:") {
 		return EnvPacket{str[3:]}, nil
 	} else if strings.HasPrefix(str, "?K") {
@@ -77,6 +98,7 @@ type RsaPacket struct {
 	EncryptedSecret  []byte
 	EncryptedSecretN int
 	KeyPath          string
+	KeyFile          string // The private key to use instead of the one of the user in KeyPath, if set.
 }
 
 func (req RsaPacket) Ask(in io.Reader, out io.Writer) error {
@@ -86,10 +108,14 @@ func (req RsaPacket) Ask(in io.Reader, out io.Writer) error {
 	}).Traceln("--> connection.RsaPacket.Ask")
 	log.WithFields(log.Fields{
 		"KeyPath":          req.KeyPath,
+		"KeyFile":          req.KeyFile,
 		"EncryptedSecretN": req.EncryptedSecretN,
 	}).Debugln("Decrypting secret.")
-	filename := url.PathEscape(os.Getenv("USER")) + ".pem"
-	privateKey, err := utils.PrivateKeyFromFile(path.Join(req.KeyPath, filename))
+	keyFile := req.KeyFile
+	if keyFile == "" {
+		keyFile = path.Join(req.KeyPath, url.PathEscape(os.Getenv("USER"))+".pem")
+	}
+	privateKey, err := utils.PrivateKeyFromFile(keyFile)
 	if err != nil {
 		log.WithError(err).Errorln("Failed to decrypt secret.")
 		return err
@@ -157,3 +183,116 @@ func (req DonePacket) Done() bool {
 	log.WithField("done", true).Traceln("--> connection.DonePacket.Done")
 	return true
 }
+
+// =============== Reject Packet ===============
+
+type RejectPacket struct {
+	Reason string
+}
+
+func (req RejectPacket) Ask(in io.Reader, out io.Writer) error {
+	log.WithFields(log.Fields{
+		"in":  &in,
+		"out": &out,
+	}).Traceln("--> connection.RejectPacket.Ask")
+	err := errors.New(req.Reason)
+	log.WithError(err).Errorln("Server rejected the connection.")
+	return err
+}
+
+func (req RejectPacket) String() string {
+	log.Traceln("--> connection.RejectPacket.String")
+	return fmt.Sprintf("?R:%s\n", req.Reason)
+}
+
+func (req RejectPacket) Done() bool {
+	log.WithField("done", true).Traceln("--> connection.RejectPacket.Done")
+	return true
+}
+
+// =============== Banner Packet ===============
+
+// The longest banner a client accepts.
+const MaxBannerLength = 64 * 1024
+
+// A BannerPacket announces a banner of Length bytes, which follow right after the packet.
+type BannerPacket struct {
+	Length int
+}
+
+func (req BannerPacket) Ask(in io.Reader, out io.Writer) error {
+	log.WithFields(log.Fields{
+		"in":  &in,
+		"out": &out,
+	}).Traceln("--> connection.BannerPacket.Ask")
+	banner := make([]byte, req.Length)
+	if _, err := io.ReadFull(in, banner); err != nil {
+		log.WithError(err).Errorln("Failed to receive banner.")
+		return err
+	}
+	if _, err := out.Write(banner); err != nil {
+		log.WithError(err).Errorln("Failed to display banner.")
+		return err
+	}
+	return nil
+}
+
+func (req BannerPacket) String() string {
+	log.Traceln("--> connection.BannerPacket.String")
+	return fmt.Sprintf("?B%d:\n", req.Length)
+}
+
+func (req BannerPacket) Done() bool {
+	log.WithField("done", false).Traceln("--> connection.BannerPacket.Done")
+	return false
+}
+
+// =============== Session Packet ===============
+
+// A SessionPacket hands the client the token it needs to resume the session after the connection dropped, along with
+// the offset of the output the session starts sending.
+type SessionPacket struct {
+	Token  string
+	Offset int64
+}
+
+func (req SessionPacket) Ask(in io.Reader, out io.Writer) error {
+	log.WithFields(log.Fields{
+		"in":  &in,
+		"out": &out,
+	}).Traceln("--> connection.SessionPacket.Ask")
+	return nil
+}
+
+func (req SessionPacket) String() string {
+	log.Traceln("--> connection.SessionPacket.String")
+	return fmt.Sprintf("?S:%s:%d\n", req.Token, req.Offset)
+}
+
+func (req SessionPacket) Done() bool {
+	log.WithField("done", false).Traceln("--> connection.SessionPacket.Done")
+	return false
+}
+
+// =============== Mux Packet ===============
+
+// A MuxPacket tells the client that channels get multiplexed over the connection once the transfer is done.
+type MuxPacket struct{}
+
+func (req MuxPacket) Ask(in io.Reader, out io.Writer) error {
+	log.WithFields(log.Fields{
+		"in":  &in,
+		"out": &out,
+	}).Traceln("--> connection.MuxPacket.Ask")
+	return nil
+}
+
+func (req MuxPacket) String() string {
+	log.Traceln("--> connection.MuxPacket.String")
+	return "?M:\n"
+}
+
+func (req MuxPacket) Done() bool {
+	log.WithField("done", false).Traceln("--> connection.MuxPacket.Done")
+	return false
+}
@@ -0,0 +1,249 @@
+package deltasync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"math"
+)
+
+// The length of the strong checksum of a block, a truncated SHA-256.
+const strongSize = 16
+
+// rolling is the weak checksum of rsync, which can be moved along the data a byte at a time.
+type rolling struct {
+	a, b uint32
+	n    uint32 // The length of the window.
+}
+
+func newRolling(data []byte) rolling {
+	sum := rolling{n: uint32(len(data))}
+	for i, value := range data {
+		sum.a += uint32(value)
+		sum.b += uint32(len(data)-i) * uint32(value)
+	}
+	return sum
+}
+
+// roll moves the window by a byte, dropping out and adding in.
+func (sum *rolling) roll(out byte, in byte) {
+	sum.a += uint32(in) - uint32(out)
+	sum.b += sum.a - sum.n*uint32(out)
+}
+
+// rollOut shrinks the window by dropping out, at the end of the data.
+func (sum *rolling) rollOut(out byte) {
+	sum.b -= sum.n * uint32(out)
+	sum.a -= uint32(out)
+	sum.n--
+}
+
+func (sum rolling) value() uint32 {
+	return sum.a&0xffff | sum.b<<16
+}
+
+func strongSum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:strongSize]
+}
+
+// blockSizeFor returns the block size for a file of the size, roughly its square root but small enough for the
+// checksums of all blocks to fit into a Signature.
+func blockSizeFor(size int64) int {
+	blockSize := int64(math.Sqrt(float64(size)))
+	if perMessage := (size + maxBlocks - 1) / maxBlocks; perMessage > blockSize {
+		blockSize = perMessage
+	}
+	if blockSize < minBlockSize {
+		blockSize = minBlockSize
+	}
+	return int((blockSize + 7) / 8 * 8)
+}
+
+// A block is what the receiver has of a file already.
+type block struct {
+	weak   uint32
+	strong []byte
+}
+
+// A signature lists the checksums of the blocks of the file the receiver has already.
+type signature struct {
+	blockSize int
+	size      int64
+	blocks    []block
+}
+
+// newSignature reads the file of the size and computes the checksums of its blocks.
+func newSignature(file io.Reader, size int64) (*signature, error) {
+	sig := &signature{blockSize: blockSizeFor(size), size: size}
+	data := make([]byte, sig.blockSize)
+	for offset := int64(0); offset < size; offset += int64(sig.blockSize) {
+		n := sig.blockLength(len(sig.blocks))
+		if _, err := io.ReadFull(file, data[:n]); err != nil {
+			return nil, err
+		}
+		sig.blocks = append(sig.blocks, block{weak: newRolling(data[:n]).value(), strong: strongSum(data[:n])})
+	}
+	return sig, nil
+}
+
+// blockLength returns the length of the block, which is shorter than the others for the last one.
+func (sig *signature) blockLength(index int) int {
+	if rest := sig.size - int64(index)*int64(sig.blockSize); rest < int64(sig.blockSize) {
+		return int(rest)
+	}
+	return sig.blockSize
+}
+
+// encodeSignature appends the Signature of the file with the index.
+func encodeSignature(encoder *codec.Encoder, index uint32, sig *signature) *codec.Encoder {
+	encoder.Uint32(index).Uint32(uint32(sig.blockSize)).Uint64(uint64(sig.size)).Uint32(uint32(len(sig.blocks)))
+	for _, block := range sig.blocks {
+		encoder.Uint32(block.weak).Raw(block.strong)
+	}
+	return encoder
+}
+
+// decodeSignature decodes a Signature and checks that its blocks make up the size.
+func decodeSignature(decoder *codec.Decoder) (uint32, *signature) {
+	index := decoder.Uint32()
+	sig := &signature{blockSize: int(decoder.Uint32()), size: int64(decoder.Uint64())}
+	count := decoder.Uint32()
+	if decoder.Err == nil && (sig.blockSize <= 0 || sig.size < 0 || count > maxBlocks ||
+		int64(count) != (sig.size+int64(sig.blockSize)-1)/int64(sig.blockSize)) {
+		decoder.Err = errors.New("invalid signature")
+		return index, nil
+	}
+	for i := uint32(0); i < count && decoder.Err == nil; i++ {
+		sig.blocks = append(sig.blocks, block{weak: decoder.Uint32(), strong: decoder.Next(strongSize)})
+	}
+	return index, sig
+}
+
+// A delta writes the instructions to turn the file of the receiver into the one of the sender.
+type delta struct {
+	peer    *peer
+	literal []byte
+	start   int // The first block of the pending Copy.
+	count   int // The number of blocks of the pending Copy.
+	stats   Stats
+}
+
+// generate reads the file of the sender and writes its delta against the signature. It returns the SHA-256 of the
+// file.
+func (delta *delta) generate(file io.Reader, sig *signature) ([]byte, error) {
+	table := map[uint32][]int{}
+	for index, block := range sig.blocks {
+		table[block.weak] = append(table[block.weak], index)
+	}
+	hash := sha256.New()
+	in := bufio.NewReaderSize(io.TeeReader(file, hash), sig.blockSize+1)
+	var sum rolling
+	valid := false
+	for {
+		window, err := in.Peek(sig.blockSize)
+		if len(window) == 0 {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if !valid {
+			sum, valid = newRolling(window), true
+		}
+		if index, ok := sig.match(table, sum.value(), window); ok {
+			if err := delta.copyBlock(index, len(window)); err != nil {
+				return nil, err
+			}
+			_, _ = in.Discard(len(window))
+			valid = false
+			continue
+		}
+		out, _ := in.ReadByte()
+		if err := delta.literalByte(out); err != nil {
+			return nil, err
+		}
+		if next, _ := in.Peek(sig.blockSize); len(next) == int(sum.n) {
+			sum.roll(out, next[len(next)-1])
+		} else {
+			sum.rollOut(out)
+		}
+	}
+	if err := delta.flush(); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// match looks for a block with the checksums of the window.
+func (sig *signature) match(table map[uint32][]int, weak uint32, window []byte) (int, bool) {
+	var strong []byte
+	for _, index := range table[weak] {
+		if sig.blockLength(index) != len(window) {
+			continue
+		}
+		if strong == nil {
+			strong = strongSum(window)
+		}
+		if bytes.Equal(strong, sig.blocks[index].strong) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// copyBlock adds the block to the pending Copy if it follows it, or starts a new one.
+func (delta *delta) copyBlock(index int, length int) error {
+	delta.stats.Matched += int64(length)
+	if len(delta.literal) > 0 {
+		if err := delta.flush(); err != nil {
+			return err
+		}
+	}
+	if delta.count > 0 && delta.start+delta.count == index {
+		delta.count++
+		return nil
+	}
+	if err := delta.flush(); err != nil {
+		return err
+	}
+	delta.start, delta.count = index, 1
+	return nil
+}
+
+func (delta *delta) literalByte(value byte) error {
+	delta.stats.Literal++
+	if delta.count > 0 {
+		if err := delta.flush(); err != nil {
+			return err
+		}
+	}
+	delta.literal = append(delta.literal, value)
+	if len(delta.literal) >= maxLiteral {
+		return delta.flush()
+	}
+	return nil
+}
+
+// flush writes what is pending.
+func (delta *delta) flush() error {
+	if delta.count > 0 {
+		msg := new(codec.Encoder).Uint32(uint32(delta.start)).Uint32(uint32(delta.count))
+		if err := delta.peer.write(msgCopy, msg); err != nil {
+			return err
+		}
+		delta.count = 0
+	}
+	if len(delta.literal) > 0 {
+		if err := delta.peer.write(msgLiteral, new(codec.Encoder).Bytes(delta.literal)); err != nil {
+			return err
+		}
+		delta.literal = delta.literal[:0]
+	}
+	return nil
+}
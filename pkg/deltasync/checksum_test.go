@@ -0,0 +1,103 @@
+package deltasync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestRolling(t *testing.T) {
+	data := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(data)
+	sum := newRolling(data[:10])
+	for i := 10; i < len(data); i++ {
+		sum.roll(data[i-10], data[i])
+		if sum.value() != newRolling(data[i-9:i+1]).value() {
+			t.Fatalf("Rolled to the wrong checksum at %d.", i)
+		}
+	}
+	for i := len(data) - 10; i < len(data)-1; i++ {
+		sum.rollOut(data[i])
+		if sum.value() != newRolling(data[i+1:]).value() {
+			t.Fatalf("Rolled out to the wrong checksum at %d.", i)
+		}
+	}
+}
+
+func TestBlockSizeFor(t *testing.T) {
+	for _, size := range []int64{0, 1000, 1 << 20, 1 << 30, 1 << 40} {
+		blockSize := blockSizeFor(size)
+		if blockSize < minBlockSize || blockSize%8 != 0 {
+			t.Errorf("Got block size %d for %d.", blockSize, size)
+		}
+		if blocks := (size + int64(blockSize) - 1) / int64(blockSize); blocks > maxBlocks {
+			t.Errorf("Got %d blocks for %d.", blocks, size)
+		}
+	}
+}
+
+// applyDelta generates the delta of data against basis and rebuilds data from it.
+func applyDelta(t *testing.T, basis []byte, data []byte) *builder {
+	sig, err := newSignature(bytes.NewReader(basis), int64(len(basis)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, otherConn := net.Pipe()
+	defer conn.Close()
+	done := make(chan error)
+	go func() {
+		delta := &delta{peer: newPeer(otherConn)}
+		sum, err := delta.generate(bytes.NewReader(data), sig)
+		if err == nil {
+			err = delta.peer.write(msgFileEnd, new(codec.Encoder).Bytes(sum))
+		}
+		done <- err
+	}()
+	basisFile := writeTemp(t, basis)
+	out := writeTemp(t, nil)
+	builder := &builder{out: out, hash: sha256.New()}
+	if err := builder.build(newPeer(conn), basisFile, sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if builder.err != nil {
+		t.Fatal(builder.err)
+	}
+	rebuilt, _ := os.ReadFile(out.Name())
+	if !bytes.Equal(rebuilt, data) {
+		t.Error("Rebuilt different data.")
+	}
+	return builder
+}
+
+func TestDelta(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	basis := make([]byte, 100*1000)
+	random.Read(basis)
+
+	// The same data only needs blocks of the basis.
+	if builder := applyDelta(t, basis, basis); builder.literal != 0 {
+		t.Errorf("Sent %d literal bytes for the same data.", builder.literal)
+	}
+	// Inserted data shifts the blocks after it, which still match.
+	inserted := append(append(append([]byte{}, basis[:5000]...), []byte("inserted")...), basis[5000:]...)
+	if builder := applyDelta(t, basis, inserted); builder.literal > int64(2*blockSizeFor(int64(len(basis)))) {
+		t.Errorf("Sent %d literal bytes for an insertion.", builder.literal)
+	}
+	// A changed tail, shorter than a block.
+	changed := append(append([]byte{}, basis[:len(basis)-10]...), []byte("changed")...)
+	if builder := applyDelta(t, basis, changed); builder.matched == 0 {
+		t.Error("Matched nothing of a changed tail.")
+	}
+	// Without a basis everything is literal.
+	if builder := applyDelta(t, nil, basis); builder.literal != int64(len(basis)) {
+		t.Errorf("Sent %d literal bytes without a basis.", builder.literal)
+	}
+	applyDelta(t, basis, nil)
+}
@@ -0,0 +1,85 @@
+package deltasync
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+)
+
+// Push synchronizes the tree at target on the server with the local one at source. Failures of single entries get
+// reported to Options.Changed and make Push return ErrIncomplete in the end.
+func Push(conn io.ReadWriter, source string, target string, options Options) (Stats, error) {
+	log.WithFields(log.Fields{
+		"source": source,
+		"target": target,
+	}).Traceln("--> deltasync.Push")
+	ErrorMsg := "Failed to push."
+	if err := checkRules(options.Rules); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	if err := checkRoot(source); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	peer := newPeer(conn)
+	if err := peer.write(msgCommand, encodeCommand(new(codec.Encoder), directionPush, target, options)); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	if err := peer.readStatus(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	sender := &sender{peer: peer, options: options, root: source}
+	if err := sender.list(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	stats, err := sender.serve()
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return stats, err
+	}
+	if stats.Failures > 0 {
+		return stats, ErrIncomplete
+	}
+	return stats, nil
+}
+
+// Pull synchronizes the local tree at target with the one at source on the server. Failures of single entries get
+// reported to Options.Changed and make Pull return ErrIncomplete in the end.
+func Pull(conn io.ReadWriter, source string, target string, options Options) (Stats, error) {
+	log.WithFields(log.Fields{
+		"source": source,
+		"target": target,
+	}).Traceln("--> deltasync.Pull")
+	ErrorMsg := "Failed to pull."
+	if err := checkRules(options.Rules); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	if err := prepareRoot(target, options.DryRun); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	peer := newPeer(conn)
+	if err := peer.write(msgCommand, encodeCommand(new(codec.Encoder), directionGet, source, options)); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	if err := peer.readStatus(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return Stats{}, err
+	}
+	receiver := &receiver{peer: peer, options: options, root: target}
+	stats, err := receiver.receive()
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return stats, err
+	}
+	if stats.Failures > 0 {
+		return stats, ErrIncomplete
+	}
+	return stats, nil
+}
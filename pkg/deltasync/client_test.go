@@ -0,0 +1,200 @@
+package deltasync
+
+import (
+	"errors"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/pipetest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemp returns a temporary file with the data.
+func writeTemp(t *testing.T, data []byte) *os.File {
+	file, err := os.CreateTemp(t.TempDir(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = file.Close()
+	})
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// writeTree writes the files, given by relative path, below root with an mtime in the past.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Unix(1500000000, 0)
+		if err := os.Chtimes(filePath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the regular files below root by relative path.
+func readTree(t *testing.T, root string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(filePath)
+		relPath, _ := filepath.Rel(root, filePath)
+		files[filepath.ToSlash(relPath)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func checkTree(t *testing.T, root string, want map[string]string) {
+	t.Helper()
+	got := readTree(t, root)
+	if len(got) != len(want) {
+		t.Errorf("Got files %v instead of %v.", got, want)
+		return
+	}
+	for name, data := range want {
+		if got[name] != data {
+			t.Errorf("%s contains %q instead of %q.", name, got[name], data)
+		}
+	}
+}
+
+// recorder records the changes of a sync.
+type recorder struct {
+	changes []string
+}
+
+func (recorder *recorder) options(options Options) Options {
+	recorder.changes = nil
+	options.Changed = func(change Change) {
+		recorder.changes = append(recorder.changes, change.String())
+	}
+	return options
+}
+
+func (recorder *recorder) check(t *testing.T, want ...string) {
+	t.Helper()
+	sort.Strings(recorder.changes)
+	sort.Strings(want)
+	if strings.Join(recorder.changes, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Got changes %q instead of %q.", recorder.changes, want)
+	}
+}
+
+func TestPush(t *testing.T) {
+	local, remote := t.TempDir(), filepath.Join(t.TempDir(), "copy")
+	writeTree(t, local, map[string]string{"a.txt": "alpha", "sub/b.txt": strings.Repeat("beta", 1000)})
+	if err := os.Symlink("a.txt", filepath.Join(local, "link")); err != nil {
+		t.Fatal(err)
+	}
+	conn := pipetest.Serve(t, Serve)
+	recorder := &recorder{}
+
+	if _, err := Push(conn, local, remote, recorder.options(Options{})); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, remote, readTree(t, local))
+	recorder.check(t, "create a.txt", "mkdir sub", "create sub/b.txt", "symlink link")
+	if link, err := os.Readlink(filepath.Join(remote, "link")); err != nil || link != "a.txt" {
+		t.Errorf("Got link %q: %v", link, err)
+	}
+
+	// Only what changed gets synchronized, and mostly from blocks the server has already.
+	writeTree(t, local, map[string]string{"sub/b.txt": strings.Repeat("beta", 1000) + "gamma"})
+	stats, err := Push(conn, local, remote, recorder.options(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, remote, readTree(t, local))
+	recorder.check(t, "update sub/b.txt")
+	if stats.Files != 1 || stats.Matched == 0 || stats.Literal >= stats.Matched {
+		t.Errorf("Got stats %+v.", stats)
+	}
+}
+
+func TestPush_DeleteAndRules(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeTree(t, local, map[string]string{"keep.txt": "keep", "skip.log": "log", "build/out": "out"})
+	writeTree(t, remote, map[string]string{"keep.txt": "old", "extra.txt": "extra", "old/file": "file",
+		"local.log": "protected"})
+	conn := pipetest.Serve(t, Serve)
+	recorder := &recorder{}
+	options := Options{Rules: []Rule{Exclude("*.log"), Exclude("/build/")}, Delete: true}
+
+	// A dry run changes nothing.
+	options.DryRun = true
+	if _, err := Push(conn, local, remote, recorder.options(options)); err != nil {
+		t.Fatal(err)
+	}
+	recorder.check(t, "update keep.txt", "delete extra.txt", "delete old")
+	if readTree(t, remote)["keep.txt"] != "old" {
+		t.Error("Changed a file in a dry run.")
+	}
+
+	options.DryRun = false
+	if _, err := Push(conn, local, remote, recorder.options(options)); err != nil {
+		t.Fatal(err)
+	}
+	recorder.check(t, "update keep.txt", "delete extra.txt", "delete old")
+	checkTree(t, remote, map[string]string{"keep.txt": "keep", "local.log": "protected"})
+}
+
+func TestPull(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeTree(t, remote, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta", "sub/c.tmp": "tmp"})
+	writeTree(t, local, map[string]string{"sub": "a file where the server has a directory"})
+	conn := pipetest.Serve(t, Serve)
+	recorder := &recorder{}
+
+	options := Options{Rules: []Rule{Include("sub/"), Exclude("*.tmp")}}
+	if _, err := Pull(conn, remote, local, recorder.options(options)); err != nil {
+		t.Fatal(err)
+	}
+	recorder.check(t, "create a.txt", "mkdir sub", "create sub/b.txt")
+	checkTree(t, local, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"})
+	if info, err := os.Stat(filepath.Join(local, "a.txt")); err != nil || info.ModTime().Unix() != 1500000000 {
+		t.Errorf("Did not preserve the mtime: %v", err)
+	}
+
+	if _, err := Pull(conn, filepath.Join(remote, "missing"), local, Options{}); err == nil {
+		t.Error("Pulled a missing tree.")
+	}
+	// The connection is still usable after a failed command.
+	if _, err := Pull(conn, remote, local, recorder.options(options)); err != nil {
+		t.Fatal(err)
+	}
+	recorder.check(t)
+}
+
+func TestPush_Failures(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Root can read anything.")
+	}
+	local, remote := t.TempDir(), t.TempDir()
+	writeTree(t, local, map[string]string{"readable": "data", "unreadable": "secret"})
+	if err := os.Chmod(filepath.Join(local, "unreadable"), 0); err != nil {
+		t.Fatal(err)
+	}
+	conn := pipetest.Serve(t, Serve)
+	stats, err := Push(conn, local, remote, Options{})
+	if !errors.Is(err, ErrIncomplete) || stats.Failures != 1 {
+		t.Errorf("Got stats %+v: %v", stats, err)
+	}
+	checkTree(t, remote, map[string]string{"readable": "data"})
+}
@@ -0,0 +1,291 @@
+// Package deltasync synchronizes directory trees in either direction over a connection to the sync subsystem. Like
+// rsync, only the blocks of changed files that the other side does not have already go over the connection.
+//
+// Every message starts with its type as one byte and the length of the payload as uint32. Integers are big-endian,
+// strings and data are prefixed with their length as uint32. The client starts with a command:
+//
+//	Command <direction> <flags> <path> <count> (<include> <pattern>)...
+//
+// The direction is P to push into the path or G to get the tree at the path, the flags are flagDelete and flagDryRun.
+// The server answers Status <message>, which is empty if it accepts the command. Then the sender lists the tree with
+// an Entry <path> <kind> <mode> <size> <mtime> <link> for every directory, file and symlink, parents first, and ends
+// the list with EndList. The receiver goes through the list and answers every file it needs with a
+// Signature <index> <block size> <size> <count> (<weak> <strong>)... of what it has of the file already. The sender
+// answers it with the delta, a sequence of Copy <block> <count> for blocks the receiver has and Literal <data> for
+// everything else, followed by FileEnd <sha256> or FileError <message>. The receiver reports what it changes with
+// Change <action> <path> <error> and ends the sync with Done <stats>.
+package deltasync
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The name of the subsystem serving the protocol.
+const Subsystem = "sync"
+
+// Message types.
+const (
+	msgCommand   = 1
+	msgStatus    = 2
+	msgEntry     = 3
+	msgEndList   = 4
+	msgSignature = 5
+	msgCopy      = 6
+	msgLiteral   = 7
+	msgFileEnd   = 8
+	msgFileError = 9
+	msgChange    = 10
+	msgDone      = 11
+)
+
+// Directions of a command.
+const (
+	directionPush = 'P'
+	directionGet  = 'G'
+)
+
+// Flags of a command.
+const (
+	flagDelete = 1 << iota
+	flagDryRun
+)
+
+// Kinds of entries.
+const (
+	kindDirectory = 'd'
+	kindFile      = 'f'
+	kindSymlink   = 'l'
+)
+
+const (
+	// The largest message either side accepts.
+	maxMessage = 1024 * 1024
+	// The most data a Literal carries.
+	maxLiteral = 64 * 1024
+	// The smallest block size, below which the signatures would outweigh the blocks.
+	minBlockSize = 700
+	// The most blocks a Signature holds, each with a weak and a strong checksum.
+	maxBlocks = (maxMessage - 64) / (4 + strongSize)
+)
+
+// ErrIncomplete is returned when some of the entries could not be synchronized.
+var ErrIncomplete = errors.New("some files were not synchronized")
+
+// Options of a sync.
+type Options struct {
+	Rules  []Rule // Decide which paths get synchronized, see Rule.
+	Delete bool   // Delete what the receiver has beyond the tree of the sender, unless it is excluded.
+	DryRun bool   // Only report the changes.
+	// Changed gets called for every change the receiver makes, or would make in a dry run, if set.
+	Changed func(change Change)
+}
+
+func (options Options) flags() byte {
+	var flags byte
+	if options.Delete {
+		flags |= flagDelete
+	}
+	if options.DryRun {
+		flags |= flagDryRun
+	}
+	return flags
+}
+
+// Actions of a change.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionMkdir   = "mkdir"
+	ActionSymlink = "symlink"
+	ActionDelete  = "delete"
+	ActionFailed  = "failed"
+)
+
+// A Change is something the receiver changed in its tree. Paths are relative to the root of the tree.
+type Change struct {
+	Action string
+	Path   string
+	Err    error // Why the entry failed to synchronize, for ActionFailed.
+}
+
+func (change Change) String() string {
+	if change.Err != nil {
+		return fmt.Sprintf("%s %s: %s", change.Action, change.Path, change.Err.Error())
+	}
+	return change.Action + " " + change.Path
+}
+
+// Stats sum up a sync.
+type Stats struct {
+	Files    int   // Files transferred.
+	Literal  int64 // Bytes sent as they are.
+	Matched  int64 // Bytes the receiver had already.
+	Deleted  int   // Entries deleted.
+	Failures int   // Entries that failed to synchronize.
+}
+
+// An entry is a directory, file or symlink of the tree of the sender.
+type entry struct {
+	path  string // Relative to the root, with slashes.
+	kind  byte
+	mode  os.FileMode
+	size  int64
+	mtime time.Time
+	link  string // The target of a symlink.
+}
+
+// validPath checks that the path of an entry from the other side stays within the tree.
+func validPath(entryPath string) error {
+	if entryPath == "" || path.IsAbs(entryPath) || strings.ContainsRune(entryPath, 0) {
+		return fmt.Errorf("invalid path %q", entryPath)
+	}
+	for _, name := range strings.Split(entryPath, "/") {
+		if name == "" || name == "." || name == ".." {
+			return fmt.Errorf("invalid path %q", entryPath)
+		}
+	}
+	return nil
+}
+
+// peer reads and writes the messages of the connection.
+type peer struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPeer(conn io.ReadWriter) *peer {
+	return &peer{in: bufio.NewReader(conn), out: conn}
+}
+
+// write writes a message of the type.
+func (peer *peer) write(msgType byte, payload *codec.Encoder) error {
+	data := payload.Data
+	if len(data) > maxMessage {
+		return errors.New("message too large")
+	}
+	header := make([]byte, 5, 5+len(data))
+	header[0] = msgType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	_, err := peer.out.Write(append(header, data...))
+	return err
+}
+
+// read reads a message and returns its type along with the payload.
+func (peer *peer) read() (byte, *codec.Decoder, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(peer.in, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxMessage {
+		return 0, nil, fmt.Errorf("invalid message length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(peer.in, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], codec.NewDecoder(payload), nil
+}
+
+// expect reads a message that has to be of the type.
+func (peer *peer) expect(msgType byte) (*codec.Decoder, error) {
+	gotType, msg, err := peer.read()
+	if err != nil {
+		return nil, err
+	}
+	if gotType != msgType {
+		return nil, fmt.Errorf("unexpected message %d instead of %d", gotType, msgType)
+	}
+	return msg, nil
+}
+
+// writeStatus answers a command with the error, if any.
+func (peer *peer) writeStatus(err error) error {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	return peer.write(msgStatus, new(codec.Encoder).Text(msg))
+}
+
+func (peer *peer) readStatus() error {
+	msg, err := peer.expect(msgStatus)
+	if err != nil {
+		return err
+	}
+	status := msg.Text()
+	if msg.Err != nil {
+		return msg.Err
+	} else if status != "" {
+		return errors.New(status)
+	}
+	return nil
+}
+
+func (peer *peer) writeChange(change Change) error {
+	errMsg := ""
+	if change.Err != nil {
+		errMsg = change.Err.Error()
+	}
+	return peer.write(msgChange, new(codec.Encoder).Text(change.Action).Text(change.Path).Text(errMsg))
+}
+
+func readChange(msg *codec.Decoder) (Change, error) {
+	change := Change{Action: msg.Text(), Path: msg.Text()}
+	if errMsg := msg.Text(); errMsg != "" {
+		change.Err = errors.New(errMsg)
+	}
+	return change, msg.Err
+}
+
+func (peer *peer) writeDone(stats Stats) error {
+	return peer.write(msgDone, new(codec.Encoder).Uint32(uint32(stats.Files)).Uint64(uint64(stats.Literal)).
+		Uint64(uint64(stats.Matched)).Uint32(uint32(stats.Deleted)).Uint32(uint32(stats.Failures)))
+}
+
+func readDone(msg *codec.Decoder) (Stats, error) {
+	stats := Stats{
+		Files:    int(msg.Uint32()),
+		Literal:  int64(msg.Uint64()),
+		Matched:  int64(msg.Uint64()),
+		Deleted:  int(msg.Uint32()),
+		Failures: int(msg.Uint32()),
+	}
+	return stats, msg.Err
+}
+
+// encodeEntry appends the entry of a file list.
+func encodeEntry(encoder *codec.Encoder, entry *entry) *codec.Encoder {
+	return encoder.Text(entry.path).Byte(entry.kind).Uint32(uint32(entry.mode)).Uint64(uint64(entry.size)).
+		Uint64(uint64(entry.mtime.Unix())).Text(entry.link)
+}
+
+// decodeEntry reads an entry encodeEntry appended.
+func decodeEntry(decoder *codec.Decoder) *entry {
+	return &entry{
+		path:  decoder.Text(),
+		kind:  decoder.Byte(),
+		mode:  os.FileMode(decoder.Uint32()),
+		size:  int64(decoder.Uint64()),
+		mtime: time.Unix(int64(decoder.Uint64()), 0),
+		link:  decoder.Text(),
+	}
+}
+
+// changed logs the change and passes it on to Options.Changed.
+func (options Options) changed(change Change) {
+	log.WithField("change", change.String()).Debugln("Synchronized entry.")
+	if options.Changed != nil {
+		options.Changed(change)
+	}
+}
@@ -0,0 +1,78 @@
+package deltasync
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A Rule includes or excludes the paths matching its pattern. The first rule matching a path decides, paths no rule
+// matches are included. Excluded directories are skipped along with everything in them, and the receiver does not
+// delete what is excluded.
+//
+// Patterns are those of path.Match. A pattern without a slash matches the name of an entry at any depth, otherwise it
+// matches the path relative to the root, which a leading slash stands for. A trailing slash only matches directories.
+type Rule struct {
+	Include bool
+	Pattern string
+}
+
+// Include returns a rule including the paths that match the pattern.
+func Include(pattern string) Rule {
+	return Rule{Include: true, Pattern: pattern}
+}
+
+// Exclude returns a rule excluding the paths that match the pattern.
+func Exclude(pattern string) Rule {
+	return Rule{Include: false, Pattern: pattern}
+}
+
+func (rule Rule) String() string {
+	if rule.Include {
+		return "+ " + rule.Pattern
+	}
+	return "- " + rule.Pattern
+}
+
+// matches tells whether the rule applies to the path of an entry.
+func (rule Rule) matches(entryPath string, isDir bool) bool {
+	pattern := rule.Pattern
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	name := entryPath
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else if !strings.Contains(pattern, "/") {
+		name = path.Base(entryPath)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// checkRules checks that the patterns of the rules are valid.
+func checkRules(rules []Rule) error {
+	for _, rule := range rules {
+		pattern := strings.Trim(rule.Pattern, "/")
+		if pattern == "" {
+			return fmt.Errorf("empty pattern %q", rule.Pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// excluded tells whether the rules exclude the path of an entry.
+func excluded(rules []Rule, entryPath string, isDir bool) bool {
+	for _, rule := range rules {
+		if rule.matches(entryPath, isDir) {
+			return !rule.Include
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package deltasync
+
+import "testing"
+
+func TestExcluded(t *testing.T) {
+	rules := []Rule{Include("keep.log"), Exclude("*.log"), Exclude("/build/"), Exclude("docs/*.tmp")}
+	tests := []struct {
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{"a.txt", false, false},
+		{"a.log", false, true},
+		{"deep/down/a.log", false, true},
+		{"deep/keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build", true, false},
+		{"docs/a.tmp", false, true},
+		{"sub/docs/a.tmp", false, false},
+	}
+	for _, test := range tests {
+		if got := excluded(rules, test.path, test.isDir); got != test.excluded {
+			t.Errorf("Excluded %s (dir %v): %v", test.path, test.isDir, got)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	if err := checkRules([]Rule{Exclude("*.log"), Include("/a/b/")}); err != nil {
+		t.Error(err)
+	}
+	for _, pattern := range []string{"[", "/", ""} {
+		if err := checkRules([]Rule{Exclude(pattern)}); err == nil {
+			t.Errorf("Accepted pattern %q.", pattern)
+		}
+	}
+}
+
+func TestValidPath(t *testing.T) {
+	for _, entryPath := range []string{"a", "a/b", "a/.b"} {
+		if err := validPath(entryPath); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, entryPath := range []string{"", "/a", "a/../../b", "..", "a//b", "a/", "./a"} {
+		if err := validPath(entryPath); err == nil {
+			t.Errorf("Accepted path %q.", entryPath)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package deltasync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"hash"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// A receiver brings the tree below root in line with the list of the sender.
+type receiver struct {
+	peer        *peer
+	options     Options
+	root        string
+	entries     []*entry
+	paths       map[string]*entry
+	failed      []Change // Failures the sender reported while listing.
+	directories []*entry // Directories that get their mode and mtime once everything in them is synchronized.
+	stats       Stats
+}
+
+// receive synchronizes the tree and returns what it did. The error is about the connection, failures of single
+// entries get reported to both sides.
+func (receiver *receiver) receive() (Stats, error) {
+	log.WithField("root", receiver.root).Traceln("--> deltasync.receiver.receive")
+	if err := receiver.readList(); err != nil {
+		return Stats{}, err
+	}
+	for _, change := range receiver.failed {
+		if err := receiver.report(change); err != nil {
+			return Stats{}, err
+		}
+	}
+	for index, entry := range receiver.entries {
+		if err := receiver.sync(uint32(index), entry); err != nil {
+			return Stats{}, err
+		}
+	}
+	if receiver.options.Delete {
+		if err := receiver.deleteExtraneous(); err != nil {
+			return Stats{}, err
+		}
+	}
+	for i := len(receiver.directories) - 1; i >= 0; i-- {
+		dir := receiver.directories[i]
+		dirPath := receiver.local(dir.path)
+		if err := preserve(dirPath, dir.mode, dir.mtime); err != nil {
+			if err := receiver.report(Change{Action: ActionFailed, Path: dir.path, Err: err}); err != nil {
+				return Stats{}, err
+			}
+		}
+	}
+	return receiver.stats, receiver.peer.writeDone(receiver.stats)
+}
+
+// readList reads the entries of the sender and checks that every one of them is within a directory of the list.
+func (receiver *receiver) readList() error {
+	receiver.paths = map[string]*entry{}
+	for {
+		msgType, msg, err := receiver.peer.read()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case msgEntry:
+			entry := decodeEntry(msg)
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if err := validPath(entry.path); err != nil {
+				return err
+			}
+			if parent := path.Dir(entry.path); parent != "." {
+				if dir, ok := receiver.paths[parent]; !ok || dir.kind != kindDirectory {
+					return fmt.Errorf("%s is not within a directory of the list", entry.path)
+				}
+			}
+			if _, ok := receiver.paths[entry.path]; ok {
+				return fmt.Errorf("%s is listed twice", entry.path)
+			}
+			if entry.kind != kindDirectory && entry.kind != kindFile && entry.kind != kindSymlink {
+				return fmt.Errorf("%s is of unknown kind %q", entry.path, entry.kind)
+			}
+			receiver.entries = append(receiver.entries, entry)
+			receiver.paths[entry.path] = entry
+		case msgChange:
+			change, err := readChange(msg)
+			if err != nil {
+				return err
+			}
+			receiver.failed = append(receiver.failed, change)
+		case msgEndList:
+			return nil
+		default:
+			return fmt.Errorf("unexpected message %d", msgType)
+		}
+	}
+}
+
+// report tells both sides about the change.
+func (receiver *receiver) report(change Change) error {
+	if change.Action == ActionFailed {
+		receiver.stats.Failures++
+		log.WithError(change.Err).WithField("path", change.Path).Warnln("Failed to synchronize entry.")
+	}
+	receiver.options.changed(change)
+	return receiver.peer.writeChange(change)
+}
+
+// local returns the path of the entry in the local tree.
+func (receiver *receiver) local(entryPath string) string {
+	return filepath.Join(receiver.root, filepath.FromSlash(entryPath))
+}
+
+// sync brings the entry in line with the one of the sender.
+func (receiver *receiver) sync(index uint32, entry *entry) error {
+	localPath := receiver.local(entry.path)
+	existing, err := os.Lstat(localPath)
+	if err != nil {
+		existing, err = nil, nil
+	}
+	dryRun := receiver.options.DryRun
+	var action string
+	switch entry.kind {
+	case kindDirectory:
+		if existing == nil || !existing.IsDir() {
+			action = ActionMkdir
+			if !dryRun {
+				// The directory stays accessible until everything in it got synchronized.
+				err = replace(localPath, existing, func() error { return os.Mkdir(localPath, 0700) })
+			}
+		}
+		if !dryRun && err == nil {
+			receiver.directories = append(receiver.directories, entry)
+		}
+	case kindSymlink:
+		if existing != nil && existing.Mode()&fs.ModeSymlink != 0 {
+			if link, _ := os.Readlink(localPath); link == entry.link {
+				return nil
+			}
+		}
+		action = ActionSymlink
+		if !dryRun {
+			err = replace(localPath, existing, func() error { return os.Symlink(entry.link, localPath) })
+		}
+	case kindFile:
+		regular := existing != nil && existing.Mode().IsRegular()
+		if regular && existing.Size() == entry.size && existing.ModTime().Unix() == entry.mtime.Unix() {
+			if existing.Mode().Perm() != entry.mode && !dryRun {
+				err = os.Chmod(localPath, entry.mode)
+			}
+			break
+		}
+		action = ActionCreate
+		if regular {
+			action = ActionUpdate
+		}
+		if !dryRun {
+			if existing != nil && !regular {
+				err = os.RemoveAll(localPath)
+			}
+			if err == nil {
+				return receiver.receiveFile(index, entry, localPath, action, regular)
+			}
+		}
+	}
+	if err != nil {
+		return receiver.report(Change{Action: ActionFailed, Path: entry.path, Err: err})
+	} else if action != "" {
+		return receiver.report(Change{Action: action, Path: entry.path})
+	}
+	return nil
+}
+
+// replace removes what exists at the path and creates what replaces it.
+func replace(localPath string, existing os.FileInfo, create func() error) error {
+	if existing != nil {
+		if err := os.RemoveAll(localPath); err != nil {
+			return err
+		}
+	}
+	return create()
+}
+
+// receiveFile asks the sender for the delta of the file against what the receiver has of it already and rebuilds the
+// file from it next to the existing one, which it replaces once the checksum is right.
+func (receiver *receiver) receiveFile(index uint32, entry *entry, localPath string, action string,
+	hasBasis bool) error {
+	log.WithField("path", entry.path).Traceln("--> deltasync.receiver.receiveFile")
+	sig := &signature{blockSize: blockSizeFor(0)}
+	var basis *os.File
+	if hasBasis {
+		var err error
+		if basis, err = os.Open(localPath); err == nil {
+			defer basis.Close()
+			if info, err := basis.Stat(); err == nil {
+				if basisSig, err := newSignature(basis, info.Size()); err == nil {
+					sig = basisSig
+				}
+			}
+		}
+	}
+	if err := receiver.peer.write(msgSignature, encodeSignature(new(codec.Encoder), index, sig)); err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".")
+	builder := &builder{out: out, hash: sha256.New(), err: err}
+	if err := builder.build(receiver.peer, basis, sig); err != nil {
+		if out != nil {
+			_ = out.Close()
+			_ = os.Remove(out.Name())
+		}
+		return err
+	}
+	err = builder.err
+	if out != nil {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = preserve(out.Name(), entry.mode, entry.mtime)
+		}
+		if err == nil {
+			err = os.Rename(out.Name(), localPath)
+		}
+		if err != nil {
+			_ = os.Remove(out.Name())
+		}
+	}
+	if err != nil {
+		return receiver.report(Change{Action: ActionFailed, Path: entry.path, Err: err})
+	}
+	receiver.stats.Files++
+	receiver.stats.Literal += builder.literal
+	receiver.stats.Matched += builder.matched
+	return receiver.report(Change{Action: action, Path: entry.path})
+}
+
+// A builder rebuilds a file from the blocks of the basis and the literal data of the delta.
+type builder struct {
+	out     *os.File
+	hash    hash.Hash
+	err     error // The first failure to build the file, after which the delta only gets read.
+	literal int64
+	matched int64
+}
+
+// build reads the delta up to its end. The error is about the connection, failures to build the file end up in
+// builder.err.
+func (builder *builder) build(peer *peer, basis *os.File, sig *signature) error {
+	buffer := make([]byte, sig.blockSize)
+	for {
+		msgType, msg, err := peer.read()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case msgCopy:
+			start, count := msg.Uint32(), msg.Uint32()
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if basis == nil || uint64(start)+uint64(count) > uint64(len(sig.blocks)) {
+				return fmt.Errorf("invalid blocks %d+%d", start, count)
+			}
+			for index := int(start); index < int(start+count); index++ {
+				data := buffer[:sig.blockLength(index)]
+				if builder.err == nil {
+					// The basis may have changed since its signature was computed, which the checksum reveals.
+					_, builder.err = basis.ReadAt(data, int64(index)*int64(sig.blockSize))
+				}
+				builder.write(data)
+				builder.matched += int64(len(data))
+			}
+		case msgLiteral:
+			data := msg.Bytes()
+			if msg.Err != nil {
+				return msg.Err
+			}
+			builder.write(data)
+			builder.literal += int64(len(data))
+		case msgFileEnd:
+			sum := msg.Bytes()
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if builder.err == nil && !bytes.Equal(sum, builder.hash.Sum(nil)) {
+				builder.err = errors.New("checksum mismatch")
+			}
+			return nil
+		case msgFileError:
+			errMsg := msg.Text()
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if builder.err == nil {
+				builder.err = errors.New(errMsg)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected message %d", msgType)
+		}
+	}
+}
+
+func (builder *builder) write(data []byte) {
+	if builder.err == nil {
+		_, builder.err = builder.out.Write(data)
+		_, _ = builder.hash.Write(data)
+	}
+}
+
+// deleteExtraneous deletes what is not in the list of the sender, unless the rules exclude it.
+func (receiver *receiver) deleteExtraneous() error {
+	log.WithField("root", receiver.root).Traceln("--> deltasync.receiver.deleteExtraneous")
+	var extraneous []string
+	_ = filepath.WalkDir(receiver.root, func(filePath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil || filePath == receiver.root {
+			// What cannot be read cannot be deleted either.
+			return nil
+		}
+		relPath, err := filepath.Rel(receiver.root, filePath)
+		if err != nil {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		isDir := dirEntry.IsDir()
+		if entry, ok := receiver.paths[relPath]; ok {
+			if isDir && entry.kind != kindDirectory {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !excluded(receiver.options.Rules, relPath, isDir) {
+			extraneous = append(extraneous, relPath)
+		}
+		if isDir {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	for _, relPath := range extraneous {
+		var err error
+		if !receiver.options.DryRun {
+			err = os.RemoveAll(receiver.local(relPath))
+		}
+		change := Change{Action: ActionDelete, Path: relPath}
+		if err != nil {
+			change = Change{Action: ActionFailed, Path: relPath, Err: err}
+		} else {
+			receiver.stats.Deleted++
+		}
+		if err := receiver.report(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preserve gives the file the mode and mtime of the original.
+func preserve(filePath string, mode os.FileMode, mtime time.Time) error {
+	if err := os.Chmod(filePath, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(filePath, mtime, mtime)
+}
@@ -0,0 +1,135 @@
+package deltasync
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// A sender sends the tree below root to the receiver on the other side.
+type sender struct {
+	peer    *peer
+	options Options
+	root    string
+	entries []*entry
+}
+
+// list walks the tree and sends an entry for everything the rules include. Failures to read parts of it get reported to
+// the receiver, which reports them along with its own.
+func (sender *sender) list() error {
+	log.WithField("root", sender.root).Traceln("--> deltasync.sender.list")
+	err := filepath.WalkDir(sender.root, func(filePath string, dirEntry fs.DirEntry, err error) error {
+		if filePath == sender.root {
+			return err
+		}
+		relPath, relErr := filepath.Rel(sender.root, filePath)
+		if relErr != nil {
+			return relErr
+		}
+		relPath = filepath.ToSlash(relPath)
+		if err != nil {
+			return sender.fail(relPath, err)
+		}
+		if excluded(sender.options.Rules, relPath, dirEntry.IsDir()) {
+			if dirEntry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return sender.fail(relPath, err)
+		}
+		entry := &entry{path: relPath, mode: info.Mode().Perm(), size: info.Size(), mtime: info.ModTime()}
+		switch {
+		case info.IsDir():
+			entry.kind, entry.size = kindDirectory, 0
+		case info.Mode().IsRegular():
+			entry.kind = kindFile
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.kind, entry.size = kindSymlink, 0
+			if entry.link, err = os.Readlink(filePath); err != nil {
+				return sender.fail(relPath, err)
+			}
+		default:
+			// Devices, sockets and pipes do not get synchronized.
+			return nil
+		}
+		sender.entries = append(sender.entries, entry)
+		return sender.peer.write(msgEntry, encodeEntry(new(codec.Encoder), entry))
+	})
+	if err != nil {
+		return err
+	}
+	return sender.peer.write(msgEndList, new(codec.Encoder))
+}
+
+// fail tells the receiver about a part of the tree that cannot be sent.
+func (sender *sender) fail(relPath string, err error) error {
+	log.WithError(err).WithField("path", relPath).Warnln("Failed to list entry.")
+	return sender.peer.writeChange(Change{Action: ActionFailed, Path: relPath, Err: err})
+}
+
+// serve answers the requests of the receiver for files until it is done.
+func (sender *sender) serve() (Stats, error) {
+	log.WithField("root", sender.root).Traceln("--> deltasync.sender.serve")
+	for {
+		msgType, msg, err := sender.peer.read()
+		if err != nil {
+			return Stats{}, err
+		}
+		switch msgType {
+		case msgSignature:
+			index, sig := decodeSignature(msg)
+			if msg.Err != nil {
+				return Stats{}, msg.Err
+			}
+			if int(index) >= len(sender.entries) || sender.entries[index].kind != kindFile {
+				return Stats{}, fmt.Errorf("invalid entry %d", index)
+			}
+			if err := sender.sendFile(sender.entries[index], sig); err != nil {
+				return Stats{}, err
+			}
+		case msgChange:
+			change, err := readChange(msg)
+			if err != nil {
+				return Stats{}, err
+			}
+			sender.options.changed(change)
+		case msgDone:
+			return readDone(msg)
+		default:
+			return Stats{}, fmt.Errorf("unexpected message %d", msgType)
+		}
+	}
+}
+
+// sendFile sends the delta of the file against the signature of what the receiver has. The error is about the
+// connection, failures to read the file go to the receiver.
+func (sender *sender) sendFile(entry *entry, sig *signature) error {
+	log.WithField("path", entry.path).Traceln("--> deltasync.sender.sendFile")
+	file, err := os.Open(filepath.Join(sender.root, filepath.FromSlash(entry.path)))
+	if err != nil {
+		return sender.peer.write(msgFileError, new(codec.Encoder).Text(err.Error()))
+	}
+	defer file.Close()
+	delta := &delta{peer: sender.peer}
+	sum, err := delta.generate(file, sig)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		// Reading the file failed rather than the connection.
+		return sender.peer.write(msgFileError, new(codec.Encoder).Text(err.Error()))
+	} else if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"path":    entry.path,
+		"literal": delta.stats.Literal,
+		"matched": delta.stats.Matched,
+	}).Debugln("Sent delta.")
+	return sender.peer.write(msgFileEnd, new(codec.Encoder).Bytes(sum))
+}
@@ -0,0 +1,139 @@
+package deltasync
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"os"
+)
+
+// Serve serves the commands of a client on conn until it goes away. Paths are relative to the working directory.
+func Serve(conn io.ReadWriter) error {
+	log.Traceln("--> deltasync.Serve")
+	peer := newPeer(conn)
+	for {
+		msg, err := peer.expect(msgCommand)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			log.WithError(err).Errorln("Failed to read command.")
+			return err
+		}
+		direction, root, options, err := readCommand(msg)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to read command.")
+			return err
+		}
+		log.WithFields(log.Fields{
+			"direction": string(direction),
+			"root":      root,
+			"delete":    options.Delete,
+			"dryRun":    options.DryRun,
+		}).Infoln("Serving command.")
+		switch direction {
+		case directionPush:
+			err = serveReceive(peer, root, options)
+		case directionGet:
+			err = serveSend(peer, root, options)
+		default:
+			err = fmt.Errorf("unknown direction %q", direction)
+		}
+		if err != nil {
+			log.WithError(err).Errorln("Failed to serve command.")
+			return err
+		}
+	}
+}
+
+func encodeCommand(encoder *codec.Encoder, direction byte, root string, options Options) *codec.Encoder {
+	encoder.Byte(direction).Byte(options.flags()).Text(root).Uint32(uint32(len(options.Rules)))
+	for _, rule := range options.Rules {
+		include := byte(0)
+		if rule.Include {
+			include = 1
+		}
+		encoder.Byte(include).Text(rule.Pattern)
+	}
+	return encoder
+}
+
+func readCommand(msg *codec.Decoder) (byte, string, Options, error) {
+	direction, flags, root := msg.Byte(), msg.Byte(), msg.Text()
+	options := Options{Delete: flags&flagDelete != 0, DryRun: flags&flagDryRun != 0}
+	count := msg.Uint32()
+	for i := uint32(0); i < count && msg.Err == nil; i++ {
+		options.Rules = append(options.Rules, Rule{Include: msg.Byte() == 1, Pattern: msg.Text()})
+	}
+	return direction, root, options, msg.Err
+}
+
+// serveReceive synchronizes the tree at root with the one the client pushes.
+func serveReceive(peer *peer, root string, options Options) error {
+	err := checkRules(options.Rules)
+	if err == nil {
+		err = prepareRoot(root, options.DryRun)
+	}
+	if err != nil {
+		return peer.writeStatus(err)
+	}
+	if err := peer.writeStatus(nil); err != nil {
+		return err
+	}
+	receiver := &receiver{peer: peer, options: options, root: root}
+	stats, err := receiver.receive()
+	if err != nil {
+		return err
+	}
+	log.WithField("stats", stats).Infoln("Received tree.")
+	return nil
+}
+
+// serveSend sends the tree at root to the client.
+func serveSend(peer *peer, root string, options Options) error {
+	err := checkRules(options.Rules)
+	if err == nil {
+		err = checkRoot(root)
+	}
+	if err != nil {
+		return peer.writeStatus(err)
+	}
+	if err := peer.writeStatus(nil); err != nil {
+		return err
+	}
+	sender := &sender{peer: peer, options: options, root: root}
+	if err := sender.list(); err != nil {
+		return err
+	}
+	stats, err := sender.serve()
+	if err != nil {
+		return err
+	}
+	log.WithField("stats", stats).Infoln("Sent tree.")
+	return nil
+}
+
+// checkRoot checks that the root of the tree to send is a directory.
+func checkRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+	return nil
+}
+
+// prepareRoot creates the root of the tree to receive, unless it exists already or the sync is a dry run.
+func prepareRoot(root string, dryRun bool) error {
+	if !dryRun {
+		if err := os.Mkdir(root, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	} else if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+	return checkRoot(root)
+}
@@ -0,0 +1,101 @@
+// Package codec builds and reads the payloads of the subsystem protocols, which are made of big-endian integers and
+// of byte strings prefixed with their length.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrTruncated is the error of a decoder that ran out of data.
+var ErrTruncated = errors.New("truncated payload")
+
+// An Encoder builds a payload.
+type Encoder struct {
+	Data []byte
+}
+
+func (encoder *Encoder) Byte(value byte) *Encoder {
+	encoder.Data = append(encoder.Data, value)
+	return encoder
+}
+
+func (encoder *Encoder) Uint32(value uint32) *Encoder {
+	encoder.Data = binary.BigEndian.AppendUint32(encoder.Data, value)
+	return encoder
+}
+
+func (encoder *Encoder) Uint64(value uint64) *Encoder {
+	encoder.Data = binary.BigEndian.AppendUint64(encoder.Data, value)
+	return encoder
+}
+
+// Raw appends the value without its length.
+func (encoder *Encoder) Raw(value []byte) *Encoder {
+	encoder.Data = append(encoder.Data, value...)
+	return encoder
+}
+
+func (encoder *Encoder) Bytes(value []byte) *Encoder {
+	return encoder.Uint32(uint32(len(value))).Raw(value)
+}
+
+// Text appends the string like Bytes.
+func (encoder *Encoder) Text(value string) *Encoder {
+	return encoder.Bytes([]byte(value))
+}
+
+// A Decoder reads a payload. Once it runs out of data, it only returns zero values and keeps the error in Err.
+type Decoder struct {
+	data []byte
+	Err  error
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+// Next returns the next n bytes of the payload.
+func (decoder *Decoder) Next(n int) []byte {
+	if decoder.Err != nil || n < 0 || len(decoder.data) < n {
+		decoder.Err = ErrTruncated
+		return nil
+	}
+	value := decoder.data[:n]
+	decoder.data = decoder.data[n:]
+	return value
+}
+
+func (decoder *Decoder) Byte() byte {
+	if value := decoder.Next(1); value != nil {
+		return value[0]
+	}
+	return 0
+}
+
+func (decoder *Decoder) Uint32() uint32 {
+	if value := decoder.Next(4); value != nil {
+		return binary.BigEndian.Uint32(value)
+	}
+	return 0
+}
+
+func (decoder *Decoder) Uint64() uint64 {
+	if value := decoder.Next(8); value != nil {
+		return binary.BigEndian.Uint64(value)
+	}
+	return 0
+}
+
+func (decoder *Decoder) Bytes() []byte {
+	n := decoder.Uint32()
+	if decoder.Err == nil && n > uint32(len(decoder.data)) {
+		decoder.Err = ErrTruncated
+	}
+	return decoder.Next(int(n))
+}
+
+// Text reads a string appended by Encoder.Text.
+func (decoder *Decoder) Text() string {
+	return string(decoder.Bytes())
+}
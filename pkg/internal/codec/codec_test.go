@@ -0,0 +1,31 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	payload := new(Encoder).Byte(3).Uint32(7).Uint64(1 << 40).Text("name").Bytes([]byte{1, 2}).Raw([]byte{9})
+	decoder := NewDecoder(payload.Data)
+	if decoder.Byte() != 3 || decoder.Uint32() != 7 || decoder.Uint64() != 1<<40 || decoder.Text() != "name" ||
+		!bytes.Equal(decoder.Bytes(), []byte{1, 2}) || !bytes.Equal(decoder.Next(1), []byte{9}) || decoder.Err != nil {
+		t.Error("Decoded the payload wrongly.")
+	}
+	if decoder.Uint32(); decoder.Err == nil {
+		t.Error("Decoded past the end of the payload.")
+	}
+}
+
+func TestDecoder_Truncated(t *testing.T) {
+	// A string longer than the payload.
+	decoder := NewDecoder(new(Encoder).Uint32(100).Data)
+	if decoder.Text(); decoder.Err != ErrTruncated {
+		t.Errorf("Decoded a truncated string: %v", decoder.Err)
+	}
+	// Once truncated, everything decodes to zero values.
+	decoder = NewDecoder(new(Encoder).Byte(1).Data)
+	if decoder.Uint32() != 0 || decoder.Byte() != 0 || decoder.Err == nil {
+		t.Error("Decoded after running out of data.")
+	}
+}
@@ -0,0 +1,22 @@
+// Package pipetest connects the clients of the subsystems to their servers in tests.
+package pipetest
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// Serve runs serve on one end of a pipe until the test ends and returns the other end for the client.
+func Serve(t *testing.T, serve func(conn io.ReadWriter) error) net.Conn {
+	conn, serverConn := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- serve(serverConn)
+	}()
+	t.Cleanup(func() {
+		_ = conn.Close()
+		<-done
+	})
+	return conn
+}
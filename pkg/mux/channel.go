@@ -0,0 +1,307 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Channel is a bidirectional stream within a session. It implements net.Conn.
+type Channel struct {
+	session       *Session
+	id            uint32
+	Kind          string
+	Data          []byte
+	mutex         sync.Mutex
+	changed       chan struct{} // Gets closed and replaced whenever the state changes.
+	opened        chan error
+	confirmed     bool
+	buffer        bytes.Buffer // Received data that was not read yet.
+	consumed      uint32       // Read data the peer was not granted window for yet.
+	sendWindow    uint32
+	eof           bool // The peer sends no more data.
+	sentEOF       bool
+	closed        bool // Closed locally.
+	remoteClosed  bool
+	err           error // Why the session ended, if it did.
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newChannel(session *Session, id uint32, kind string, data []byte) *Channel {
+	return &Channel{
+		session:    session,
+		id:         id,
+		Kind:       kind,
+		Data:       data,
+		changed:    make(chan struct{}),
+		opened:     make(chan error, 1),
+		sendWindow: Window,
+	}
+}
+
+// Id returns the id of the channel within its session.
+func (channel *Channel) Id() uint32 {
+	return channel.id
+}
+
+// notifyLocked wakes up everyone waiting for the state to change.
+func (channel *Channel) notifyLocked() {
+	close(channel.changed)
+	channel.changed = make(chan struct{})
+}
+
+// wait waits for the state to change until the deadline. The mutex has to be held and is held again afterwards.
+func (channel *Channel) wait(deadline time.Time) error {
+	changed := channel.changed
+	channel.mutex.Unlock()
+	defer channel.mutex.Lock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-changed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (channel *Channel) Read(p []byte) (int, error) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	for channel.buffer.Len() == 0 {
+		if channel.err != nil && channel.err != io.EOF {
+			return 0, channel.err
+		}
+		if channel.eof || channel.closed {
+			return 0, io.EOF
+		}
+		if !channel.readDeadline.IsZero() && !time.Now().Before(channel.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if err := channel.wait(channel.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+	n, _ := channel.buffer.Read(p)
+	channel.consumed += uint32(n)
+	// Granting window in small steps would flood the peer with frames.
+	if increment := channel.consumed; increment >= Window/2 && !channel.remoteClosed {
+		channel.consumed = 0
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, increment)
+		go func() { _ = channel.session.writeFrame(frameWindow, channel.id, payload) }()
+	}
+	return n, nil
+}
+
+func (channel *Channel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		channel.mutex.Lock()
+		for channel.sendWindow == 0 && !channel.closed && !channel.remoteClosed && !channel.sentEOF {
+			if !channel.writeDeadline.IsZero() && !time.Now().Before(channel.writeDeadline) {
+				channel.mutex.Unlock()
+				return written, os.ErrDeadlineExceeded
+			}
+			if err := channel.wait(channel.writeDeadline); err != nil {
+				channel.mutex.Unlock()
+				return written, err
+			}
+		}
+		if channel.closed || channel.remoteClosed || channel.sentEOF {
+			channel.mutex.Unlock()
+			if err := channel.session.Err(); err != nil {
+				return written, err
+			}
+			return written, io.ErrClosedPipe
+		}
+		n := len(p)
+		if n > int(channel.sendWindow) {
+			n = int(channel.sendWindow)
+		}
+		if n > MaxPayload {
+			n = MaxPayload
+		}
+		channel.sendWindow -= uint32(n)
+		channel.mutex.Unlock()
+		if err := channel.session.writeFrame(frameData, channel.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite tells the peer that no more data follows.
+func (channel *Channel) CloseWrite() error {
+	channel.mutex.Lock()
+	if channel.sentEOF || channel.closed || channel.remoteClosed {
+		channel.mutex.Unlock()
+		return nil
+	}
+	channel.sentEOF = true
+	channel.notifyLocked()
+	channel.mutex.Unlock()
+	return channel.session.writeFrame(frameEOF, channel.id, nil)
+}
+
+// Close closes the channel. Closing the stream channel closes the whole session.
+func (channel *Channel) Close() error {
+	if channel.id == StreamId {
+		return channel.session.Close()
+	}
+	channel.mutex.Lock()
+	if channel.closed {
+		channel.mutex.Unlock()
+		return nil
+	}
+	channel.closed = true
+	remoteClosed := channel.remoteClosed
+	channel.notifyLocked()
+	channel.mutex.Unlock()
+	if remoteClosed {
+		return nil
+	}
+	return channel.session.writeFrame(frameClose, channel.id, nil)
+}
+
+func (channel *Channel) LocalAddr() net.Addr {
+	if conn, ok := channel.session.conn.(net.Conn); ok {
+		return conn.LocalAddr()
+	}
+	return nil
+}
+
+func (channel *Channel) RemoteAddr() net.Addr {
+	if conn, ok := channel.session.conn.(net.Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+func (channel *Channel) SetDeadline(t time.Time) error {
+	_ = channel.SetReadDeadline(t)
+	return channel.SetWriteDeadline(t)
+}
+
+func (channel *Channel) SetReadDeadline(t time.Time) error {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.readDeadline = t
+	channel.notifyLocked()
+	return nil
+}
+
+func (channel *Channel) SetWriteDeadline(t time.Time) error {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.writeDeadline = t
+	channel.notifyLocked()
+	return nil
+}
+
+func (channel *Channel) handleOpened(err error) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	if channel.confirmed {
+		return
+	}
+	channel.confirmed = true
+	channel.opened <- err
+}
+
+func (channel *Channel) handleData(data []byte) error {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	if channel.closed {
+		return nil
+	}
+	// Data of channels that wait to be accepted would pile up for as long as they wait.
+	if !channel.confirmed {
+		return errors.New("peer sent data before the channel was confirmed")
+	}
+	if channel.eof || channel.buffer.Len()+int(channel.consumed)+len(data) > Window {
+		return errors.New("peer sent data beyond the window")
+	}
+	channel.buffer.Write(data)
+	channel.notifyLocked()
+	return nil
+}
+
+func (channel *Channel) handleWindow(increment uint32) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.sendWindow += increment
+	channel.notifyLocked()
+}
+
+func (channel *Channel) handleEOF() {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.eof = true
+	channel.notifyLocked()
+}
+
+// handleClose answers the close of the peer with a close of its own, unless the channel is closed locally already.
+// Either way, the channel is gone afterwards.
+func (channel *Channel) handleClose() {
+	channel.mutex.Lock()
+	closed := channel.closed
+	channel.remoteClosed = true
+	channel.eof = true
+	channel.notifyLocked()
+	channel.mutex.Unlock()
+	channel.session.remove(channel.id)
+	if !closed {
+		_ = channel.session.writeFrame(frameClose, channel.id, nil)
+	}
+}
+
+// terminate ends the channel along with its session. Reads return the error once the received data is read.
+func (channel *Channel) terminate(err error) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.err = err
+	channel.remoteClosed = true
+	channel.eof = true
+	if !channel.confirmed {
+		channel.confirmed = true
+		channel.opened <- ErrClosed
+	}
+	channel.notifyLocked()
+}
+
+// Relay copies between the channel and the connection in both directions until both are done, passing on the end of
+// either side's data, and closes both.
+func Relay(channel *Channel, conn net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+			_ = halfCloser.CloseWrite()
+		} else {
+			_ = conn.Close()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(channel, conn)
+		_ = channel.CloseWrite()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	_ = conn.Close()
+	_ = channel.Close()
+}
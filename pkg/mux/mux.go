@@ -0,0 +1,313 @@
+// Package mux multiplexes channels over the connection between gosh and goshh once the transfer is done. Channel 0
+// is the stream of the terminal session, further channels get opened for forwarded connections. Every channel has a
+// window of bytes the peer may send before it has to wait for the window to be adjusted, so that a stalled channel
+// cannot block the others.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Frame types.
+const (
+	frameOpen    = 1 // Payload is the length of the kind as one byte, the kind and the data of the request.
+	frameConfirm = 2
+	frameReject  = 3 // Payload is the reason.
+	frameData    = 4
+	frameWindow  = 5 // Payload is the increment as uint32.
+	frameEOF     = 6
+	frameClose   = 7
+)
+
+const (
+	// A frame header holds the type, the channel id and the length of the payload.
+	headerSize = 1 + 4 + 4
+	// The largest payload of a frame.
+	MaxPayload = 32 * 1024
+	// The window every channel starts with.
+	Window = 256 * 1024
+	// How long writing a frame may take before the connection counts as dead.
+	frameWriteTimeout = 30 * time.Second
+	// How many channel requests may wait to be accepted.
+	maxPendingRequests = 16
+)
+
+// The id of the stream channel, which exists without being opened.
+const StreamId = 0
+
+var ErrClosed = errors.New("mux session closed")
+
+// A Session multiplexes channels over a connection.
+type Session struct {
+	conn       io.ReadWriteCloser
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	channels   map[uint32]*Channel
+	nextId     uint32
+	requests   chan *Request
+	done       chan struct{}
+	err        error
+}
+
+// Client starts a session on the client side of the connection, which opens channels with odd ids.
+func Client(conn io.ReadWriteCloser) *Session {
+	log.Traceln("--> mux.Client")
+	return newSession(conn, 1)
+}
+
+// Server starts a session on the server side of the connection, which opens channels with even ids.
+func Server(conn io.ReadWriteCloser) *Session {
+	log.Traceln("--> mux.Server")
+	return newSession(conn, 2)
+}
+
+func newSession(conn io.ReadWriteCloser, firstId uint32) *Session {
+	session := &Session{
+		conn:     conn,
+		channels: map[uint32]*Channel{},
+		nextId:   firstId,
+		requests: make(chan *Request, maxPendingRequests),
+		done:     make(chan struct{}),
+	}
+	stream := newChannel(session, StreamId, "session", nil)
+	stream.confirmed = true
+	session.channels[StreamId] = stream
+	go session.readLoop()
+	return session
+}
+
+// Stream returns the channel of the terminal session. Closing it closes the whole session.
+func (session *Session) Stream() *Channel {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.channels[StreamId]
+}
+
+// Open opens a channel of the kind. The data tells the peer what the channel is for.
+func (session *Session) Open(kind string, data []byte) (*Channel, error) {
+	log.WithField("kind", kind).Traceln("--> mux.Session.Open")
+	if len(kind) > 255 || len(kind)+1+len(data) > MaxPayload {
+		return nil, errors.New("channel request too long")
+	}
+	session.mutex.Lock()
+	if session.err != nil {
+		session.mutex.Unlock()
+		return nil, session.err
+	}
+	id := session.nextId
+	session.nextId += 2
+	channel := newChannel(session, id, kind, data)
+	session.channels[id] = channel
+	session.mutex.Unlock()
+
+	payload := append([]byte{byte(len(kind))}, kind...)
+	if err := session.writeFrame(frameOpen, id, append(payload, data...)); err != nil {
+		return nil, err
+	}
+	select {
+	case err := <-channel.opened:
+		if err != nil {
+			return nil, err
+		}
+		return channel, nil
+	case <-session.done:
+		return nil, session.Err()
+	}
+}
+
+// Accept waits for the peer to request a channel.
+func (session *Session) Accept() (*Request, error) {
+	select {
+	case request := <-session.requests:
+		return request, nil
+	case <-session.done:
+		return nil, session.Err()
+	}
+}
+
+// Done is closed once the session is closed.
+func (session *Session) Done() <-chan struct{} {
+	return session.done
+}
+
+// Err returns why the session got closed.
+func (session *Session) Err() error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.err
+}
+
+// Close closes the session along with its connection and all its channels.
+func (session *Session) Close() error {
+	log.Traceln("--> mux.Session.Close")
+	return session.fail(ErrClosed)
+}
+
+func (session *Session) fail(err error) error {
+	session.mutex.Lock()
+	if session.err != nil {
+		session.mutex.Unlock()
+		return nil
+	}
+	session.err = err
+	channels := session.channels
+	session.channels = map[uint32]*Channel{}
+	close(session.done)
+	session.mutex.Unlock()
+	for _, channel := range channels {
+		channel.terminate(err)
+	}
+	return session.conn.Close()
+}
+
+func (session *Session) channel(id uint32) *Channel {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.channels[id]
+}
+
+func (session *Session) remove(id uint32) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	delete(session.channels, id)
+}
+
+func (session *Session) writeFrame(frameType byte, id uint32, payload []byte) error {
+	frame := make([]byte, headerSize+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:], id)
+	binary.BigEndian.PutUint32(frame[5:], uint32(len(payload)))
+	copy(frame[headerSize:], payload)
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	if session.Err() != nil {
+		return session.Err()
+	}
+	if conn, ok := session.conn.(net.Conn); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	}
+	if _, err := session.conn.Write(frame); err != nil {
+		log.WithError(err).Errorln("Failed to write frame.")
+		_ = session.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (session *Session) readLoop() {
+	log.Traceln("--> mux.Session.readLoop")
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(session.conn, header); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			_ = session.fail(err)
+			return
+		}
+		frameType, id, length := header[0], binary.BigEndian.Uint32(header[1:]), binary.BigEndian.Uint32(header[5:])
+		if length > MaxPayload {
+			_ = session.fail(fmt.Errorf("frame of %d bytes is too long", length))
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(session.conn, payload); err != nil {
+			_ = session.fail(err)
+			return
+		}
+		if err := session.handleFrame(frameType, id, payload); err != nil {
+			log.WithError(err).Errorln("Peer violated the mux protocol.")
+			_ = session.fail(err)
+			return
+		}
+	}
+}
+
+func (session *Session) handleFrame(frameType byte, id uint32, payload []byte) error {
+	if frameType == frameOpen {
+		return session.handleOpen(id, payload)
+	}
+	channel := session.channel(id)
+	if channel == nil {
+		// The channel got closed locally in the meantime.
+		return nil
+	}
+	switch frameType {
+	case frameConfirm:
+		channel.handleOpened(nil)
+	case frameReject:
+		session.remove(id)
+		channel.handleOpened(errors.New(string(payload)))
+	case frameData:
+		return channel.handleData(payload)
+	case frameWindow:
+		if len(payload) != 4 {
+			return errors.New("malformed window frame")
+		}
+		channel.handleWindow(binary.BigEndian.Uint32(payload))
+	case frameEOF:
+		channel.handleEOF()
+	case frameClose:
+		channel.handleClose()
+	default:
+		return fmt.Errorf("unknown frame type %d", frameType)
+	}
+	return nil
+}
+
+func (session *Session) handleOpen(id uint32, payload []byte) error {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return errors.New("malformed open frame")
+	}
+	// The peer opens channels with the other parity.
+	if id == StreamId || id%2 == session.nextId%2 || session.channel(id) != nil {
+		return fmt.Errorf("peer opened invalid channel %d", id)
+	}
+	kind := string(payload[1 : 1+payload[0]])
+	channel := newChannel(session, id, kind, payload[1+payload[0]:])
+	session.mutex.Lock()
+	session.channels[id] = channel
+	session.mutex.Unlock()
+	select {
+	case session.requests <- &Request{Kind: kind, Data: channel.Data, channel: channel}:
+	default:
+		log.WithField("kind", kind).Warnln("Too many pending channel requests.")
+		session.remove(id)
+		return session.writeFrame(frameReject, id, []byte("too many pending channel requests"))
+	}
+	return nil
+}
+
+// A Request is a channel the peer asks to open.
+type Request struct {
+	Kind    string
+	Data    []byte
+	channel *Channel
+}
+
+// Accept confirms the channel to the peer.
+func (request *Request) Accept() (*Channel, error) {
+	log.WithField("kind", request.Kind).Traceln("--> mux.Request.Accept")
+	request.channel.handleOpened(nil)
+	if err := request.channel.session.writeFrame(frameConfirm, request.channel.id, nil); err != nil {
+		return nil, err
+	}
+	return request.channel, nil
+}
+
+// Reject refuses the channel for the reason.
+func (request *Request) Reject(reason string) error {
+	log.WithFields(log.Fields{
+		"kind":   request.Kind,
+		"reason": reason,
+	}).Traceln("--> mux.Request.Reject")
+	request.channel.session.remove(request.channel.id)
+	return request.channel.session.writeFrame(frameReject, request.channel.id, []byte(reason))
+}
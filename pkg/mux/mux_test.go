@@ -0,0 +1,168 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func newPair(t *testing.T) (*Session, *Session) {
+	clientConn, serverConn := net.Pipe()
+	client, server := Client(clientConn), Server(serverConn)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func TestSession_Stream(t *testing.T) {
+	client, server := newPair(t)
+	go func() {
+		_, _ = client.Stream().Write([]byte("hello"))
+	}()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server.Stream(), buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Got %q: %v", buf, err)
+	}
+	_ = client.Stream().Close()
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Error("Closing the stream did not close the session.")
+	}
+}
+
+func TestSession_Open(t *testing.T) {
+	client, server := newPair(t)
+	go func() {
+		request, err := server.Accept()
+		if err != nil {
+			return
+		}
+		if string(request.Data) == "reject" {
+			_ = request.Reject("no")
+			return
+		}
+		channel, err := request.Accept()
+		if err != nil {
+			return
+		}
+		// Echoes everything back.
+		_, _ = io.Copy(channel, channel)
+		_ = channel.CloseWrite()
+		request, err = server.Accept()
+		if err == nil {
+			_ = request.Reject("no")
+		}
+	}()
+	channel, err := client.Open("echo", []byte("accept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than the window, so that the window has to be adjusted.
+	data := bytes.Repeat([]byte("0123456789"), Window/5)
+	go func() {
+		_, _ = channel.Write(data)
+		_ = channel.CloseWrite()
+	}()
+	echo, err := ioutil.ReadAll(channel)
+	if err != nil || !bytes.Equal(echo, data) {
+		t.Errorf("Got %d bytes back instead of %d: %v", len(echo), len(data), err)
+	}
+	_ = channel.Close()
+
+	if _, err := client.Open("echo", []byte("reject")); err == nil || err.Error() != "no" {
+		t.Errorf("Open was not rejected: %v", err)
+	}
+}
+
+func TestSession_DataBeforeConfirm(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	server := Server(serverConn)
+	defer server.Close()
+	frame := func(frameType byte, payload []byte) []byte {
+		header := []byte{frameType, 0, 0, 0, 1, 0, 0, 0, byte(len(payload))}
+		return append(header, payload...)
+	}
+	go func() {
+		_, _ = clientConn.Write(frame(frameOpen, []byte("\x04idle")))
+		_, _ = clientConn.Write(frame(frameData, []byte("unasked")))
+	}()
+	select {
+	case <-server.Done():
+	case <-time.After(time.Second):
+		t.Error("Accepted data on a channel that waits to be accepted.")
+	}
+}
+
+func TestChannel_Deadline(t *testing.T) {
+	client, server := newPair(t)
+	go func() {
+		if request, err := server.Accept(); err == nil {
+			_, _ = request.Accept()
+		}
+	}()
+	channel, err := client.Open("idle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = channel.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := channel.Read(make([]byte, 1)); err == nil {
+		t.Error("Read did not time out.")
+	}
+	_ = client.Close()
+	if _, err := channel.Write([]byte("x")); err == nil {
+		t.Error("Wrote to a closed session.")
+	}
+	if _, err := client.Open("idle", nil); err == nil {
+		t.Error("Opened a channel on a closed session.")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	client, server := newPair(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		data, _ := ioutil.ReadAll(conn)
+		_, _ = conn.Write(append([]byte("got "), data...))
+		_ = conn.Close()
+	}()
+	go func() {
+		request, err := server.Accept()
+		if err != nil {
+			return
+		}
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			_ = request.Reject(err.Error())
+			return
+		}
+		channel, err := request.Accept()
+		if err != nil {
+			return
+		}
+		Relay(channel, conn)
+	}()
+	channel, err := client.Open("direct-tcpip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = channel.Write([]byte("ping"))
+	_ = channel.CloseWrite()
+	reply, err := ioutil.ReadAll(channel)
+	if err != nil || string(reply) != "got ping" {
+		t.Errorf("Got %q: %v", reply, err)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build cgo
+// +build cgo
+
+package passwd
+
+// https://linux.die.net/man/3/getgrouplist
+
+/*
+#include <stdlib.h>
+#include <sys/types.h>
+#include <grp.h>
+*/
+import "C"
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"unsafe"
+)
+
+// The GetGroupList() function returns the groups of the group database the user is a member of, including the group
+// gid, which usually is the user's primary group.
+func GetGroupList(username string, gid uint32) ([]Group, error) {
+	log.WithFields(log.Fields{
+		"username": username,
+		"gid":      gid,
+	}).Traceln("--> pw.GetGroupList")
+	cUsername := C.CString(username)
+	defer C.free(unsafe.Pointer(cUsername))
+	nGroups := C.int(32)
+	var gids []C.gid_t
+	for {
+		gids = make([]C.gid_t, nGroups)
+		if C.getgrouplist(cUsername, C.gid_t(gid), &gids[0], &nGroups) >= 0 {
+			break
+		}
+		if int(nGroups) <= len(gids) {
+			err := errors.New("failed to get group list")
+			log.WithError(err).Warnln("Lookup failed.")
+			return nil, err
+		}
+	}
+	groups := make([]Group, 0, int(nGroups))
+	for _, groupGid := range gids[:nGroups] {
+		group := Group{Gid: uint32(groupGid)}
+		if cGroup := C.getgrgid(groupGid); cGroup != nil {
+			group.Name = C.GoString(cGroup.gr_name)
+		}
+		groups = append(groups, group)
+	}
+	log.WithField("groups", groups).Debugln("Looked up groups.")
+	return groups, nil
+}
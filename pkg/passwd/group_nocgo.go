@@ -0,0 +1,59 @@
+//go:build !cgo
+// +build !cgo
+
+package passwd
+
+import (
+	"bufio"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Without cgo, groups are looked up in the local group file only.
+var groupFile = "/etc/group"
+
+// The GetGroupList() function returns the groups of the group file the user is a member of, including the group gid,
+// which usually is the user's primary group.
+func GetGroupList(username string, gid uint32) ([]Group, error) {
+	log.WithFields(log.Fields{
+		"username": username,
+		"gid":      gid,
+	}).Traceln("--> pw.GetGroupList")
+	file, err := os.Open(groupFile)
+	if err != nil {
+		log.WithError(err).Warnln("Lookup failed.")
+		return nil, err
+	}
+	defer file.Close()
+	groups := []Group{{Gid: gid}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:GID:member,member
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) != 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		groupGid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if uint32(groupGid) == gid {
+			groups[0].Name = fields[0]
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == username {
+				groups = append(groups, Group{Name: fields[0], Gid: uint32(groupGid)})
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.WithError(err).Warnln("Lookup failed.")
+		return nil, err
+	}
+	log.WithField("groups", groups).Debugln("Looked up groups.")
+	return groups, nil
+}
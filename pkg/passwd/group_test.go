@@ -0,0 +1,26 @@
+package passwd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetGroupList(t *testing.T) {
+	pwd, err := GetPwByUid(uint32(os.Getuid()))
+	if err != nil {
+		t.Skip("Cannot look up the current user: " + err.Error())
+	}
+	groups, err := GetGroupList(pwd.Name, pwd.Gid)
+	if err != nil {
+		t.Fatal("Couldn't look up groups: " + err.Error())
+	}
+	found := false
+	for _, group := range groups {
+		if group.Gid == pwd.Gid {
+			found = group.Name != ""
+		}
+	}
+	if !found {
+		t.Error("Primary group is missing from the group list.")
+	}
+}
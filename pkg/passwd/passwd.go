@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package passwd
 
 // https://linux.die.net/man/3/getpwnam
@@ -23,16 +26,6 @@ import (
 	_ "github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
 )
 
-type PassWd struct {
-	Name     string
-	Password string
-	Uid      uint32
-	Gid      uint32
-	Gecos    string
-	HomeDir  string
-	Shell    string
-}
-
 // The GetPwByName() function returns a pointer to a structure containing the broken-out fields of the record in the
 // password database (e.g., the local password file /etc/passwd, NIS, and LDAP) that matches the username name.
 func GetPwByName(username string) (*PassWd, error) {
@@ -0,0 +1,94 @@
+//go:build !cgo
+// +build !cgo
+
+package passwd
+
+import (
+	"bufio"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Without cgo, users are looked up in the local password file only.
+var passwdFile = "/etc/passwd"
+
+// The GetPwByName() function returns a pointer to a structure containing the broken-out fields of the record in the
+// password file that matches the username name.
+func GetPwByName(username string) (*PassWd, error) {
+	log.WithField("username", username).Traceln("--> pw.GetPwByName")
+	return lookup(func(passWd *PassWd) bool {
+		return passWd.Name == username
+	})
+}
+
+// The GetPwByUid() function returns a pointer to a structure containing the broken-out fields of the record in the
+// password file that matches the user ID uid.
+func GetPwByUid(uid uint32) (*PassWd, error) {
+	log.WithField("uid", uid).Traceln("--> pw.GetPwByUid")
+	return lookup(func(passWd *PassWd) bool {
+		return passWd.Uid == uid
+	})
+}
+
+func lookup(matches func(passWd *PassWd) bool) (*PassWd, error) {
+	log.WithField("passwdFile", passwdFile).Traceln("--> pw.lookup")
+	file, err := os.Open(passwdFile)
+	if err != nil {
+		log.WithError(err).Warnln("Lookup failed.")
+		return &PassWd{}, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		passWd, ok := parseLine(scanner.Text())
+		if !ok || !matches(passWd) {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"USER":  passWd.Name,
+			"UID":   passWd.Uid,
+			"GID":   passWd.Gid,
+			"HOME":  passWd.HomeDir,
+			"SHELL": passWd.Shell,
+		}).Println("Looked up user.")
+		return passWd, nil
+	}
+	if err := scanner.Err(); err != nil {
+		log.WithError(err).Warnln("Lookup failed.")
+		return &PassWd{}, err
+	}
+	err = errors.New("no matching entry in " + passwdFile)
+	log.WithError(err).Warnln("Lookup failed.")
+	return &PassWd{}, err
+}
+
+// parseLine parses a line of the password file, name:password:UID:GID:GECOS:directory:shell.
+func parseLine(line string) (*PassWd, bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, false
+	}
+	fields := strings.Split(line, ":")
+	if len(fields) != 7 || fields[0] == "" {
+		return nil, false
+	}
+	uid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return nil, false
+	}
+	gid, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return nil, false
+	}
+	return &PassWd{
+		Name:     fields[0],
+		Password: fields[1],
+		Uid:      uint32(uid),
+		Gid:      uint32(gid),
+		Gecos:    fields[4],
+		HomeDir:  fields[5],
+		Shell:    fields[6],
+	}, true
+}
@@ -0,0 +1,61 @@
+//go:build !cgo
+// +build !cgo
+
+package passwd
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLookup_PasswdFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "passwd")
+	content := "# comment\nroot:x:0:0:root:/root:/bin/bash\nbroken:x:nan:0::/:/bin/sh\nalice:x:1000:100:Alice:/home/alice:/bin/zsh\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(original string) { passwdFile = original }(passwdFile)
+	passwdFile = file
+
+	pwd, err := GetPwByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pwd.Uid != 1000 || pwd.Gid != 100 || pwd.HomeDir != "/home/alice" || pwd.Shell != "/bin/zsh" {
+		t.Errorf("Unexpected entry %+v.", *pwd)
+	}
+	if pwd, err = GetPwByUid(0); err != nil || pwd.Name != "root" {
+		t.Error("Failed to look up root by uid.")
+	}
+	if _, err = GetPwByName("broken"); err == nil {
+		t.Error("Malformed entry was returned.")
+	}
+	if _, err = GetPwByName(""); err == nil {
+		t.Error("No error after empty look up.")
+	}
+}
+
+func TestGetGroupList_GroupFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "group")
+	content := "users:x:100:\nwheel:x:10:root,alice\naudio:x:29:bob,alice\nvideo:x:44:bob\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(original string) { groupFile = original }(groupFile)
+	groupFile = file
+
+	groups, err := GetGroupList("alice", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Group{{"users", 100}, {"wheel", 10}, {"audio", 29}}
+	if len(groups) != len(expected) {
+		t.Fatalf("Unexpected groups %v.", groups)
+	}
+	for i := range expected {
+		if groups[i] != expected[i] {
+			t.Errorf("Unexpected group %v instead of %v.", groups[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package passwd
+
+type PassWd struct {
+	Name     string
+	Password string
+	Uid      uint32
+	Gid      uint32
+	Gecos    string
+	HomeDir  string
+	Shell    string
+}
+
+type Group struct {
+	Name string
+	Gid  uint32
+}
@@ -2,12 +2,13 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// +build aix darwin dragonfly freebsd linux,!android netbsd openbsd
+//go:build (aix || darwin || dragonfly || freebsd || netbsd || openbsd) && cgo
+// +build aix darwin dragonfly freebsd netbsd openbsd
 // +build cgo
 
 // Package pty is a simple pseudo-terminal package for Unix systems,
-// implemented by calling C functions via cgo.
-// This is only used for testing the os/signal package.
+// implemented by calling C functions via cgo. Linux uses the
+// implementation in pty_linux.go, which needs no cgo.
 
 package pty
 
@@ -0,0 +1,53 @@
+//go:build linux && !android
+// +build linux,!android
+
+package pty
+
+import (
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+)
+
+// Creates a pty pair by opening the multiplexer /dev/ptmx, asking for the number of the new pts and unlocking it.
+func Create() (ptm *os.File, pts *os.File, err error) {
+	log.Traceln("--> pty.Create")
+	ptyFd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to open pseudo-terminal.")
+		return
+	}
+	ptsNumber, err := unix.IoctlGetUint32(ptyFd, unix.TIOCGPTN)
+	if err != nil {
+		_ = unix.Close(ptyFd)
+		log.WithError(err).Errorln("Failed to get pseudo-terminal number.")
+		return
+	}
+	if err = unix.IoctlSetPointerInt(ptyFd, unix.TIOCSPTLCK, 0); err != nil {
+		_ = unix.Close(ptyFd)
+		log.WithError(err).Errorln("Failed to unlock pseudo-terminal.")
+		return
+	}
+	ptsName := "/dev/pts/" + strconv.FormatUint(uint64(ptsNumber), 10)
+	log.WithFields(log.Fields{
+		"ptsName": ptsName,
+		"ptyFd":   ptyFd,
+	}).Debugln("Got pty information.")
+	ptm = os.NewFile(uintptr(ptyFd), "/dev/pts/ptmx")
+	pts, err = os.OpenFile(ptsName, os.O_RDWR, 0755)
+	if err != nil {
+		log.WithError(err).WithField("ptsName", ptsName).Errorln("Failed to open pts.")
+		if closeErr := ptm.Close(); closeErr != nil {
+			log.WithError(closeErr).Errorln("Failed to close ptm.")
+		}
+		return
+	}
+	log.WithFields(log.Fields{
+		"ptm":      ptm,
+		"ptm.Fd()": ptm.Fd(),
+		"pts":      pts,
+		"pts.Fd()": pts.Fd(),
+	}).Infoln("Opened pseudo terminal.")
+	return
+}
@@ -0,0 +1,25 @@
+package pty
+
+import (
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	ptm, pts, err := Create()
+	if err != nil {
+		t.Fatal("Failed to create pty pair: " + err.Error())
+	}
+	defer ptm.Close()
+	defer pts.Close()
+	if _, err := pts.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := ptm.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "ping\r\n" {
+		t.Errorf("Read %q from ptm.", got)
+	}
+}
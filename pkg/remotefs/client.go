@@ -0,0 +1,431 @@
+package remotefs
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// A Client accesses the files on the server over a connection to the ftp subsystem. Relative paths are relative to the
+// home directory of the user. It is safe for concurrent use, but sends one request at a time.
+type Client struct {
+	conn   io.ReadWriter
+	mutex  sync.Mutex
+	nextId uint32
+}
+
+// NewClient returns a client using the connection to the subsystem.
+func NewClient(conn io.ReadWriter) *Client {
+	log.Traceln("--> remotefs.NewClient")
+	return &Client{conn: conn}
+}
+
+// request sends a request and returns the response, which is expected to be of the type. A status in place of it
+// turns into an error for the operation on the path.
+func (client *Client) request(op string, name string, requestType byte, payload *codec.Encoder,
+	responseType byte) (*codec.Decoder, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.nextId++
+	id := client.nextId
+	if err := writePacket(client.conn, requestType, id, payload.Data); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	gotType, gotId, response, err := readPacket(client.conn)
+	if err == nil && gotId != id {
+		err = fmt.Errorf("response to request %d instead of %d", gotId, id)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if gotType == responseStatus {
+		code, msg := response.Uint32(), response.Text()
+		if response.Err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: response.Err}
+		}
+		if code == statusOk && responseType == responseStatus {
+			return response, nil
+		} else if code == statusEOF {
+			return nil, io.EOF
+		}
+		return nil, &fs.PathError{Op: op, Path: name, Err: &statusError{code: code, msg: msg}}
+	}
+	if gotType != responseType {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("unexpected response %d", gotType)}
+	}
+	return response, nil
+}
+
+// done checks that the response could be decoded.
+func done(op string, name string, response *codec.Decoder) error {
+	if response.Err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: response.Err}
+	}
+	return nil
+}
+
+// Open opens the file or directory for reading.
+func (client *Client) Open(name string) (*File, error) {
+	return client.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create creates the file or truncates it if it exists.
+func (client *Client) Create(name string) (*File, error) {
+	return client.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the file with the flags of os.OpenFile, creating it with the permissions perm if asked to. Writes to a
+// file opened with os.O_APPEND go to its end regardless of the offset.
+func (client *Client) OpenFile(name string, flag int, perm fs.FileMode) (*File, error) {
+	log.WithField("name", name).Traceln("--> remotefs.Client.OpenFile")
+	payload := new(codec.Encoder).Uint32(uint32(flag)).Uint32(uint32(perm)).Text(name)
+	response, err := client.request("open", name, requestOpen, payload, responseHandle)
+	if err != nil {
+		return nil, err
+	}
+	handle := response.Uint32()
+	if err := done("open", name, response); err != nil {
+		return nil, err
+	}
+	return &File{client: client, name: name, handle: handle}, nil
+}
+
+// Stat returns the attributes of the file, following symlinks.
+func (client *Client) Stat(name string) (fs.FileInfo, error) {
+	return client.stat("stat", name, requestStat)
+}
+
+// Lstat returns the attributes of the file without following symlinks.
+func (client *Client) Lstat(name string) (fs.FileInfo, error) {
+	return client.stat("lstat", name, requestLstat)
+}
+
+func (client *Client) stat(op string, name string, requestType byte) (fs.FileInfo, error) {
+	response, err := client.request(op, name, requestType, new(codec.Encoder).Text(name), responseAttrs)
+	if err != nil {
+		return nil, err
+	}
+	info := decodeAttrs(response, response.Text())
+	return info, done(op, name, response)
+}
+
+// ReadDir returns the entries of the directory sorted by name.
+func (client *Client) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, err := client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	entries, err := dir.ReadDir(-1)
+	sortEntries(entries)
+	return entries, err
+}
+
+func sortEntries(entries []fs.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+}
+
+// ReadFile returns the content of the file.
+func (client *Client) ReadFile(name string) ([]byte, error) {
+	file, err := client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Rename renames the file or directory, replacing a file at the new path.
+func (client *Client) Rename(oldName string, newName string) error {
+	_, err := client.request("rename", oldName, requestRename, new(codec.Encoder).Text(oldName).Text(newName),
+		responseStatus)
+	return err
+}
+
+// Remove removes the file or empty directory.
+func (client *Client) Remove(name string) error {
+	_, err := client.request("remove", name, requestRemove, new(codec.Encoder).Text(name), responseStatus)
+	return err
+}
+
+// Mkdir creates the directory with the permissions perm.
+func (client *Client) Mkdir(name string, perm fs.FileMode) error {
+	_, err := client.request("mkdir", name, requestMkdir, new(codec.Encoder).Uint32(uint32(perm)).Text(name),
+		responseStatus)
+	return err
+}
+
+// Symlink creates newName as a symlink to oldName.
+func (client *Client) Symlink(oldName string, newName string) error {
+	_, err := client.request("symlink", newName, requestSymlink, new(codec.Encoder).Text(oldName).Text(newName),
+		responseStatus)
+	return err
+}
+
+// Readlink returns the target of the symlink.
+func (client *Client) Readlink(name string) (string, error) {
+	return client.path("readlink", name, requestReadlink)
+}
+
+// RealPath returns the absolute path of the file with all symlinks resolved.
+func (client *Client) RealPath(name string) (string, error) {
+	return client.path("realpath", name, requestRealPath)
+}
+
+func (client *Client) path(op string, name string, requestType byte) (string, error) {
+	response, err := client.request(op, name, requestType, new(codec.Encoder).Text(name), responsePath)
+	if err != nil {
+		return "", err
+	}
+	result := response.Text()
+	return result, done(op, name, response)
+}
+
+// A File is a file or directory opened on the server.
+type File struct {
+	client *Client
+	name   string
+	handle uint32
+	mutex  sync.Mutex
+	offset int64
+	closed bool
+}
+
+// Name returns the name the file was opened with.
+func (file *File) Name() string {
+	return file.name
+}
+
+// Read reads up to len(p) bytes from the current offset.
+func (file *File) Read(p []byte) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	n, err := file.readAt(p, file.offset)
+	file.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at the offset.
+func (file *File) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: file.name, Err: errors.New("negative offset")}
+	}
+	read := 0
+	for read < len(p) {
+		n, err := file.readAt(p[read:], offset+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// readAt reads at most maxData bytes with a single request.
+func (file *File) readAt(p []byte, offset int64) (int, error) {
+	if len(p) > maxData {
+		p = p[:maxData]
+	}
+	payload := new(codec.Encoder).Uint32(file.handle).Uint64(uint64(offset)).Uint32(uint32(len(p)))
+	response, err := file.client.request("read", file.name, requestRead, payload, responseData)
+	if err != nil {
+		return 0, err
+	}
+	data := response.Bytes()
+	if err := done("read", file.name, response); err != nil {
+		return 0, err
+	}
+	if len(data) > len(p) {
+		return 0, &fs.PathError{Op: "read", Path: file.name, Err: errors.New("too much data")}
+	}
+	return copy(p, data), nil
+}
+
+// Write writes p at the current offset.
+func (file *File) Write(p []byte) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	n, err := file.WriteAt(p, file.offset)
+	file.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes p at the offset.
+func (file *File) WriteAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "writeat", Path: file.name, Err: errors.New("negative offset")}
+	}
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > maxData {
+			chunk = chunk[:maxData]
+		}
+		payload := new(codec.Encoder).Uint32(file.handle).Uint64(uint64(offset + int64(written))).Bytes(chunk)
+		if _, err := file.client.request("write", file.name, requestWrite, payload, responseStatus); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Seek sets the offset of the next Read or Write.
+func (file *File) Seek(offset int64, whence int) (int64, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += file.offset
+	case io.SeekEnd:
+		info, err := file.Stat()
+		if err != nil {
+			return file.offset, err
+		}
+		offset += info.Size()
+	}
+	if offset < 0 {
+		return file.offset, &fs.PathError{Op: "seek", Path: file.name, Err: errors.New("negative offset")}
+	}
+	file.offset = offset
+	return offset, nil
+}
+
+// Stat returns the attributes of the open file.
+func (file *File) Stat() (fs.FileInfo, error) {
+	response, err := file.client.request("stat", file.name, requestFstat, new(codec.Encoder).Uint32(file.handle),
+		responseAttrs)
+	if err != nil {
+		return nil, err
+	}
+	info := decodeAttrs(response, response.Text())
+	// As for os.File, the name is the one the file was opened with.
+	info.name = path.Base(file.name)
+	return info, done("stat", file.name, response)
+}
+
+// ReadDir reads the next n entries of the directory, or all remaining ones if n <= 0. Like os.File.ReadDir, it returns
+// io.EOF at the end only if n > 0.
+func (file *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	for n <= 0 || len(entries) < n {
+		batch := readDirBatch
+		if n > 0 && n-len(entries) < batch {
+			batch = n - len(entries)
+		}
+		payload := new(codec.Encoder).Uint32(file.handle).Uint32(uint32(batch))
+		response, err := file.client.request("readdir", file.name, requestReadDir, payload, responseEntries)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return entries, err
+		}
+		count := response.Uint32()
+		for i := uint32(0); i < count && response.Err == nil; i++ {
+			entries = append(entries, dirEntry{info: decodeAttrs(response, response.Text())})
+		}
+		if err := done("readdir", file.name, response); err != nil {
+			return entries, err
+		}
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+// Close closes the file on the server.
+func (file *File) Close() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.closed {
+		return &fs.PathError{Op: "close", Path: file.name, Err: fs.ErrClosed}
+	}
+	file.closed = true
+	_, err := file.client.request("close", file.name, requestClose, new(codec.Encoder).Uint32(file.handle), responseStatus)
+	return err
+}
+
+// FS returns the files below the directory dir on the server as an fs.FS, like os.DirFS does for local ones.
+func (client *Client) FS(dir string) fs.FS {
+	return &remoteFS{client: client, dir: dir}
+}
+
+// remoteFS implements fs.FS along with fs.StatFS, fs.ReadDirFS and fs.ReadFileFS.
+type remoteFS struct {
+	client *Client
+	dir    string
+}
+
+// join checks the name as io/fs requires and joins it to the directory.
+func (fsys *remoteFS) join(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(fsys.dir, name), nil
+}
+
+// rename gives the error the name used with fsys.
+func rename(err error, name string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = name
+	}
+	return err
+}
+
+func (fsys *remoteFS) Open(name string) (fs.File, error) {
+	fullName, err := fsys.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.client.Open(fullName)
+	if err != nil {
+		return nil, rename(err, name)
+	}
+	file.name = name
+	return file, nil
+}
+
+func (fsys *remoteFS) Stat(name string) (fs.FileInfo, error) {
+	fullName, err := fsys.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.client.Stat(fullName)
+	if err != nil {
+		return nil, rename(err, name)
+	}
+	// The name of the root is ".", as for os.DirFS.
+	if name == "." {
+		info.(*fileInfo).name = "."
+	}
+	return info, nil
+}
+
+func (fsys *remoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fullName, err := fsys.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fsys.client.ReadDir(fullName)
+	return entries, rename(err, name)
+}
+
+func (fsys *remoteFS) ReadFile(name string) ([]byte, error) {
+	fullName, err := fsys.join("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fsys.client.ReadFile(fullName)
+	return data, rename(err, name)
+}
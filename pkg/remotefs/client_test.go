@@ -0,0 +1,161 @@
+package remotefs
+
+import (
+	"errors"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/pipetest"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestClient_Files(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient(pipetest.Serve(t, Serve))
+
+	file, err := client.Create(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than fits into a single request.
+	data := make([]byte, 3*maxData+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if n, err := file.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Wrote %d bytes: %v", n, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.ReadAll(file)
+	if err != nil || string(read) != string(data) {
+		t.Errorf("Read %d bytes back: %v", len(read), err)
+	}
+	if info, err := file.Stat(); err != nil || info.Size() != int64(len(data)) || info.Name() != "file" {
+		t.Errorf("Got info %v: %v", info, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := file.Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Closed the file twice: %v", err)
+	}
+
+	file, err = client.OpenFile(filepath.Join(dir, "file"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("end")); err != nil {
+		t.Error(err)
+	}
+	_ = file.Close()
+	if content, _ := os.ReadFile(filepath.Join(dir, "file")); string(content[len(data):]) != "end" {
+		t.Error("Did not append to the file.")
+	}
+
+	if _, err := client.Open(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Opened a missing file: %v", err)
+	}
+	if _, err := client.OpenFile(filepath.Join(dir, "file"), os.O_CREATE|os.O_EXCL, 0600); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Created an existing file exclusively: %v", err)
+	}
+}
+
+func TestClient_Directories(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient(pipetest.Serve(t, Serve))
+
+	if err := client.Mkdir(filepath.Join(dir, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := client.Stat(filepath.Join(dir, "sub")); err != nil || !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Errorf("Got info %v: %v", info, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "a"), []byte("alpha"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rename(filepath.Join(dir, "sub", "a"), filepath.Join(dir, "sub", "b")); err != nil {
+		t.Error(err)
+	}
+	if err := client.Symlink("b", filepath.Join(dir, "sub", "link")); err != nil {
+		t.Error(err)
+	}
+	if target, err := client.Readlink(filepath.Join(dir, "sub", "link")); err != nil || target != "b" {
+		t.Errorf("Got target %q: %v", target, err)
+	}
+	if info, err := client.Lstat(filepath.Join(dir, "sub", "link")); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Got info %v: %v", info, err)
+	}
+	if real, err := client.RealPath(filepath.Join(dir, "sub", "link")); err != nil || filepath.Base(real) != "b" {
+		t.Errorf("Got real path %q: %v", real, err)
+	}
+
+	entries, err := client.ReadDir(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "b" || entries[1].Name() != "link" ||
+		entries[1].Type() != fs.ModeSymlink {
+		t.Errorf("Got entries %v.", entries)
+	}
+
+	if err := client.Remove(filepath.Join(dir, "sub")); err == nil {
+		t.Error("Removed a directory that is not empty.")
+	}
+	for _, name := range []string{"b", "link", ""} {
+		if err := client.Remove(filepath.Join(dir, "sub", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Error("Did not remove the directory.")
+	}
+}
+
+func TestClient_ReadDirBatches(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < readDirBatch+10; i++ {
+		if err := os.WriteFile(filepath.Join(dir, string(rune('a'+i%26))+string(rune('a'+i/26))), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := NewClient(pipetest.Serve(t, Serve))
+	dirFile, err := client.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dirFile.Close()
+	entries, err := dirFile.ReadDir(readDirBatch + 1)
+	if err != nil || len(entries) != readDirBatch+1 {
+		t.Errorf("Read %d entries: %v", len(entries), err)
+	}
+	if entries, err = dirFile.ReadDir(-1); err != nil || len(entries) != 9 {
+		t.Errorf("Read %d remaining entries: %v", len(entries), err)
+	}
+	if _, err = dirFile.ReadDir(1); err != io.EOF {
+		t.Errorf("Read past the end: %v", err)
+	}
+}
+
+func TestClient_FS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "deeper"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "alpha", "sub/b.txt": "beta", "sub/deeper/c": ""} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := NewClient(pipetest.Serve(t, Serve))
+	fsys := client.FS(dir)
+	if err := fstest.TestFS(fsys, "a.txt", "sub/b.txt", "sub/deeper/c"); err != nil {
+		t.Error(err)
+	}
+	if _, err := fsys.Open("../escape"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Opened a path outside: %v", err)
+	}
+}
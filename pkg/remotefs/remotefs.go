@@ -0,0 +1,211 @@
+// Package remotefs implements a request/response protocol to access the files of the user on the server, served by the
+// ftp subsystem. A Client offers the usual file operations and an io/fs view of the remote files.
+//
+// Every packet starts with the length of the rest as uint32, followed by the type as one byte, the id of the request
+// as uint32 and the payload. The client sends one request at a time, the server answers it with a packet of the same
+// id. Integers are big-endian, strings and data are prefixed with their length as uint32.
+//
+//	Open     <flags> <perm> <path>    Handle <handle>
+//	Close    <handle>                 Status
+//	Read     <handle> <offset> <n>    Data <data>, or Status EOF at the end
+//	Write    <handle> <offset> <data> Status
+//	ReadDir  <handle> <n>             Entries <count> (<name> <attrs>)..., or Status EOF at the end
+//	Stat     <path>                   Attrs <name> <attrs>
+//	Lstat    <path>                   Attrs <name> <attrs>
+//	Fstat    <handle>                 Attrs <name> <attrs>
+//	Rename   <old path> <new path>    Status
+//	Remove   <path>                   Status
+//	Mkdir    <perm> <path>            Status
+//	Symlink  <target> <path>          Status
+//	Readlink <path>                   Path <target>
+//	RealPath <path>                   Path <absolute path>
+//
+// Flags are those of os.OpenFile, attrs are the size as uint64, the fs.FileMode as uint32 and the mtime in seconds
+// since the epoch as int64. Every request may be answered with Status <code> <message> instead.
+package remotefs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// The name of the subsystem serving the protocol.
+const Subsystem = "ftp"
+
+// Requests of the client.
+const (
+	requestOpen     = 1
+	requestClose    = 2
+	requestRead     = 3
+	requestWrite    = 4
+	requestReadDir  = 5
+	requestStat     = 6
+	requestLstat    = 7
+	requestFstat    = 8
+	requestRename   = 9
+	requestRemove   = 10
+	requestMkdir    = 11
+	requestSymlink  = 12
+	requestReadlink = 13
+	requestRealPath = 14
+)
+
+// Responses of the server.
+const (
+	responseStatus  = 101
+	responseHandle  = 102
+	responseData    = 103
+	responseEntries = 104
+	responseAttrs   = 105
+	responsePath    = 106
+)
+
+// Status codes.
+const (
+	statusOk         = 0
+	statusEOF        = 1
+	statusNotExist   = 2
+	statusExist      = 3
+	statusPermission = 4
+	statusFailure    = 5
+)
+
+const (
+	// The largest packet either side accepts.
+	maxPacket = 256 * 1024
+	// The most data a single read or write transfers.
+	maxData = 64 * 1024
+	// How many entries a directory gets read in at once.
+	readDirBatch = 128
+	// How many files and directories a client may have open at once.
+	maxHandles = 256
+)
+
+// writePacket writes a packet of the type for the request id.
+func writePacket(out io.Writer, packetType byte, id uint32, payload []byte) error {
+	if len(payload)+5 > maxPacket {
+		return errors.New("packet too large")
+	}
+	packet := make([]byte, 9, 9+len(payload))
+	binary.BigEndian.PutUint32(packet[0:4], uint32(5+len(payload)))
+	packet[4] = packetType
+	binary.BigEndian.PutUint32(packet[5:9], id)
+	_, err := out.Write(append(packet, payload...))
+	return err
+}
+
+// readPacket reads a packet and returns its type, request id and payload.
+func readPacket(in io.Reader) (byte, uint32, *codec.Decoder, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return 0, 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < 5 || length > maxPacket {
+		return 0, 0, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	payload := make([]byte, length-5)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[4], binary.BigEndian.Uint32(header[5:9]), codec.NewDecoder(payload), nil
+}
+
+// encodeAttrs appends the size, mode and modification time of the file.
+func encodeAttrs(encoder *codec.Encoder, info fs.FileInfo) *codec.Encoder {
+	return encoder.Uint64(uint64(info.Size())).Uint32(uint32(info.Mode())).Uint64(uint64(info.ModTime().Unix()))
+}
+
+// decodeAttrs reads the attributes encodeAttrs appended for the file with the name.
+func decodeAttrs(decoder *codec.Decoder, name string) *fileInfo {
+	return &fileInfo{
+		name:  name,
+		size:  int64(decoder.Uint64()),
+		mode:  fs.FileMode(decoder.Uint32()),
+		mtime: time.Unix(int64(decoder.Uint64()), 0),
+	}
+}
+
+// fileInfo describes a remote file.
+type fileInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (info *fileInfo) Name() string       { return info.name }
+func (info *fileInfo) Size() int64        { return info.size }
+func (info *fileInfo) Mode() fs.FileMode  { return info.mode }
+func (info *fileInfo) ModTime() time.Time { return info.mtime }
+func (info *fileInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info *fileInfo) Sys() interface{}   { return nil }
+
+// dirEntry is a remote directory entry along with its attributes.
+type dirEntry struct {
+	info *fileInfo
+}
+
+func (entry dirEntry) Name() string               { return entry.info.name }
+func (entry dirEntry) IsDir() bool                { return entry.info.IsDir() }
+func (entry dirEntry) Type() fs.FileMode          { return entry.info.mode.Type() }
+func (entry dirEntry) Info() (fs.FileInfo, error) { return entry.info, nil }
+func (entry dirEntry) String() string             { return fs.FormatDirEntry(entry) }
+
+// A statusError is a failure the server reported. It matches the errors of io/fs for the respective status codes.
+type statusError struct {
+	code uint32
+	msg  string
+}
+
+func (err *statusError) Error() string {
+	return err.msg
+}
+
+func (err *statusError) Is(target error) bool {
+	switch err.code {
+	case statusEOF:
+		return target == io.EOF
+	case statusNotExist:
+		return target == fs.ErrNotExist
+	case statusExist:
+		return target == fs.ErrExist
+	case statusPermission:
+		return target == fs.ErrPermission
+	}
+	return false
+}
+
+// statusOf returns the status code and message the server answers the error with. The message leaves out the path,
+// which the client knows already.
+func statusOf(err error) (uint32, string) {
+	if err == nil {
+		return statusOk, ""
+	}
+	msg := err.Error()
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) {
+		msg = pathErr.Err.Error()
+	} else if errors.As(err, &linkErr) {
+		msg = linkErr.Err.Error()
+	}
+	switch {
+	case errors.Is(err, io.EOF):
+		return statusEOF, msg
+	case errors.Is(err, fs.ErrNotExist):
+		return statusNotExist, msg
+	case errors.Is(err, fs.ErrExist):
+		return statusExist, msg
+	case errors.Is(err, fs.ErrPermission):
+		return statusPermission, msg
+	default:
+		return statusFailure, msg
+	}
+}
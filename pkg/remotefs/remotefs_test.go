@@ -0,0 +1,66 @@
+package remotefs
+
+import (
+	"bytes"
+	"errors"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestPacket(t *testing.T) {
+	var buffer bytes.Buffer
+	payload := new(codec.Encoder).Uint32(7).Uint64(1 << 40).Text("name").Bytes([]byte{1, 2})
+	if err := writePacket(&buffer, requestWrite, 42, payload.Data); err != nil {
+		t.Fatal(err)
+	}
+	packetType, id, decoder, err := readPacket(&buffer)
+	if err != nil || packetType != requestWrite || id != 42 {
+		t.Fatalf("Read packet %d with id %d: %v", packetType, id, err)
+	}
+	if decoder.Uint32() != 7 || decoder.Uint64() != 1<<40 || decoder.Text() != "name" ||
+		!bytes.Equal(decoder.Bytes(), []byte{1, 2}) || decoder.Err != nil {
+		t.Error("Decoded the payload wrongly.")
+	}
+	if decoder.Uint32(); decoder.Err == nil {
+		t.Error("Decoded past the end of the payload.")
+	}
+}
+
+func TestPacket_Invalid(t *testing.T) {
+	if err := writePacket(io.Discard, requestWrite, 1, make([]byte, maxPacket)); err == nil {
+		t.Error("Wrote a packet that is too large.")
+	}
+	if _, _, _, err := readPacket(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, requestOpen, 0, 0, 0, 1})); err == nil {
+		t.Error("Read a packet that is too large.")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   uint32
+		target error
+	}{
+		{io.EOF, statusEOF, io.EOF},
+		{&fs.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}, statusNotExist, fs.ErrNotExist},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: fs.ErrExist}, statusExist, fs.ErrExist},
+		{fs.ErrPermission, statusPermission, fs.ErrPermission},
+		{errors.New("failure"), statusFailure, nil},
+	}
+	for _, test := range tests {
+		code, msg := statusOf(test.err)
+		if code != test.code {
+			t.Errorf("Got code %d for %v.", code, test.err)
+		}
+		err := &statusError{code: code, msg: msg}
+		if test.target != nil && !errors.Is(err, test.target) {
+			t.Errorf("%v does not match %v.", err, test.target)
+		}
+	}
+	if _, msg := statusOf(&fs.PathError{Op: "open", Path: "secret", Err: os.ErrNotExist}); msg != os.ErrNotExist.Error() {
+		t.Errorf("Got message %q.", msg)
+	}
+}
@@ -0,0 +1,217 @@
+package remotefs
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/codec"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// A server answers the requests of a client with the files on this host.
+type server struct {
+	conn       io.ReadWriter
+	handles    map[uint32]*os.File
+	appending  map[uint32]bool // The handles of files opened with os.O_APPEND, which cannot be written at an offset.
+	nextHandle uint32
+}
+
+// Serve serves the requests of a client on conn until it goes away. Paths are relative to the working directory.
+func Serve(conn io.ReadWriter) error {
+	log.Traceln("--> remotefs.Serve")
+	server := &server{conn: conn, handles: map[uint32]*os.File{}, appending: map[uint32]bool{}}
+	defer server.closeHandles()
+	for {
+		requestType, id, request, err := readPacket(conn)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			log.WithError(err).Errorln("Failed to read request.")
+			return err
+		}
+		if err := server.serve(requestType, id, request); err != nil {
+			log.WithError(err).Errorln("Failed to answer request.")
+			return err
+		}
+	}
+}
+
+// serve answers a single request. The error is about the connection, failures of the request go to the client.
+func (server *server) serve(requestType byte, id uint32, request *codec.Decoder) error {
+	var response *codec.Encoder
+	responseType := byte(responseStatus)
+	var err error
+	switch requestType {
+	case requestOpen:
+		flag, perm, path := request.Uint32(), request.Uint32(), request.Text()
+		if err = request.Err; err == nil {
+			var handle uint32
+			if handle, err = server.open(path, int(flag), fs.FileMode(perm)); err == nil {
+				responseType, response = responseHandle, new(codec.Encoder).Uint32(handle)
+			}
+		}
+	case requestClose:
+		handle := request.Uint32()
+		var file *os.File
+		if file, err = server.handle(handle, request.Err); err == nil {
+			delete(server.handles, handle)
+			delete(server.appending, handle)
+			err = file.Close()
+		}
+	case requestRead:
+		handle, offset, n := request.Uint32(), request.Uint64(), request.Uint32()
+		var file *os.File
+		if file, err = server.handle(handle, request.Err); err == nil {
+			if n > maxData {
+				n = maxData
+			}
+			data := make([]byte, n)
+			read, readErr := file.ReadAt(data, int64(offset))
+			if read > 0 || n == 0 {
+				responseType, response = responseData, new(codec.Encoder).Bytes(data[:read])
+			} else {
+				err = readErr
+			}
+		}
+	case requestWrite:
+		handle, offset, data := request.Uint32(), request.Uint64(), request.Bytes()
+		var file *os.File
+		if file, err = server.handle(handle, request.Err); err == nil {
+			if server.appending[handle] {
+				_, err = file.Write(data)
+			} else {
+				_, err = file.WriteAt(data, int64(offset))
+			}
+		}
+	case requestReadDir:
+		handle, n := request.Uint32(), request.Uint32()
+		var file *os.File
+		if file, err = server.handle(handle, request.Err); err == nil {
+			if n == 0 || n > readDirBatch {
+				n = readDirBatch
+			}
+			var entries []fs.DirEntry
+			if entries, err = file.ReadDir(int(n)); err == nil {
+				responseType, response = responseEntries, server.entries(file.Name(), entries)
+			}
+		}
+	case requestStat, requestLstat:
+		path := request.Text()
+		if err = request.Err; err == nil {
+			var info fs.FileInfo
+			if requestType == requestStat {
+				info, err = os.Stat(path)
+			} else {
+				info, err = os.Lstat(path)
+			}
+			if err == nil {
+				responseType, response = responseAttrs, encodeAttrs(new(codec.Encoder).Text(info.Name()), info)
+			}
+		}
+	case requestFstat:
+		var file *os.File
+		if file, err = server.handle(request.Uint32(), request.Err); err == nil {
+			var info fs.FileInfo
+			if info, err = file.Stat(); err == nil {
+				responseType, response = responseAttrs, encodeAttrs(new(codec.Encoder).Text(info.Name()), info)
+			}
+		}
+	case requestRename:
+		oldPath, newPath := request.Text(), request.Text()
+		if err = request.Err; err == nil {
+			err = os.Rename(oldPath, newPath)
+		}
+	case requestRemove:
+		path := request.Text()
+		if err = request.Err; err == nil {
+			err = os.Remove(path)
+		}
+	case requestMkdir:
+		perm, path := request.Uint32(), request.Text()
+		if err = request.Err; err == nil {
+			err = os.Mkdir(path, fs.FileMode(perm)&fs.ModePerm)
+		}
+	case requestSymlink:
+		target, path := request.Text(), request.Text()
+		if err = request.Err; err == nil {
+			err = os.Symlink(target, path)
+		}
+	case requestReadlink, requestRealPath:
+		path := request.Text()
+		if err = request.Err; err == nil {
+			var result string
+			if requestType == requestReadlink {
+				result, err = os.Readlink(path)
+			} else if result, err = filepath.Abs(path); err == nil {
+				result, err = filepath.EvalSymlinks(result)
+			}
+			if err == nil {
+				responseType, response = responsePath, new(codec.Encoder).Text(result)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown request %d", requestType)
+	}
+	if responseType == responseStatus {
+		code, msg := statusOf(err)
+		if code != statusOk && code != statusEOF {
+			log.WithError(err).WithField("request", requestType).Debugln("Failed to serve request.")
+		}
+		response = new(codec.Encoder).Uint32(code).Text(msg)
+	}
+	return writePacket(server.conn, responseType, id, response.Data)
+}
+
+// open opens the file or directory and returns the handle for it.
+func (server *server) open(path string, flag int, perm fs.FileMode) (uint32, error) {
+	if len(server.handles) >= maxHandles {
+		return 0, errors.New("too many open files")
+	}
+	file, err := os.OpenFile(path, flag, perm&fs.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+	server.nextHandle++
+	server.handles[server.nextHandle] = file
+	server.appending[server.nextHandle] = flag&os.O_APPEND != 0
+	return server.nextHandle, nil
+}
+
+// handle returns the file of the handle, unless the request could not be decoded.
+func (server *server) handle(handle uint32, err error) (*os.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	file, ok := server.handles[handle]
+	if !ok {
+		return nil, fs.ErrClosed
+	}
+	return file, nil
+}
+
+// entries encodes the directory entries along with their attributes. A batch of them easily fits into a packet.
+func (server *server) entries(dir string, entries []fs.DirEntry) *codec.Encoder {
+	list := new(codec.Encoder)
+	count := uint32(0)
+	for _, entry := range entries {
+		// Entries that vanished in the meantime get left out.
+		info, err := os.Lstat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		encodeAttrs(list.Text(entry.Name()), info)
+		count++
+	}
+	return new(codec.Encoder).Uint32(count).Raw(list.Data)
+}
+
+func (server *server) closeHandles() {
+	for handle, file := range server.handles {
+		_ = file.Close()
+		delete(server.handles, handle)
+		delete(server.appending, handle)
+	}
+}
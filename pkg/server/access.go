@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net"
+)
+
+// permittedAddress checks the address against the Access.DenyFrom and Access.AllowFrom lists. An empty allow list
+// allows every address that is not denied. Connections without an IP address are always permitted.
+func permittedAddress(config *viper.Viper, ip net.IP) error {
+	log.WithField("ip", ip.String()).Traceln("--> server.permittedAddress")
+	if ip == nil {
+		return nil
+	}
+	if matchesAddress(config.GetStringSlice("Access.DenyFrom"), ip) {
+		return fmt.Errorf("%s is denied", ip)
+	}
+	allowFrom := config.GetStringSlice("Access.AllowFrom")
+	if len(allowFrom) > 0 && !matchesAddress(allowFrom, ip) {
+		return fmt.Errorf("%s is not allowed", ip)
+	}
+	return nil
+}
+
+// permittedUser checks the user name against the Access.DenyUsers and Access.AllowUsers lists. An empty allow list
+// allows every user that is not denied.
+func permittedUser(config *viper.Viper, user string) error {
+	log.WithField("user", user).Traceln("--> server.permittedUser")
+	if contains(config.GetStringSlice("Access.DenyUsers"), user) {
+		return fmt.Errorf("user %s is denied", user)
+	}
+	allowUsers := config.GetStringSlice("Access.AllowUsers")
+	if len(allowUsers) > 0 && !contains(allowUsers, user) {
+		return fmt.Errorf("user %s is not allowed", user)
+	}
+	return nil
+}
+
+// matchesAddress tells whether the address matches any of the entries, which are either addresses or networks in
+// CIDR notation.
+func matchesAddress(entries []string, ip net.IP) bool {
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if entryIp := net.ParseIP(entry); entryIp != nil && entryIp.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func validAddressList(entries []string) error {
+	for _, entry := range entries {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("%q is neither an address nor a network", entry)
+		}
+	}
+	return nil
+}
+
+func contains(entries []string, str string) bool {
+	for _, entry := range entries {
+		if entry == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+)
+
+// The first file descriptor passed by systemd, see sd_listen_fds(3).
+var listenFdsStart = 3
+
+// activationListeners returns the listening sockets systemd passed to goshd through LISTEN_FDS and LISTEN_PID. It
+// returns no sockets if goshd was not socket activated.
+func activationListeners() ([]int, error) {
+	log.Traceln("--> server.activationListeners")
+	pidStr, pidSet := os.LookupEnv("LISTEN_PID")
+	fdsStr, fdsSet := os.LookupEnv("LISTEN_FDS")
+	if !pidSet || !fdsSet {
+		return nil, nil
+	}
+	// The variables are meant for goshd only and must not be passed on to goshh.
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(env)
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID %q", pidStr)
+	}
+	if pid != os.Getpid() {
+		log.WithField("pid", pid).Debugln("LISTEN_PID is meant for another process.")
+		return nil, nil
+	}
+	nFds, err := strconv.Atoi(fdsStr)
+	if err != nil || nFds < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fdsStr)
+	}
+	var listeners []int
+	for fd := listenFdsStart; fd < listenFdsStart+nFds; fd++ {
+		accepting, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
+		if err != nil {
+			return nil, fmt.Errorf("passed file descriptor %d: %s", fd, err.Error())
+		}
+		if accepting == 0 {
+			return nil, fmt.Errorf("passed file descriptor %d is not a listening socket", fd)
+		}
+		unix.CloseOnExec(fd)
+		listeners = append(listeners, fd)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("socket activated without any sockets")
+	}
+	log.WithField("listeners", listeners).Infoln("Using sockets passed by systemd.")
+	return listeners, nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestActivationListeners(t *testing.T) {
+	fd, err := listenSocket(listenAddress{sockAddr: &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	defer func(start int) { listenFdsStart = start }(listenFdsStart)
+	listenFdsStart = fd
+
+	_ = os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	_ = os.Setenv("LISTEN_FDS", "1")
+	if listeners, err := activationListeners(); err != nil || len(listeners) != 0 {
+		t.Error("Sockets meant for another process were used.")
+	}
+
+	_ = os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	_ = os.Setenv("LISTEN_FDS", "1")
+	listeners, err := activationListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || listeners[0] != fd {
+		t.Error("Passed socket was not used.")
+	}
+	if _, set := os.LookupEnv("LISTEN_FDS"); set {
+		t.Error("LISTEN_FDS was not unset.")
+	}
+	if listeners, err := activationListeners(); err != nil || len(listeners) != 0 {
+		t.Error("Sockets were used twice.")
+	}
+}
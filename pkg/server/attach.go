@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/connection"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"os"
+	"strings"
+	"time"
+)
+
+// resume attaches the client to the session it resumes, which is served by another goshh process. The session token
+// authenticates the client.
+func (host *Host) resume() error {
+	log.Traceln("--> host.Host.resume")
+	ErrorMsg := "Failed to resume session."
+	attachment, err := resumeSession(host.config.GetString("Sessions.Dir"), host.resumeToken)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.report(Event{Type: EventAuthFailed, Value: host.userName})
+		host.reject("cannot resume session: " + err.Error())
+		return err
+	}
+	defer utils.CloseConn(attachment.Conn)
+	if err = host.authorize(attachment.User); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject(err.Error())
+		return err
+	}
+	if err = host.stopTransfer(true); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	log.WithField("user", attachment.User).Infoln("Resuming session.")
+	return host.joinSession(attachment)
+}
+
+// attach authenticates the user without starting a shell and then attaches the client to one of the user's detached
+// sessions, or lists them. Password logins go through login(1), which starts a shell, so attaching needs key or peer
+// credential authentication.
+func (host *Host) attach() error {
+	log.WithField("attachId", host.attachId).Traceln("--> host.Host.attach")
+	ErrorMsg := "Failed to attach to session."
+	pwd, err := host.authenticateWithoutShell()
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject("attaching to a session needs key authentication")
+		return err
+	}
+	if err = host.authorize(pwd.Name); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject(err.Error())
+		return err
+	}
+	if host.attachId == common.ATTACH_LIST {
+		if err = host.stopTransfer(true); err == nil {
+			host.listDetachedSessions(pwd.Name)
+		}
+		return err
+	}
+	attachment, err := attachSession(host.config.GetString("Sessions.Dir"), host.attachId, pwd.Name)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject("cannot attach to session: " + err.Error())
+		return err
+	}
+	defer utils.CloseConn(attachment.Conn)
+	packet := connection.SessionPacket{Token: attachment.Token, Offset: attachment.Offset}
+	if _, err = fmt.Fprint(host.conn, packet.String()); err == nil {
+		err = host.stopTransfer(true)
+	}
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	log.WithFields(log.Fields{
+		"user": pwd.Name,
+		"id":   host.attachId,
+	}).Infoln("Attaching to session.")
+	return host.joinSession(attachment)
+}
+
+// authenticateWithoutShell authenticates the user by the peer credentials or by keys.
+func (host *Host) authenticateWithoutShell() (*passwd.PassWd, error) {
+	log.Traceln("--> host.Host.authenticateWithoutShell")
+	if pwd := host.peerCredentialUser(); pwd != nil {
+		return pwd, nil
+	}
+	if host.userName == "" {
+		return nil, errors.New("no user to authenticate")
+	}
+	if err := host.authenticateWithKeys(host.userName); err != nil {
+		if !os.IsNotExist(err) {
+			host.report(Event{Type: EventAuthFailed, Value: host.userName})
+		}
+		return nil, err
+	}
+	return passwd.GetPwByName(host.userName)
+}
+
+// joinSession attaches the client to the session of another goshh and relays between them until either side leaves.
+func (host *Host) joinSession(attachment *sessionAttachment) error {
+	log.Traceln("--> host.Host.joinSession")
+	if err := confirmAttachment(attachment); err != nil {
+		log.WithError(err).Errorln("Failed to join session.")
+		return err
+	}
+	go func() {
+		utils.Forward(host.conn, attachment.Conn, "client", "session")
+		_ = attachment.Conn.Close()
+	}()
+	utils.Forward(attachment.Conn, host.conn, "session", "client")
+	return nil
+}
+
+// listDetachedSessions writes the detached sessions of the user to the client.
+func (host *Host) listDetachedSessions(user string) {
+	log.WithField("user", user).Traceln("--> host.Host.listDetachedSessions")
+	detached := 0
+	for _, session := range listSessions(host.config.GetString("Sessions.Dir")) {
+		if session.User != user || session.Attached {
+			continue
+		}
+		host.writeLine(fmt.Sprintf("%s\tdetached since %s", session.Id, session.Detached.Format(time.ANSIC)))
+		detached++
+	}
+	if detached == 0 {
+		host.writeLine("No detached sessions.")
+	}
+}
+
+// shareSession handles the share request of the client. It either lets the owner of a session invite another user
+// into it, as "invite <id> <user> <ro|rw|none>", or lets an invited user join it, as "join <id>".
+func (host *Host) shareSession() error {
+	log.WithField("share", host.share).Traceln("--> host.Host.shareSession")
+	ErrorMsg := "Failed to share session."
+	fields := strings.Fields(host.share)
+	if !(len(fields) == 2 && fields[0] == "join") && !(len(fields) == 4 && fields[0] == "invite") {
+		err := errors.New("malformed share request")
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject(err.Error())
+		return err
+	}
+	pwd, err := host.authenticateWithoutShell()
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject("sharing a session needs key authentication")
+		return err
+	}
+	if err = host.authorize(pwd.Name); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject(err.Error())
+		return err
+	}
+	dir := host.config.GetString("Sessions.Dir")
+	if fields[0] == "invite" {
+		if _, err = passwd.GetPwByName(fields[2]); err != nil {
+			err = fmt.Errorf("unknown user %s", fields[2])
+		} else {
+			err = inviteToSession(dir, fields[1], pwd.Name, fields[2], fields[3])
+		}
+		if err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			host.reject("cannot invite to session: " + err.Error())
+			return err
+		}
+		if err = host.stopTransfer(true); err == nil {
+			host.writeLine(fmt.Sprintf("Changed access of %s to session %s.", fields[2], fields[1]))
+		}
+		return err
+	}
+	attachment, err := joinSessionAs(dir, fields[1], pwd.Name)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject("cannot join session: " + err.Error())
+		return err
+	}
+	defer utils.CloseConn(attachment.Conn)
+	if err = host.stopTransfer(true); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	log.WithFields(log.Fields{
+		"user":  pwd.Name,
+		"id":    fields[1],
+		"owner": attachment.User,
+	}).Infoln("Joining session.")
+	return host.joinSession(attachment)
+}
@@ -1,22 +1,99 @@
 package server
 
 import (
-	"github.com/fsnotify/fsnotify"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/accounting"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/deltasync"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/remotefs"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/transfer"
+	"golang.org/x/sys/unix"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// Settings with these prefixes get applied when the config is reloaded.
+var reloadableSettings = []string{
+	"serve.maxsessions",
+	"serve.shutdowngracetime",
+	"logging.",
+	"limits.",
+	"access.",
+}
+
+// Settings with these prefixes only take effect after a restart.
+var restartSettings = []string{
+	"serve.",
+}
+
+// configLock guards the config of a running goshd against concurrent reloads.
+var configLock sync.RWMutex
+
 func setDefaults(config *viper.Viper) {
 	log.WithField("config", config).Traceln("--> server.setDefaults")
 	config.SetDefault("Serve.Port", common.PORT)
 	config.SetDefault("Serve.Protocol", common.TCP)
+	config.SetDefault("Serve.ListenAddress", []string{})
+	config.SetDefault("Serve.ListenBacklog", unix.SOMAXCONN)
+	config.SetDefault("Serve.MaxSessions", 10)
+	config.SetDefault("Serve.MaxSessionsPerUser", 0)
+	config.SetDefault("Serve.ShutdownGraceTime", 30)
+	config.SetDefault("Serve.UnixSocket", "")
+	config.SetDefault("Serve.UnixSocketMode", "0666")
+	config.SetDefault("Serve.TCPKeepAlive", true)
 	config.SetDefault("Logging.LogLevel", "info")
 	config.SetDefault("Authentication.KeyStore", common.AUTHPATH)
 	config.SetDefault("Authentication.LoginGraceTime", 120)
 	config.SetDefault("Authentication.PermitRootLogin", false)
 	config.SetDefault("Authentication.MaxTries", 6)
-	config.SetDefault("Authentication.MaxSessions", 10)
+	config.SetDefault("Authentication.PeerCredentials", true)
+	config.SetDefault("Authentication.PeerCredentialsRoot", false)
+	config.SetDefault("Environment.Path", "/usr/local/bin:/usr/bin:/bin")
+	config.SetDefault("Environment.RootPath", "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+	config.SetDefault("Environment.MailDir", "/var/mail")
+	config.SetDefault("Environment.SystemFile", "/etc/environment")
+	config.SetDefault("Environment.PermitUserEnvironment", false)
+	config.SetDefault("Environment.AcceptEnv", []string{"TERM", "LANG"})
+	config.SetDefault("Login.Banner", "")
+	config.SetDefault("Login.PrintMotd", true)
+	config.SetDefault("Login.MotdFile", "/etc/motd")
+	config.SetDefault("Login.UpdateMotd", false)
+	config.SetDefault("Login.UpdateMotdDir", "/etc/update-motd.d")
+	config.SetDefault("Login.UpdateMotdTimeout", 5)
+	config.SetDefault("Login.PrintLastLog", true)
+	config.SetDefault("Accounting.Utmp", accounting.UTMP)
+	config.SetDefault("Accounting.Wtmp", accounting.WTMP)
+	config.SetDefault("Accounting.Lastlog", accounting.LASTLOG)
+	config.SetDefault("Sessions.Resumable", false)
+	config.SetDefault("Sessions.Dir", "/run/gosh/sessions")
+	config.SetDefault("Sessions.BufferSize", 256*1024)
+	config.SetDefault("Sessions.Timeout", 600)
+	config.SetDefault("Sessions.AuditFile", "")
+	config.SetDefault("Forwarding.AllowTcpForwarding", true)
+	config.SetDefault("Forwarding.PermitOpen", []string{})
+	config.SetDefault("Forwarding.GatewayPorts", gatewayPortsNo)
+	config.SetDefault("Forwarding.AllowStreamLocalForwarding", true)
+	config.SetDefault("Forwarding.StreamLocalBindUnlink", streamLocalBindUnlinkStale)
+	config.SetDefault("Forwarding.StreamLocalBindMode", "0600")
+	config.SetDefault("Subsystems.Enabled", []string{transfer.Subsystem, remotefs.Subsystem, deltasync.Subsystem})
+	config.SetDefault("Limits.RateWindow", 60)
+	config.SetDefault("Limits.MaxConnectionsPerHost", 10)
+	config.SetDefault("Limits.MaxConnectionsPerSubnet", 30)
+	config.SetDefault("Limits.SubnetMaskIPv4", 24)
+	config.SetDefault("Limits.SubnetMaskIPv6", 64)
+	config.SetDefault("Limits.MaxUnauthenticated", 10)
+	config.SetDefault("Limits.BanAfterFailures", 5)
+	config.SetDefault("Limits.FailureWindow", 600)
+	config.SetDefault("Limits.BanTime", 3600)
+	config.SetDefault("Limits.BanFile", "")
+	config.SetDefault("Access.AllowFrom", []string{})
+	config.SetDefault("Access.DenyFrom", []string{})
+	config.SetDefault("Access.AllowUsers", []string{})
+	config.SetDefault("Access.DenyUsers", []string{})
 }
 
 func init() {
@@ -24,11 +101,7 @@ func init() {
 
 func LoadConfig(configpath string) *viper.Viper {
 	log.WithField("configpath", configpath).Traceln("--> server.LoadConfig")
-	config := viper.New()
-	config.SetConfigName(common.SERVERNAME + "_config")
-	config.AddConfigPath(configpath)
-	config.SetConfigType(common.CONFIGFORMAT)
-	setDefaults(config)
+	config := newConfig(configpath)
 	err := config.ReadInConfig()
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -36,11 +109,150 @@ func LoadConfig(configpath string) *viper.Viper {
 		}).Warnln("Failed to read config file. Using defaults instead.")
 		return config
 	}
-	config.WatchConfig()
-	config.OnConfigChange(func(e fsnotify.Event) {
-		log.WithFields(log.Fields{
-			"name": e.Name,
-		}).Warnln("LoadConfig file changed.")
-	})
+	if err := validateConfig(config); err != nil {
+		log.WithError(err).Warnln("Config file is invalid.")
+	}
+	return config
+}
+
+// ReloadConfig reads the config file again and, if it is valid, applies the settings that can change at runtime to
+// config. Changed settings that need a restart are reported.
+func ReloadConfig(config *viper.Viper, configpath string) error {
+	log.WithField("configpath", configpath).Traceln("--> server.ReloadConfig")
+	ErrorMsg := "Failed to reload config."
+	fresh := newConfig(configpath)
+	if err := fresh.ReadInConfig(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	if err := validateConfig(fresh); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	configLock.Lock()
+	defer configLock.Unlock()
+	for _, key := range fresh.AllKeys() {
+		oldValue, newValue := config.Get(key), fresh.Get(key)
+		if fmt.Sprint(oldValue) == fmt.Sprint(newValue) {
+			continue
+		}
+		fields := log.Fields{
+			"key":      key,
+			"oldValue": oldValue,
+			"newValue": newValue,
+		}
+		if hasAnyPrefix(key, reloadableSettings) {
+			config.Set(key, newValue)
+			log.WithFields(fields).Infoln("Applied changed setting.")
+		} else if hasAnyPrefix(key, restartSettings) {
+			log.WithFields(fields).Warnln("Changed setting needs a restart to take effect.")
+		}
+	}
+	if level, err := log.ParseLevel(config.GetString("Logging.LogLevel")); err == nil {
+		log.SetLevel(level)
+	}
+	log.Infoln("Reloaded config.")
+	return nil
+}
+
+func newConfig(configpath string) *viper.Viper {
+	config := viper.New()
+	config.SetConfigName(common.SERVERNAME + "_config")
+	config.AddConfigPath(configpath)
+	config.SetConfigType(common.CONFIGFORMAT)
+	setDefaults(config)
 	return config
 }
+
+func validateConfig(config *viper.Viper) error {
+	log.WithField("config", config).Traceln("--> server.validateConfig")
+	if port := config.GetInt("Serve.Port"); port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	switch config.GetString("Serve.Protocol") {
+	case common.TCP, common.TCP4, common.TCP6:
+	default:
+		return errors.New("protocol has to be either tcp, tcp4 or tcp6")
+	}
+	if _, err := listenAddresses(config); err != nil {
+		return err
+	}
+	if mode, err := strconv.ParseUint(config.GetString("Serve.UnixSocketMode"), 8, 32); err != nil || mode > 0777 {
+		return fmt.Errorf("invalid Unix socket mode %q", config.GetString("Serve.UnixSocketMode"))
+	}
+	if _, err := log.ParseLevel(config.GetString("Logging.LogLevel")); err != nil {
+		return err
+	}
+	for _, key := range []string{
+		"Serve.MaxSessions",
+		"Serve.MaxSessionsPerUser",
+		"Serve.ShutdownGraceTime",
+		"Login.UpdateMotdTimeout",
+		"Sessions.Timeout",
+		"Limits.RateWindow",
+		"Limits.MaxConnectionsPerHost",
+		"Limits.MaxConnectionsPerSubnet",
+		"Limits.MaxUnauthenticated",
+		"Limits.BanAfterFailures",
+		"Limits.FailureWindow",
+		"Limits.BanTime",
+	} {
+		if config.GetInt(key) < 0 {
+			return fmt.Errorf("%s cannot be negative", key)
+		}
+	}
+	if bits := config.GetInt("Limits.SubnetMaskIPv4"); bits < 0 || bits > 32 {
+		return fmt.Errorf("invalid IPv4 subnet mask /%d", bits)
+	}
+	if bits := config.GetInt("Limits.SubnetMaskIPv6"); bits < 0 || bits > 128 {
+		return fmt.Errorf("invalid IPv6 subnet mask /%d", bits)
+	}
+	if size := config.GetInt("Sessions.BufferSize"); size <= 0 {
+		return fmt.Errorf("invalid session buffer size %d", size)
+	}
+	if err := validEnvNames(config.GetStringSlice("Environment.AcceptEnv")); err != nil {
+		return fmt.Errorf("Environment.AcceptEnv: %s", err.Error())
+	}
+	switch config.GetString("Forwarding.GatewayPorts") {
+	case gatewayPortsNo, gatewayPortsYes, gatewayPortsClientSpecified:
+	default:
+		return errors.New("Forwarding.GatewayPorts has to be either no, yes or clientspecified")
+	}
+	switch config.GetString("Forwarding.StreamLocalBindUnlink") {
+	case streamLocalBindUnlinkNo, streamLocalBindUnlinkStale, streamLocalBindUnlinkYes:
+	default:
+		return errors.New("Forwarding.StreamLocalBindUnlink has to be either no, stale or yes")
+	}
+	bindMode := config.GetString("Forwarding.StreamLocalBindMode")
+	if mode, err := strconv.ParseUint(bindMode, 8, 32); err != nil || mode > 0777 {
+		return fmt.Errorf("invalid Forwarding.StreamLocalBindMode %q", bindMode)
+	}
+	for _, name := range config.GetStringSlice("Subsystems.Enabled") {
+		if _, ok := subsystems[name]; !ok {
+			return fmt.Errorf("Subsystems.Enabled: unknown subsystem %q", name)
+		}
+	}
+	for _, rule := range config.GetStringSlice("Forwarding.PermitOpen") {
+		if err := validPermitOpen(rule); err != nil {
+			return fmt.Errorf("Forwarding.PermitOpen: %s", err.Error())
+		}
+	}
+	if err := validResourceLimits(config); err != nil {
+		return err
+	}
+	for _, key := range []string{"Access.AllowFrom", "Access.DenyFrom"} {
+		if err := validAddressList(config.GetStringSlice(key)); err != nil {
+			return fmt.Errorf("%s: %s", key, err.Error())
+		}
+	}
+	return nil
+}
+
+func hasAnyPrefix(str string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(str, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"path"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, dir string, content string) {
+	if err := ioutil.WriteFile(path.Join(dir, "goshd_config.toml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "[Serve]\nPort = 2222\n[Limits]\nBanTime = 10\n")
+	config := LoadConfig(dir)
+	if config.GetInt("Limits.BanTime") != 10 {
+		t.Fatal("Config file was not loaded.")
+	}
+
+	writeTestConfig(t, dir, "[Serve]\nPort = 3333\n[Limits]\nBanTime = 20\n[Access]\nDenyUsers = [\"root\"]\n")
+	if err := ReloadConfig(config, dir); err != nil {
+		t.Fatal(err)
+	}
+	if config.GetInt("Limits.BanTime") != 20 {
+		t.Error("Changed limit was not applied.")
+	}
+	if err := permittedUser(config, "root"); err == nil {
+		t.Error("Changed access list was not applied.")
+	}
+	if config.GetInt("Serve.Port") != 2222 {
+		t.Error("Changed port was applied without a restart.")
+	}
+
+	writeTestConfig(t, dir, "[Limits]\nBanTime = -1\n")
+	if err := ReloadConfig(config, dir); err == nil {
+		t.Error("Invalid config was accepted.")
+	}
+	if config.GetInt("Limits.BanTime") != 20 {
+		t.Error("Invalid config was applied.")
+	}
+}
+
+func TestPermittedAddress(t *testing.T) {
+	config := LoadConfig("")
+	config.Set("Access.AllowFrom", []string{"192.0.2.0/24", "2001:db8::1"})
+	config.Set("Access.DenyFrom", []string{"192.0.2.13"})
+	if err := permittedAddress(config, net.ParseIP("192.0.2.1")); err != nil {
+		t.Error(err)
+	}
+	if err := permittedAddress(config, net.ParseIP("2001:db8::1")); err != nil {
+		t.Error(err)
+	}
+	if err := permittedAddress(config, net.ParseIP("192.0.2.13")); err == nil {
+		t.Error("Denied address was permitted.")
+	}
+	if err := permittedAddress(config, net.ParseIP("198.51.100.1")); err == nil {
+		t.Error("Address outside of the allow list was permitted.")
+	}
+}
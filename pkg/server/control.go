@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Events are reported by a goshh process to its goshd parent over the control channel, one per line. The goshd
+// process answers every EventAuthSucceeded with either EventAccepted or EventRejected.
+const (
+	EventAuthSucceeded = "auth-ok"
+	EventAuthFailed    = "auth-failed"
+	EventAccepted      = "accepted"
+	EventRejected      = "rejected"
+)
+
+type Event struct {
+	Type  string
+	Value string
+}
+
+func ParseEvent(str string) (Event, error) {
+	log.WithField("str", str).Traceln("--> server.ParseEvent")
+	colonIdx := strings.Index(str, ":")
+	if colonIdx < 0 {
+		err := errors.New("unknown event format")
+		log.WithError(err).WithField("str", str).Errorln("Failed to parse event.")
+		return Event{}, err
+	}
+	return Event{Type: str[:colonIdx], Value: str[colonIdx+1:]}, nil
+}
+
+func (event Event) String() string {
+	return fmt.Sprintf("%s:%s\n", event.Type, event.Value)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// The user's own environment file below the home directory.
+const userEnvironmentFile = ".gosh/environment"
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// These variables describe the user and cannot be overridden by environment files or the client.
+var identityEnvs = []string{"HOME", "USER", "LOGNAME", "SHELL"}
+
+// An environment keeps variables in the order they were first set.
+type environment struct {
+	names  []string
+	values map[string]string
+}
+
+func newEnvironment() *environment {
+	return &environment{values: map[string]string{}}
+}
+
+func (env *environment) set(name string, value string) {
+	if _, ok := env.values[name]; !ok {
+		env.names = append(env.names, name)
+	}
+	env.values[name] = value
+}
+
+// merge sets the variables of the other environment, except for the user's identity.
+func (env *environment) merge(other *environment, source string) {
+	for _, name := range other.names {
+		if contains(identityEnvs, name) {
+			log.WithFields(log.Fields{
+				"name":   name,
+				"source": source,
+			}).Warnln("Ignored environment variable describing the user.")
+			continue
+		}
+		env.set(name, other.values[name])
+	}
+}
+
+func (env *environment) list() []string {
+	list := make([]string, 0, len(env.names))
+	for _, name := range env.names {
+		list = append(list, fmt.Sprintf("%s=%s", name, env.values[name]))
+	}
+	return list
+}
+
+// loginEnvironment builds the environment of a login shell the way login(1) does. The variables derived from the
+// password entry come first, followed by the system environment file, the user's environment file if permitted and
+// the variables the client forwarded.
+func loginEnvironment(config *viper.Viper, pwd *passwd.PassWd, clientEnvs []string) []string {
+	log.WithField("user", pwd.Name).Traceln("--> server.loginEnvironment")
+	env := newEnvironment()
+	env.set("HOME", pwd.HomeDir)
+	env.set("USER", pwd.Name)
+	env.set("LOGNAME", pwd.Name)
+	env.set("SHELL", pwd.Shell)
+	if pwd.Uid == 0 {
+		env.set("PATH", config.GetString("Environment.RootPath"))
+	} else {
+		env.set("PATH", config.GetString("Environment.Path"))
+	}
+	if mailDir := config.GetString("Environment.MailDir"); mailDir != "" {
+		env.set("MAIL", path.Join(mailDir, pwd.Name))
+	}
+	if systemFile := config.GetString("Environment.SystemFile"); systemFile != "" {
+		if system, err := readEnvironmentFile(systemFile); err == nil {
+			env.merge(system, systemFile)
+		} else if !os.IsNotExist(err) {
+			log.WithError(err).WithField("file", systemFile).Warnln("Failed to read system environment file.")
+		}
+	}
+	if config.GetBool("Environment.PermitUserEnvironment") {
+		userFile := path.Join(pwd.HomeDir, userEnvironmentFile)
+		if err := checkUserFile(userFile, pwd.Uid); err == nil {
+			if user, err := readEnvironmentFile(userFile); err == nil {
+				env.merge(user, userFile)
+			} else {
+				log.WithError(err).WithField("file", userFile).Warnln("Failed to read user environment file.")
+			}
+		} else if !os.IsNotExist(err) {
+			log.WithError(err).WithField("file", userFile).Warnln("Ignored user environment file.")
+		}
+	}
+	client := newEnvironment()
+	for _, clientEnv := range clientEnvs {
+		if equalIdx := strings.Index(clientEnv, "="); equalIdx > 0 {
+			client.set(clientEnv[:equalIdx], clientEnv[equalIdx+1:])
+		}
+	}
+	env.merge(client, "client")
+	log.WithField("env", env.names).Debugln("Built login environment.")
+	return env.list()
+}
+
+// readEnvironmentFile reads NAME=VALUE lines. Empty lines, comments and an export prefix are allowed, values may be
+// quoted.
+func readEnvironmentFile(filePath string) (*environment, error) {
+	log.WithField("filePath", filePath).Traceln("--> server.readEnvironmentFile")
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	env := newEnvironment()
+	scanner := bufio.NewScanner(file)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		equalIdx := strings.Index(line, "=")
+		if equalIdx < 0 || !envNamePattern.MatchString(strings.TrimSpace(line[:equalIdx])) {
+			log.WithFields(log.Fields{
+				"filePath": filePath,
+				"lineNr":   lineNr,
+			}).Warnln("Skipped malformed environment line.")
+			continue
+		}
+		value := strings.TrimSpace(line[equalIdx+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env.set(strings.TrimSpace(line[:equalIdx]), value)
+	}
+	return env, scanner.Err()
+}
+
+// checkUserFile makes sure the file is a regular file owned by the user that nobody else can write to.
+func checkUserFile(filePath string, uid uint32) error {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", filePath)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != uid {
+		return fmt.Errorf("%s is not owned by the user", filePath)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by others", filePath)
+	}
+	return nil
+}
+
+// validEnvNames checks that the names can be accepted from clients.
+func validEnvNames(names []string) error {
+	for _, name := range names {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("%q is not a variable name", name)
+		}
+		if contains(identityEnvs, name) {
+			return fmt.Errorf("%s describes the user and cannot be accepted", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func envMap(list []string) map[string]string {
+	env := newEnvironment()
+	for _, entry := range list {
+		for i := range entry {
+			if entry[i] == '=' {
+				env.set(entry[:i], entry[i+1:])
+				break
+			}
+		}
+	}
+	return env.values
+}
+
+func TestReadEnvironmentFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "environment")
+	content := "# comment\n\nLANG=\"de_CH.UTF-8\"\nexport EDITOR='vi'\nbroken line\n1NVALID=x\nPAGER=less\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env, err := readEnvironmentFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.names) != 3 {
+		t.Errorf("Read %d variables instead of 3: %v", len(env.names), env.names)
+	}
+	if env.values["LANG"] != "de_CH.UTF-8" || env.values["EDITOR"] != "vi" || env.values["PAGER"] != "less" {
+		t.Errorf("Unexpected variables %v.", env.values)
+	}
+}
+
+func TestLoginEnvironment(t *testing.T) {
+	home := t.TempDir()
+	systemFile := path.Join(home, "system")
+	if err := ioutil.WriteFile(systemFile, []byte("LANG=C\nHOME=/elsewhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path.Join(home, ".gosh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(home, userEnvironmentFile), []byte("EDITOR=vi\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := LoadConfig("")
+	config.Set("Environment.SystemFile", systemFile)
+	pwd := &passwd.PassWd{Name: "alice", Uid: uint32(os.Getuid()), HomeDir: home, Shell: "/bin/zsh"}
+	if pwd.Uid == 0 {
+		pwd.Name = "root"
+	}
+
+	env := envMap(loginEnvironment(config, pwd, []string{"TERM=xterm", "USER=mallory"}))
+	if env["HOME"] != home || env["USER"] != pwd.Name || env["LOGNAME"] != pwd.Name || env["SHELL"] != "/bin/zsh" {
+		t.Errorf("Identity variables are wrong: %v", env)
+	}
+	if env["PATH"] == "" || env["MAIL"] != "/var/mail/"+pwd.Name {
+		t.Errorf("PATH or MAIL are wrong: %v", env)
+	}
+	if env["LANG"] != "C" || env["TERM"] != "xterm" {
+		t.Errorf("System or client variables are missing: %v", env)
+	}
+	if _, ok := env["EDITOR"]; ok {
+		t.Error("User environment file was read without being permitted.")
+	}
+
+	config.Set("Environment.PermitUserEnvironment", true)
+	if env := envMap(loginEnvironment(config, pwd, nil)); env["EDITOR"] != "vi" {
+		t.Error("Permitted user environment file was not read.")
+	}
+	if err := os.Chmod(path.Join(home, userEnvironmentFile), 0622); err != nil {
+		t.Fatal(err)
+	}
+	if env := envMap(loginEnvironment(config, pwd, nil)); env["EDITOR"] != "" {
+		t.Error("User environment file writable by others was read.")
+	}
+}
+
+func TestValidEnvNames(t *testing.T) {
+	if err := validEnvNames([]string{"TERM", "LC_ALL"}); err != nil {
+		t.Error(err)
+	}
+	if err := validEnvNames([]string{"LC_*"}); err == nil {
+		t.Error("Pattern was accepted as a variable name.")
+	}
+	if err := validEnvNames([]string{"HOME"}); err == nil {
+		t.Error("HOME was accepted from clients.")
+	}
+}
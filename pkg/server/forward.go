@@ -0,0 +1,284 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long dialing the destination of a forwarded connection may take.
+const forwardDialTimeout = 10 * time.Second
+
+// A PermitOpen rule that permits no destination at all.
+const permitOpenNone = "none"
+
+// Values of Forwarding.GatewayPorts, which decides where remote forwards listen.
+const (
+	gatewayPortsNo              = "no"              // On the loopback interface only.
+	gatewayPortsYes             = "yes"             // On all interfaces.
+	gatewayPortsClientSpecified = "clientspecified" // Wherever the client asks for.
+)
+
+// Ports below this one are reserved for root.
+const firstUnprivilegedPort = 1024
+
+// serveChannels handles the channels the client opens until the connection ends. Channels only get accepted once the
+// user logged in, until then the mux rejects all but a few of them.
+func (host *Host) serveChannels() {
+	log.Traceln("--> server.Host.serveChannels")
+	select {
+	case <-host.loggedIn:
+	case <-host.channels.Done():
+		return
+	}
+	for {
+		request, err := host.channels.Accept()
+		if err != nil {
+			log.WithError(err).Debugln("Stopped serving channels.")
+			return
+		}
+		go host.handleChannel(request)
+	}
+}
+
+func (host *Host) handleChannel(request *mux.Request) {
+	log.WithField("kind", request.Kind).Traceln("--> server.Host.handleChannel")
+	switch request.Kind {
+	case common.CHANNEL_DIRECT_TCPIP, common.CHANNEL_DIRECT_STREAMLOCAL:
+		host.forwardDirect(request)
+	case common.CHANNEL_TCPIP_FORWARD, common.CHANNEL_STREAMLOCAL_FORWARD:
+		host.listenForward(request)
+	default:
+		log.WithField("kind", request.Kind).Warnln("Client requested an unknown channel.")
+		_ = request.Reject("unknown channel kind " + request.Kind)
+	}
+}
+
+// forwardDirect dials the destination of the request, given as "host:port [originator]" or as "path [originator]" for a
+// Unix socket, and relays the channel to it.
+func (host *Host) forwardDirect(request *mux.Request) {
+	ErrorMsg := "Failed to forward connection."
+	args := strings.Fields(string(request.Data))
+	if len(args) == 0 {
+		err := errors.New("malformed forwarding request")
+		log.WithError(err).Errorln(ErrorMsg)
+		_ = request.Reject(err.Error())
+		return
+	}
+	destination, originator := args[0], ""
+	if len(args) > 1 {
+		originator = args[1]
+	}
+	fields := log.Fields{
+		"user":        host.user,
+		"destination": destination,
+		"originator":  originator,
+	}
+	streamLocal := request.Kind == common.CHANNEL_DIRECT_STREAMLOCAL
+	var err error
+	if streamLocal {
+		err = host.permitStreamLocal(destination)
+	} else {
+		err = host.permitForward(destination)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln("Refused to forward connection.")
+		_ = request.Reject(err.Error())
+		return
+	}
+	var conn net.Conn
+	if streamLocal {
+		conn, err = host.dialUnix(destination)
+	} else {
+		conn, err = net.DialTimeout(common.TCP, destination, forwardDialTimeout)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Errorln(ErrorMsg)
+		_ = request.Reject(err.Error())
+		return
+	}
+	channel, err := request.Accept()
+	if err != nil {
+		log.WithError(err).WithFields(fields).Errorln(ErrorMsg)
+		utils.CloseConn(conn)
+		return
+	}
+	log.WithFields(fields).Infoln("Forwarding connection.")
+	mux.Relay(channel, conn)
+	log.WithFields(fields).Debugln("Forwarded connection closed.")
+}
+
+// listenForward listens on the bind address or Unix socket of the request for the user until the client closes the
+// channel, and forwards the accepted connections back to the client.
+func (host *Host) listenForward(request *mux.Request) {
+	ErrorMsg := "Failed to listen for forwarded connections."
+	bind := string(request.Data)
+	fields := log.Fields{
+		"user": host.user,
+		"bind": bind,
+	}
+	streamLocal := request.Kind == common.CHANNEL_STREAMLOCAL_FORWARD
+	address := bind
+	var err error
+	if streamLocal {
+		err = host.permitStreamLocal(bind)
+	} else {
+		address, err = host.permitListen(bind)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln("Refused to listen for forwarded connections.")
+		_ = request.Reject(err.Error())
+		return
+	}
+	var listener net.Listener
+	if streamLocal {
+		listener, err = host.listenUnix(address)
+	} else {
+		listener, err = net.Listen(common.TCP, address)
+	}
+	if err != nil {
+		log.WithError(err).WithFields(fields).Errorln(ErrorMsg)
+		_ = request.Reject(err.Error())
+		return
+	}
+	channel, err := request.Accept()
+	if err != nil {
+		log.WithError(err).WithFields(fields).Errorln(ErrorMsg)
+		_ = listener.Close()
+		return
+	}
+	log.WithFields(fields).WithField("address", listener.Addr()).Infoln("Listening for forwarded connections.")
+	go func() {
+		// The client cancels the forward by closing the channel, which it also does by going away.
+		_, _ = io.Copy(ioutil.Discard, channel)
+		_ = listener.Close()
+		_ = channel.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.WithError(err).WithFields(fields).Infoln("Stopped listening for forwarded connections.")
+			return
+		}
+		go host.forwardRemote(streamLocal, bind, conn)
+	}
+}
+
+// forwardRemote hands a connection accepted for the remote forward on the bind address or Unix socket to the client.
+func (host *Host) forwardRemote(streamLocal bool, bind string, conn net.Conn) {
+	fields := log.Fields{
+		"user":       host.user,
+		"bind":       bind,
+		"originator": conn.RemoteAddr(),
+	}
+	log.WithFields(fields).Traceln("--> server.Host.forwardRemote")
+	kind, data := common.CHANNEL_FORWARDED_TCPIP, bind+" "+conn.RemoteAddr().String()
+	if streamLocal {
+		// Peers of Unix sockets have no address worth telling.
+		kind, data = common.CHANNEL_FORWARDED_STREAMLOCAL, bind
+	}
+	channel, err := host.channels.Open(kind, []byte(data))
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warnln("Client refused forwarded connection.")
+		utils.CloseConn(conn)
+		return
+	}
+	log.WithFields(fields).Infoln("Forwarding connection to client.")
+	mux.Relay(channel, conn)
+}
+
+// permitListen checks whether the user may listen on the bind address for a remote forward and returns the address to
+// listen on, which depends on Forwarding.GatewayPorts. Only root may listen on privileged ports.
+func (host *Host) permitListen(bind string) (string, error) {
+	log.WithField("bind", bind).Traceln("--> server.Host.permitListen")
+	hostName, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	allowed := host.config.GetBool("Forwarding.AllowTcpForwarding")
+	gatewayPorts := host.config.GetString("Forwarding.GatewayPorts")
+	if !allowed || host.keyOptions.noPortForwarding {
+		return "", errors.New("port forwarding is disabled")
+	}
+	if n < firstUnprivilegedPort {
+		if pwd, err := passwd.GetPwByName(host.user); err != nil || pwd.Uid != 0 {
+			return "", fmt.Errorf("only root may listen on port %d", n)
+		}
+	}
+	switch gatewayPorts {
+	case gatewayPortsYes:
+		hostName = ""
+	case gatewayPortsClientSpecified:
+	default:
+		hostName = common.LOCALHOST
+	}
+	return net.JoinHostPort(hostName, port), nil
+}
+
+// permitForward checks whether the user may open connections to the destination. Both Forwarding.PermitOpen and the
+// permitopen options of the authorized key have to permit it.
+func (host *Host) permitForward(destination string) error {
+	log.WithField("destination", destination).Traceln("--> server.Host.permitForward")
+	hostName, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return err
+	}
+	allowed := host.config.GetBool("Forwarding.AllowTcpForwarding")
+	rules := host.config.GetStringSlice("Forwarding.PermitOpen")
+	if !allowed || host.keyOptions.noPortForwarding {
+		return errors.New("port forwarding is disabled")
+	}
+	if !permitOpen(rules, hostName, port) || !permitOpen(host.keyOptions.permitOpen, hostName, port) {
+		return fmt.Errorf("forwarding to %s is not permitted", destination)
+	}
+	return nil
+}
+
+// permitOpen checks whether the rules permit connections to the host and port. Rules are given as host:port, where
+// either may be *. No rules permit everything, the rule none permits nothing.
+func permitOpen(rules []string, hostName string, port string) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		if rule == permitOpenNone {
+			return false
+		}
+		ruleHost, rulePort, err := net.SplitHostPort(rule)
+		if err != nil {
+			continue
+		}
+		if (ruleHost == "*" || strings.EqualFold(ruleHost, hostName)) && (rulePort == "*" || rulePort == port) {
+			return true
+		}
+	}
+	return false
+}
+
+func validPermitOpen(rule string) error {
+	if rule == permitOpenNone {
+		return nil
+	}
+	ruleHost, rulePort, err := net.SplitHostPort(rule)
+	if err != nil || ruleHost == "" {
+		return fmt.Errorf("invalid permitopen rule %q", rule)
+	}
+	if port, err := strconv.Atoi(rulePort); rulePort != "*" && (err != nil || port <= 0 || port > 65535) {
+		return fmt.Errorf("invalid port in permitopen rule %q", rule)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bufio"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPermitOpen(t *testing.T) {
+	rules := []string{"db.internal:5432", "*:8080", "cache:*"}
+	for _, test := range []struct {
+		host, port string
+		permitted  bool
+	}{
+		{"db.internal", "5432", true},
+		{"DB.internal", "5432", true},
+		{"db.internal", "22", false},
+		{"web", "8080", true},
+		{"cache", "6379", true},
+		{"other", "22", false},
+	} {
+		if permitOpen(rules, test.host, test.port) != test.permitted {
+			t.Errorf("Permitted %s:%s is not %t.", test.host, test.port, test.permitted)
+		}
+	}
+	if !permitOpen(nil, "anywhere", "22") {
+		t.Error("No rules did not permit everything.")
+	}
+	if permitOpen([]string{permitOpenNone}, "anywhere", "22") {
+		t.Error("Rule none permitted something.")
+	}
+}
+
+func TestValidPermitOpen(t *testing.T) {
+	for _, rule := range []string{"none", "db:5432", "*:*", "[::1]:22"} {
+		if err := validPermitOpen(rule); err != nil {
+			t.Errorf("Rule %q is invalid: %v", rule, err)
+		}
+	}
+	for _, rule := range []string{"", "db", ":22", "db:0", "db:http", "db:70000"} {
+		if err := validPermitOpen(rule); err == nil {
+			t.Errorf("Rule %q is valid.", rule)
+		}
+	}
+}
+
+// multiplexedHost stops the transfer of a host with a client that multiplexes the connection.
+func multiplexedHost(t *testing.T) (*Host, *mux.Session) {
+	host := NewHost(LoadConfig(""))
+	host.multiplexed = true
+	clientConn, serverConn := net.Pipe()
+	host.conn = serverConn
+	go func() {
+		if err := host.stopTransfer(true); err != nil {
+			t.Error(err)
+		}
+	}()
+	reader := bufio.NewReader(clientConn)
+	for _, expected := range []string{"?M:\n", "?D1:\n"} {
+		if line, err := reader.ReadString('\n'); err != nil || line != expected {
+			t.Fatalf("Got %q instead of %q: %v", line, expected, err)
+		}
+	}
+	channels := mux.Client(clientConn)
+	t.Cleanup(func() { _ = channels.Close() })
+	return &host, channels
+}
+
+func TestHost_ServeChannels(t *testing.T) {
+	listener, err := net.Listen(common.TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("hello"))
+			_ = conn.Close()
+		}
+	}()
+
+	host, channels := multiplexedHost(t)
+
+	opened := make(chan error)
+	go func() {
+		channel, err := channels.Open(common.CHANNEL_DIRECT_TCPIP, []byte(listener.Addr().String()+" 127.0.0.1:1234"))
+		if err == nil {
+			var greeting []byte
+			if greeting, err = ioutil.ReadAll(channel); string(greeting) != "hello" {
+				t.Errorf("Got %q.", greeting)
+			}
+		}
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		t.Fatalf("Opened a channel before logging in: %v", err)
+	default:
+	}
+	host.admit()
+	if err := <-opened; err != nil {
+		t.Fatal(err)
+	}
+
+	host.config.Set("Forwarding.PermitOpen", []string{"db.internal:5432"})
+	if _, err := channels.Open(common.CHANNEL_DIRECT_TCPIP, []byte(listener.Addr().String())); err == nil {
+		t.Error("Forwarded to a destination PermitOpen does not permit.")
+	}
+	host.config.Set("Forwarding.PermitOpen", []string{})
+	host.keyOptions.noPortForwarding = true
+	if _, err := channels.Open(common.CHANNEL_DIRECT_TCPIP, []byte(listener.Addr().String())); err == nil {
+		t.Error("Forwarded although the key disables forwarding.")
+	}
+	if _, err := channels.Open("unknown", nil); err == nil {
+		t.Error("Opened an unknown kind of channel.")
+	}
+}
+
+func TestHost_ServeChannels_BeforeLogin(t *testing.T) {
+	_, channels := multiplexedHost(t)
+	// Channels wait in the queue of the mux until the user logged in, the ones that do not fit get rejected.
+	rejected := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func() {
+			if _, err := channels.Open(common.CHANNEL_DIRECT_TCPIP, []byte("127.0.0.1:1")); err != nil {
+				rejected <- err
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-rejected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only %d channels got rejected before logging in.", i)
+		}
+	}
+}
+
+func TestHost_PermitListen(t *testing.T) {
+	host := NewHost(LoadConfig(""))
+	host.user = "nobody"
+	for gatewayPorts, expected := range map[string]string{
+		gatewayPortsNo:              "localhost:8080",
+		gatewayPortsYes:             ":8080",
+		gatewayPortsClientSpecified: "0.0.0.0:8080",
+	} {
+		host.config.Set("Forwarding.GatewayPorts", gatewayPorts)
+		if address, err := host.permitListen("0.0.0.0:8080"); err != nil || address != expected {
+			t.Errorf("Listens on %q instead of %q with GatewayPorts %s: %v", address, expected, gatewayPorts, err)
+		}
+	}
+	if _, err := host.permitListen("localhost:80"); err == nil {
+		t.Error("Let a user other than root listen on a privileged port.")
+	}
+	host.keyOptions.noPortForwarding = true
+	if _, err := host.permitListen("localhost:8080"); err == nil {
+		t.Error("Listened although the key disables forwarding.")
+	}
+}
+
+func TestHost_ListenForward(t *testing.T) {
+	host, channels := multiplexedHost(t)
+	host.admit()
+	// Finds a free port to listen on.
+	listener, err := net.Listen(common.TCP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind := listener.Addr().String()
+	_ = listener.Close()
+
+	forward, err := channels.Open(common.CHANNEL_TCPIP_FORWARD, []byte(bind))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		request, err := channels.Accept()
+		if err != nil {
+			return
+		}
+		if request.Kind != common.CHANNEL_FORWARDED_TCPIP || !strings.HasPrefix(string(request.Data), bind+" ") {
+			t.Errorf("Got request %s %q.", request.Kind, request.Data)
+		}
+		if channel, err := request.Accept(); err == nil {
+			_, _ = channel.Write([]byte("hello"))
+			_ = channel.Close()
+		}
+	}()
+	conn, err := net.Dial(common.TCP, bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if greeting, err := ioutil.ReadAll(conn); err != nil || string(greeting) != "hello" {
+		t.Errorf("Got %q: %v", greeting, err)
+	}
+	_ = conn.Close()
+
+	// Closing the channel cancels the forward.
+	_ = forward.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial(common.TCP, bind)
+		if err != nil {
+			break
+		}
+		_ = conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("Server still listens after the forward got cancelled.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -10,11 +10,15 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/accounting"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/connection"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/mux"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/pty"
 	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"golang.org/x/sys/unix"
+	"io"
 	"net"
 	"net/url"
 	"os"
@@ -22,13 +26,17 @@ import (
 	"path"
 	"strings"
 	"syscall"
+	"time"
 )
 
+const loginPollInterval = 500 * time.Millisecond
+
 type Host struct {
 	config      *viper.Viper
 	certificate *tls.Certificate
 	conn        net.Conn
-	rAddr       *net.TCPAddr
+	rAddr       net.Addr
+	peerCred    *unix.Ucred
 	userEnvs    []string
 	userName    string
 	userPw      string
@@ -38,11 +46,48 @@ type Host struct {
 	ptm         *os.File
 	pts         *os.File
 	shell       *exec.Cmd
+	exited      chan struct{}
+	accounting  accounting.Accounting
+	accounted   *accounting.Entry
+	user        string
+	resumable   *resumableSession
+	resumeToken string
+	attachId    string
+	share       string
+	control     io.ReadWriter
+	verdicts    *bufio.Reader
+	multiplexed bool          // The client can multiplex channels over the connection.
+	channels    *mux.Session  // The channels multiplexed over the connection once the transfer is done.
+	loggedIn    chan struct{} // Closed once the user is authorized.
+	keyOptions  keyOptions
+	subsystem   string // The subsystem to run instead of a shell.
 }
 
 func NewHost(config *viper.Viper) Host {
 	log.WithField("config", config).Traceln("--> host.NewHost")
-	return Host{config: config}
+	return Host{
+		config:   config,
+		exited:   make(chan struct{}),
+		loggedIn: make(chan struct{}),
+		accounting: accounting.NewFiles(
+			config.GetString("Accounting.Utmp"),
+			config.GetString("Accounting.Wtmp"),
+			config.GetString("Accounting.Lastlog")),
+	}
+}
+
+// SetControl sets the control channel to the goshd process over which authentication results get reported and
+// session verdicts are received.
+func (host *Host) SetControl(control io.ReadWriter) {
+	log.WithField("control", control).Traceln("--> host.Host.SetControl")
+	host.control = control
+	host.verdicts = bufio.NewReader(control)
+}
+
+// SetPeerCredentials sets the credentials of a client connected over a Unix socket.
+func (host *Host) SetPeerCredentials(cred *unix.Ucred) {
+	log.WithField("cred", cred).Traceln("--> host.Host.SetPeerCredentials")
+	host.peerCred = cred
 }
 
 func (host *Host) LoadCertKeyPair(certPath string, keyFilePath string) error {
@@ -62,7 +107,7 @@ func (host *Host) LoadCertKeyPair(certPath string, keyFilePath string) error {
 	return nil
 }
 
-func (host *Host) Connect(socketFd uintptr, rAddr *net.TCPAddr) error {
+func (host *Host) Connect(socketFd uintptr, rAddr net.Addr) error {
 	log.WithFields(log.Fields{
 		"socketFd": socketFd,
 		"rAddr":    rAddr.String(),
@@ -86,8 +131,12 @@ func (host *Host) Connect(socketFd uintptr, rAddr *net.TCPAddr) error {
 func (host *Host) Setup() error {
 	log.Traceln("--> host.Host.Setup")
 	ErrorMsg := "Failed to setup host."
+	if err := host.sendBanner(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
 	// Get the necessary information from the rAddr client
-	err := host.getClientEnvs("TERM")
+	err := host.getClientEnvs(host.config.GetStringSlice("Environment.AcceptEnv")...)
 	if err != nil {
 		log.WithError(err).Errorln(ErrorMsg)
 		return err
@@ -113,7 +162,10 @@ func (host *Host) Setup() error {
 			} else {
 				if host.rHostname == "" {
 					log.Warnln("Client NAME was empty. Using IP address instead.")
-					host.rHostname = strings.Split(host.rAddr.String(), ":")[0]
+					host.rHostname = common.LOCALHOST
+					if ip := peerIP(host.rAddr); ip != nil {
+						host.rHostname = ip.String()
+					}
 				}
 				log.WithField("rHostname", host.rHostname).Debugln("Got remote host name.")
 			}
@@ -135,8 +187,46 @@ func (host *Host) Setup() error {
 		log.WithField("userPw", host.userPw).Debugln("Got local user password.")
 	}
 
+	if host.config.GetBool("Sessions.Resumable") {
+		host.resumeToken, err = host.requestClientEnv(common.ENV_GOSH_SESSION)
+		if err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			return err
+		}
+		host.attachId, err = host.requestClientEnv(common.ENV_GOSH_ATTACH)
+		if err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			return err
+		}
+		host.share, err = host.requestClientEnv(common.ENV_GOSH_SHARE)
+		if err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			return err
+		}
+	}
+	host.subsystem, err = host.requestClientEnv(common.ENV_GOSH_SUBSYSTEM)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	multiplex, err := host.requestClientEnv(common.ENV_GOSH_MUX)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	// A subsystem has the connection to itself.
+	host.multiplexed = multiplex == "1" && host.subsystem == ""
+
 	// Done gathering all the information.
 	log.Infoln("Got all the information from the client.")
+	if host.subsystem != "" {
+		log.WithField("subsystem", host.subsystem).Infoln("Set up host to run a subsystem.")
+		return nil
+	}
+	if host.resumeToken != "" || host.attachId != "" || host.share != "" {
+		log.Infoln("Set up host to join a session.")
+		return nil
+	}
 
 	host.ptm, host.pts, err = pty.Create()
 	if err != nil {
@@ -149,10 +239,31 @@ func (host *Host) Setup() error {
 
 func (host *Host) Serve() error {
 	log.Traceln("--> host.Host.Serve")
+	if host.subsystem != "" {
+		defer utils.CloseConn(host.conn)
+		return host.serveSubsystem()
+	}
+	if host.resumeToken != "" {
+		defer utils.CloseConn(host.conn)
+		return host.resume()
+	}
+	if host.attachId != "" {
+		defer utils.CloseConn(host.conn)
+		return host.attach()
+	}
+	if host.share != "" {
+		defer utils.CloseConn(host.conn)
+		return host.shareSession()
+	}
 	defer func() {
 		utils.CloseFile(host.pts)
 		utils.CloseFile(host.ptm)
-		utils.CloseConn(host.conn)
+		if host.resumable != nil {
+			// The session closes the connection of whichever client is attached.
+			host.resumable.Close()
+		} else {
+			utils.CloseConn(host.conn)
+		}
 	}()
 	cmd, err := host.StartShell()
 	if err != nil {
@@ -160,14 +271,14 @@ func (host *Host) Serve() error {
 		return err
 	}
 
-	// TODO: Handle forwarding yourself.
-	go utils.Forward(host.ptm, host.conn, "ptm", "client")
-	go utils.Forward(host.conn, host.ptm, "client", "ptm")
+	host.relay()
 
 	//rFdSet := unix.FdSet{}
 	//n, err := unix.Pselect(3, &rFdSet, &rFdSet, &rFdSet, nil, nil)
 
 	status, err := cmd.Process.Wait()
+	close(host.exited)
+	host.accountLogout()
 	if err != nil {
 		log.WithError(err).Errorln("Failed waiting for login.")
 		return err
@@ -177,12 +288,34 @@ func (host *Host) Serve() error {
 	return nil
 }
 
+// relay forwards between the pty and the client, through the resumable session if there is one.
+func (host *Host) relay() {
+	log.Traceln("--> host.Host.relay")
+	if host.resumable != nil {
+		go utils.Forward(host.ptm, host.resumable, "ptm", "session")
+		host.resumable.Attach(host.conn, 0)
+	} else {
+		// TODO: Handle forwarding yourself.
+		go utils.Forward(host.ptm, host.conn, "ptm", "client")
+		go utils.Forward(host.conn, host.ptm, "client", "ptm")
+	}
+}
+
 func (host *Host) StartShell() (cmd *exec.Cmd, err error) {
 	log.Traceln("--> host.Host.StartShell")
 	ErrorMsg := "Failed to start shell."
-	if host.userName != "" {
+	if pwd := host.peerCredentialUser(); pwd != nil {
+		if err = host.authorize(pwd.Name); err != nil {
+			host.reject(err.Error())
+		} else if err = host.stopTransfer(true); err == nil {
+			cmd, err = host.spawnShell(pwd)
+		}
+	} else if host.userName != "" {
 		err = host.authenticateWithKeys(host.userName)
 		if err != nil {
+			if !os.IsNotExist(err) {
+				host.report(Event{Type: EventAuthFailed, Value: host.userName})
+			}
 			log.WithError(err).Infoln("Failed to log in user with keys. Proceed to login command.")
 			err = host.stopTransfer(true)
 			if err == nil {
@@ -193,11 +326,11 @@ func (host *Host) StartShell() (cmd *exec.Cmd, err error) {
 			pwd, err = passwd.GetPwByName(host.userName)
 			if err != nil {
 				log.WithError(err).Errorln("Failed to log in with keys.")
+			} else if err = host.authorize(pwd.Name); err != nil {
+				host.reject(err.Error())
 			} else {
 				err = host.stopTransfer(true)
 				if err == nil {
-					//TODO: Make entry in utmx
-					//TODO: Display MOTD
 					cmd, err = host.spawnShell(pwd)
 				}
 			}
@@ -224,6 +357,9 @@ func (host *Host) getClientEnvs(envs ...string) error {
 		if err != nil {
 			return err
 		}
+		if value == "" {
+			continue
+		}
 		host.userEnvs = append(host.userEnvs, fmt.Sprintf("%s=%s", env, value))
 	}
 	log.WithField("host.userEnvs", host.userEnvs).Debugln("Done gathering environment variables.")
@@ -249,18 +385,63 @@ func (host Host) requestClientEnv(env string) (string, error) {
 	return value, nil
 }
 
-func (host Host) stopTransfer(success bool) error {
+func (host *Host) stopTransfer(success bool) error {
 	log.Traceln("--> host.Host.stopTransfer")
+	if success && host.resumeToken == "" && host.attachId == "" && host.share == "" && host.subsystem == "" &&
+		host.config.GetBool("Sessions.Resumable") {
+		host.offerSession()
+	}
+	if success && host.multiplexed {
+		if _, err := fmt.Fprint(host.conn, connection.MuxPacket{}.String()); err != nil {
+			log.WithError(err).Errorln("Failed to send MuxPacket.")
+			return err
+		}
+	}
 	_, err := fmt.Fprint(host.conn, connection.DonePacket{Success: success}.String())
 	if err != nil {
 		log.WithError(err).Errorln("Failed to send DonePacket.")
 		return err
 	}
 	log.Debugln("Sent done packet.")
+	if success && host.multiplexed {
+		// From now on, the terminal session is one channel among the forwarded connections.
+		host.channels = mux.Server(host.conn)
+		host.conn = host.channels.Stream()
+		go host.serveChannels()
+	}
 	return nil
 }
 
-func (host Host) authenticateWithKeys(user string) error {
+// peerCredentialUser returns the user a client connected over a Unix socket may log in as without further
+// authentication, or nil. That is the client's own user, or any user if the client runs as root and
+// Authentication.PeerCredentialsRoot allows it.
+func (host Host) peerCredentialUser() *passwd.PassWd {
+	log.WithField("peerCred", host.peerCred).Traceln("--> server.Host.peerCredentialUser")
+	if host.peerCred == nil || !host.config.GetBool("Authentication.PeerCredentials") {
+		return nil
+	}
+	peer, err := passwd.GetPwByUid(host.peerCred.Uid)
+	if err != nil {
+		log.WithError(err).WithField("uid", host.peerCred.Uid).Warnln("Failed to look up peer user.")
+		return nil
+	}
+	if host.userName == "" || host.userName == peer.Name {
+		log.WithField("userName", peer.Name).Infoln("Client authenticated itself using peer credentials.")
+		return peer
+	}
+	if host.peerCred.Uid != 0 || !host.config.GetBool("Authentication.PeerCredentialsRoot") {
+		return nil
+	}
+	pwd, err := passwd.GetPwByName(host.userName)
+	if err != nil {
+		log.WithError(err).WithField("userName", host.userName).Warnln("Failed to look up user.")
+		return nil
+	}
+	log.WithField("userName", pwd.Name).Infoln("Root client authenticated itself using peer credentials.")
+	return pwd
+}
+
+func (host *Host) authenticateWithKeys(user string) error {
 	log.WithField("user", user).Traceln("--> server.Host.authenticateWithKeys")
 	ErrorMsg := "Failed login with key."
 	filename := url.PathEscape(host.rUser) + ".pub"
@@ -305,39 +486,109 @@ func (host Host) authenticateWithKeys(user string) error {
 		return err
 	}
 	if nAnswer != nSecret || !bytes.Equal(secret[:nSecret], answer[:nAnswer]) {
-		log.WithError(err).Errorln("The answer does not match the secret.")
+		err = errors.New("answer does not match the secret")
+		log.WithError(err).Errorln(ErrorMsg)
 		return err
 	} else {
 		log.Infoln("Client authenticated itself using keys.")
 
 	}
+	host.keyOptions, err = keyOptionsFromFile(keyStorePath)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
 	return nil
 }
 
 func (host *Host) spawnShell(pwd *passwd.PassWd) (*exec.Cmd, error) {
 	log.Traceln("--> server.Host.spawnShell")
 	shell := exec.Command(pwd.Shell, "--login")
+	groups, err := passwd.GetGroupList(pwd.Name, pwd.Gid)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to look up groups.")
+		return shell, err
+	}
+	gids := make([]uint32, 0, len(groups))
+	groupNames := make([]string, 0, len(groups))
+	for _, group := range groups {
+		gids = append(gids, group.Gid)
+		groupNames = append(groupNames, group.Name)
+	}
+	// The shell leads a new session with the pts as controlling terminal, so that job control and ^C work. Ctty
+	// refers to a descriptor in the child, which is its stdin.
 	shell.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true,
-		//Setctty: true, //TODO: Fix error when using ctty
+		Setsid:  true,
+		Setctty: true,
+		Ctty:    0,
 		Credential: &syscall.Credential{
-			Uid: pwd.Uid,
-			Gid: pwd.Gid,
+			Uid:    pwd.Uid,
+			Gid:    pwd.Gid,
+			Groups: gids,
 		},
 	}
 	shell.Dir = pwd.HomeDir
-	shell.Env = host.userEnvs
+	shell.Env = loginEnvironment(host.config, pwd, host.userEnvs)
+	if host.resumable != nil {
+		// Tells the user which session to invite others to.
+		shell.Env = append(shell.Env, common.ENV_GOSH_SESSION_ID+"="+host.resumable.Id)
+	}
 	shell.Stdin = host.pts
 	shell.Stdout = host.pts
 	shell.Stderr = host.pts
-	err := shell.Start()
+	if err = limitCommand(shell, resourceLimits(host.config, pwd.Name, groupNames)); err != nil {
+		log.WithError(err).Errorln("Failed to apply resource limits.")
+		return shell, err
+	}
+	err = shell.Start()
 	if err != nil {
 		log.WithError(err).Errorln("Failed to fork shell.")
-	} else {
-		host.shell = shell
-		log.WithField("shell", shell).Debugln("Forked shell.")
+		return shell, err
 	}
-	return shell, err
+	host.shell = shell
+	log.WithField("shell", shell).Debugln("Forked shell.")
+	host.greet(pwd, host.accountLogin(pwd, shell.Process.Pid))
+	return shell, nil
+}
+
+// accountLogin records the session of a shell spawned by goshh and returns the previous login of the user. The login
+// command does its own accounting.
+func (host *Host) accountLogin(pwd *passwd.PassWd, pid int) *accounting.LastLogin {
+	log.WithFields(log.Fields{
+		"user": pwd.Name,
+		"pid":  pid,
+	}).Traceln("--> server.Host.accountLogin")
+	entry := accounting.Entry{
+		Pid:  pid,
+		Line: strings.TrimPrefix(host.pts.Name(), "/dev/"),
+		User: pwd.Name,
+		Uid:  pwd.Uid,
+		Host: host.rHostname,
+		Addr: peerIP(host.rAddr),
+		Time: time.Now(),
+	}
+	if entry.Addr != nil {
+		entry.Host = entry.Addr.String()
+	}
+	lastLogin, err := host.accounting.Login(entry)
+	if err != nil {
+		log.WithError(err).Warnln("Session is missing from the accounting.")
+		return nil
+	}
+	host.accounted = &entry
+	return lastLogin
+}
+
+func (host *Host) accountLogout() {
+	log.Traceln("--> server.Host.accountLogout")
+	if host.accounted == nil {
+		return
+	}
+	host.accounted.Time = time.Now()
+	if err := host.accounting.Logout(*host.accounted); err != nil {
+		log.WithError(err).Warnln("Session end is missing from the accounting.")
+	}
+	host.accounted = nil
 }
 
 func (host *Host) login() (*exec.Cmd, error) {
@@ -350,6 +601,7 @@ func (host *Host) login() (*exec.Cmd, error) {
 	login.SysProcAttr = &syscall.SysProcAttr{
 		Setsid:  true,
 		Setctty: true,
+		Ctty:    0,
 	}
 	err := login.Start()
 	if err != nil {
@@ -358,6 +610,9 @@ func (host *Host) login() (*exec.Cmd, error) {
 		host.shell = login
 		log.WithField("login", login).Debugln("Forked login.")
 	}
+	if err != nil {
+		return login, err
+	}
 	if host.userName != "" {
 		if err := host.answerPtyLoginRequest(login.Process.Pid); err != nil {
 			return login, err
@@ -368,9 +623,187 @@ func (host *Host) login() (*exec.Cmd, error) {
 			}
 		}
 	}
+	go host.awaitLogin(login)
 	return login, err
 }
 
+// awaitLogin watches the terminal until login hands it over to the user's shell, which counts as a successful
+// authentication. If that does not happen within Authentication.LoginGraceTime seconds, login gets killed.
+func (host *Host) awaitLogin(login *exec.Cmd) {
+	log.WithField("pid", login.Process.Pid).Traceln("--> server.Host.awaitLogin")
+	loginExe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", login.Process.Pid))
+	if err != nil {
+		log.WithError(err).Warnln("Failed to resolve login executable. Cannot tell when login succeeds.")
+		return
+	}
+	ticker := time.NewTicker(loginPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(time.Duration(host.config.GetInt("Authentication.LoginGraceTime")) * time.Second)
+	for {
+		select {
+		case <-host.exited:
+			log.Infoln("Login exited before handing over to a shell.")
+			host.report(Event{Type: EventAuthFailed, Value: host.userName})
+			return
+		case <-deadline:
+			log.Warnln("Login grace time exceeded.")
+			host.report(Event{Type: EventAuthFailed, Value: host.userName})
+			if err := host.Kill(); err != nil {
+				log.WithError(err).Warnln("Failed to kill login.")
+			}
+			return
+		case <-ticker.C:
+			pgrp, err := unix.IoctlGetInt(int(host.ptm.Fd()), unix.TIOCGPGRP)
+			if err != nil || pgrp <= 0 {
+				continue
+			}
+			exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pgrp))
+			if err != nil || exe == loginExe {
+				continue
+			}
+			userName := host.userName
+			stat := unix.Stat_t{}
+			if err := unix.Stat(fmt.Sprintf("/proc/%d", pgrp), &stat); err == nil {
+				if pwd, err := passwd.GetPwByUid(stat.Uid); err == nil {
+					userName = pwd.Name
+				}
+			}
+			log.WithFields(log.Fields{
+				"pgrp":     pgrp,
+				"exe":      exe,
+				"userName": userName,
+			}).Infoln("Login handed the terminal over to a shell.")
+			if err := host.authorize(userName); err != nil {
+				host.notice(err.Error())
+				if err := host.Kill(); err != nil {
+					log.WithError(err).Warnln("Failed to kill shell.")
+				}
+			}
+			return
+		}
+	}
+}
+
+func (host Host) report(event Event) {
+	log.WithField("event", event).Traceln("--> server.Host.report")
+	if host.control == nil {
+		return
+	}
+	if _, err := fmt.Fprint(host.control, event.String()); err != nil {
+		log.WithError(err).WithField("event", event).Warnln("Failed to report event.")
+	}
+}
+
+// authorize reports the successful authentication of the user to goshd and waits for its verdict on whether the
+// user may have another session.
+func (host *Host) authorize(userName string) error {
+	log.WithField("userName", userName).Traceln("--> server.Host.authorize")
+	host.report(Event{Type: EventAuthSucceeded, Value: userName})
+	if host.control != nil {
+		str, err := host.verdicts.ReadString('\n')
+		if err != nil {
+			log.WithError(err).Errorln("Failed to read verdict from goshd.")
+			return err
+		}
+		verdict, err := ParseEvent(strings.TrimSpace(str))
+		if err != nil {
+			return err
+		}
+		if verdict.Type != EventAccepted {
+			err := errors.New(verdict.Value)
+			log.WithError(err).WithField("userName", userName).Warnln("Session got rejected by goshd.")
+			return err
+		}
+	}
+	host.user = userName
+	if host.resumable != nil {
+		host.resumable.SetUser(userName)
+	}
+	host.admit()
+	return nil
+}
+
+// admit lets the user open channels.
+func (host *Host) admit() {
+	select {
+	case <-host.loggedIn:
+	default:
+		close(host.loggedIn)
+	}
+}
+
+// offerSession makes the session resumable and hands the client the token to resume it. Sessions of login(1) get the
+// token before the user logged in, as there are no more packets after the transfer. The token is of no use until then,
+// since sessions refuse to be resumed until authorize sets their user.
+func (host *Host) offerSession() {
+	log.Traceln("--> host.Host.offerSession")
+	session, err := newResumableSession(
+		host.config.GetString("Sessions.Dir"),
+		host.config.GetInt("Sessions.BufferSize"),
+		time.Duration(host.config.GetInt("Sessions.Timeout"))*time.Second,
+		host.ptm,
+		func() {
+			if err := host.Kill(); err != nil {
+				log.WithError(err).Warnln("Failed to kill the shell of the expired session.")
+			}
+		})
+	if err != nil {
+		log.WithError(err).Warnln("Session cannot be resumed.")
+		return
+	}
+	session.SetUser(host.user)
+	session.SetAuditFile(host.config.GetString("Sessions.AuditFile"))
+	if _, err := fmt.Fprint(host.conn, connection.SessionPacket{Token: session.Token()}.String()); err != nil {
+		log.WithError(err).Errorln("Failed to send session packet.")
+		session.Close()
+		return
+	}
+	host.resumable = session
+}
+
+func (host Host) reject(reason string) {
+	log.WithField("reason", reason).Traceln("--> host.Host.reject")
+	if _, err := fmt.Fprint(host.conn, connection.RejectPacket{Reason: reason}.String()); err != nil {
+		log.WithError(err).Errorln("Failed to send RejectPacket.")
+	}
+}
+
+// Shutdown tells the client that the server is going down and the session will be closed after the grace period.
+// It returns false if there is no session to wrap up yet.
+func (host *Host) Shutdown(grace time.Duration) bool {
+	log.WithField("grace", grace.String()).Traceln("--> host.Host.Shutdown")
+	if host.shell == nil {
+		return false
+	}
+	host.notice(fmt.Sprintf("Server is shutting down. This session will be closed in %s.", grace))
+	return true
+}
+
+// terminal returns where output for the client's terminal goes. Output of a resumable session has to go through the
+// session, so that it can be replayed.
+func (host Host) terminal() io.Writer {
+	if host.resumable != nil {
+		return host.resumable
+	}
+	return host.conn
+}
+
+// writeLine writes a line to the client's terminal, which is in raw mode once the transfer stopped.
+func (host Host) writeLine(line string) {
+	log.WithField("line", line).Traceln("--> host.Host.writeLine")
+	if _, err := fmt.Fprint(host.terminal(), line+"\r\n"); err != nil {
+		log.WithError(err).Warnln("Failed to write to client.")
+	}
+}
+
+// notice writes a message straight to the client's terminal.
+func (host Host) notice(msg string) {
+	log.WithField("msg", msg).Traceln("--> host.Host.notice")
+	if _, err := fmt.Fprintf(host.terminal(), "\r\ngosh: %s\r\n", msg); err != nil {
+		log.WithError(err).Warnln("Failed to write notice to client.")
+	}
+}
+
 func (host *Host) answerPtyLoginRequest(pid int) error {
 	log.WithField("pid", pid).Traceln("--> server.Host.answerPtyLoginRequest")
 	str, err := bufio.NewReader(host.ptm).ReadString(':')
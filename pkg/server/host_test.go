@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bufio"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/accounting"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/pty"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The shell stands in for a login shell. It reports the SIGINT it gets from a ^C the client sends over the session.
+const trapScript = `#!/bin/sh
+trap 'echo got-sigint; exit 0' INT
+echo ready
+while :; do sleep 1; done
+`
+
+func TestHost_SpawnShell_Interrupt(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Spawning a shell for a user needs root.")
+	}
+	shellPath := path.Join(t.TempDir(), "shell")
+	if err := os.WriteFile(shellPath, []byte(trapScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	host := NewHost(LoadConfig(""))
+	host.accounting = accounting.NewFiles("", "", "")
+	host.config.Set("Login.PrintMotd", false)
+	var err error
+	host.ptm, host.pts, err = pty.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.ptm.Close()
+	defer host.pts.Close()
+	conn, serverConn := net.Pipe()
+	defer conn.Close()
+	defer serverConn.Close()
+	host.conn = serverConn
+	shell, err := host.spawnShell(&passwd.PassWd{Name: "root", HomeDir: "/", Shell: shellPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = shell.Process.Kill() }()
+	host.relay()
+
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+	await := func(expected string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("Connection closed before %q.", expected)
+				}
+				// The terminal echoes the ^C in front of the trap's output.
+				if strings.HasSuffix(line, expected) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for %q.", expected)
+			}
+		}
+	}
+	await("ready")
+	if _, err := conn.Write([]byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+	await("got-sigint")
+	if status, err := shell.Process.Wait(); err != nil || !status.Success() {
+		t.Error("Shell did not exit through its trap.", status, err)
+	}
+}
+
+func TestHost_AccountLogin(t *testing.T) {
+	dir := t.TempDir()
+	host := NewHost(LoadConfig(""))
+	host.accounting = accounting.NewFiles(path.Join(dir, "utmp"), path.Join(dir, "wtmp"), path.Join(dir, "lastlog"))
+	host.rAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222}
+	var err error
+	host.ptm, host.pts, err = pty.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.ptm.Close()
+	defer host.pts.Close()
+	pwd := &passwd.PassWd{Name: "alice", Uid: 1000}
+
+	if lastLogin := host.accountLogin(pwd, 4242); lastLogin != nil || host.accounted == nil {
+		t.Fatal("First login was not accounted.")
+	}
+	host.accountLogout()
+	if host.accounted != nil {
+		t.Error("Logout was not accounted.")
+	}
+	lastLogin := host.accountLogin(pwd, 4243)
+	if lastLogin == nil || lastLogin.Host != "192.0.2.1" || lastLogin.Line != strings.TrimPrefix(host.pts.Name(), "/dev/") {
+		t.Errorf("Unexpected previous login %+v.", lastLogin)
+	}
+}
+
+func TestHost_Authorize_Resumable(t *testing.T) {
+	host := NewHost(LoadConfig(""))
+	session, err := newResumableSession(t.TempDir(), 64, time.Minute, &lockedBuffer{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	host.resumable = session
+	// Without goshd there is no verdict to wait for, the session still gets its user.
+	if err := host.authorize("alice"); err != nil {
+		t.Fatal(err)
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if host.user != "alice" || session.User != "alice" {
+		t.Errorf("Authorized %q for a session of %q.", host.user, session.User)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"strings"
+)
+
+// keyOptions restrict what a client authenticated with an authorized key may do. They are given as the comma
+// separated Options header of the key's PEM block, e.g. "Options: no-port-forwarding" or
+// "Options: permitopen=db.internal:5432,permitopen=*:8080".
+type keyOptions struct {
+	noPortForwarding bool
+	permitOpen       []string
+}
+
+func parseKeyOptions(str string) (keyOptions, error) {
+	log.WithField("str", str).Traceln("--> server.parseKeyOptions")
+	options := keyOptions{}
+	for _, option := range strings.Split(str, ",") {
+		option = strings.TrimSpace(option)
+		name, value := option, ""
+		if eqIdx := strings.Index(option, "="); eqIdx >= 0 {
+			name, value = option[:eqIdx], strings.Trim(option[eqIdx+1:], `"`)
+		}
+		switch strings.ToLower(name) {
+		case "":
+		case "no-port-forwarding":
+			options.noPortForwarding = true
+		case "permitopen":
+			if err := validPermitOpen(value); err != nil {
+				return keyOptions{}, err
+			}
+			options.permitOpen = append(options.permitOpen, value)
+		default:
+			return keyOptions{}, fmt.Errorf("unknown key option %q", name)
+		}
+	}
+	return options, nil
+}
+
+// keyOptionsFromFile reads the options of the authorized key at the path.
+func keyOptionsFromFile(path string) (keyOptions, error) {
+	log.WithField("path", path).Traceln("--> server.keyOptionsFromFile")
+	block, err := utils.BlockFromFile(path)
+	if err != nil {
+		return keyOptions{}, err
+	}
+	return parseKeyOptions(block.Headers["Options"])
+}
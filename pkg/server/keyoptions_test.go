@@ -0,0 +1,37 @@
+package server
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyOptions(t *testing.T) {
+	options, err := parseKeyOptions(`no-port-forwarding, permitopen="db:5432",permitopen=*:8080`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !options.noPortForwarding || !reflect.DeepEqual(options.permitOpen, []string{"db:5432", "*:8080"}) {
+		t.Errorf("Got %+v.", options)
+	}
+	if options, err := parseKeyOptions(""); err != nil || options.noPortForwarding || options.permitOpen != nil {
+		t.Errorf("Got %+v without options: %v", options, err)
+	}
+	for _, str := range []string{"no-such-option", "permitopen=db"} {
+		if _, err := parseKeyOptions(str); err == nil {
+			t.Errorf("Parsed %q.", str)
+		}
+	}
+}
+
+func TestKeyOptionsFromFile(t *testing.T) {
+	keyPath := path.Join(t.TempDir(), "user.pub")
+	key := "-----BEGIN PUBLIC KEY-----\nOptions: no-port-forwarding\n\nAAAA\n-----END PUBLIC KEY-----\n"
+	if err := os.WriteFile(keyPath, []byte(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if options, err := keyOptionsFromFile(keyPath); err != nil || !options.noPortForwarding {
+		t.Errorf("Got %+v: %v", options, err)
+	}
+}
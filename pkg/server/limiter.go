@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// The Limiter decides whether a freshly accepted connection may be handed to a goshh process. It keeps track of the
+// connection rate per source address and per subnet, the number of connections that have not authenticated yet and
+// the authentication failures per source address, which lead to temporary bans. Connections without an IP address,
+// like the ones over a Unix socket, are not limited.
+type Limiter struct {
+	config          *viper.Viper
+	mutex           sync.Mutex
+	hosts           map[string][]time.Time
+	subnets         map[string][]time.Time
+	failures        map[string][]time.Time
+	bans            map[string]time.Time
+	unauthenticated int
+	swept           time.Time // When sweep last dropped the addresses without recent entries.
+	now             func() time.Time
+}
+
+func NewLimiter(config *viper.Viper) *Limiter {
+	log.WithField("config", config).Traceln("--> server.NewLimiter")
+	limiter := &Limiter{
+		config:   config,
+		hosts:    map[string][]time.Time{},
+		subnets:  map[string][]time.Time{},
+		failures: map[string][]time.Time{},
+		bans:     map[string]time.Time{},
+		now:      time.Now,
+	}
+	if err := limiter.loadBans(); err != nil {
+		log.WithError(err).Warnln("Failed to load ban list. Starting with an empty one.")
+	}
+	return limiter
+}
+
+// Admit checks whether a new connection from the given address is allowed and, if so, counts it as an
+// unauthenticated connection until either Authenticated or Release is called for it.
+func (limiter *Limiter) Admit(ip net.IP) error {
+	log.WithField("ip", ip.String()).Traceln("--> server.Limiter.Admit")
+	if ip == nil {
+		return nil
+	}
+	configLock.RLock()
+	defer configLock.RUnlock()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := limiter.now()
+	window := limiter.seconds("Limits.RateWindow")
+	if now.Sub(limiter.swept) >= window {
+		limiter.sweep(now)
+	}
+	host := hostKey(ip)
+	if until, ok := limiter.bans[host]; ok {
+		if now.Before(until) {
+			return fmt.Errorf("%s is banned until %s", host, until.Format(time.RFC3339))
+		}
+		delete(limiter.bans, host)
+		limiter.saveBans()
+	}
+	hostConns := prune(limiter.hosts[host], now.Add(-window))
+	if max := limiter.config.GetInt("Limits.MaxConnectionsPerHost"); max > 0 && len(hostConns) >= max {
+		limiter.hosts[host] = hostConns
+		return fmt.Errorf("%s exceeded %d connections per %s", host, max, window)
+	}
+	subnet := limiter.subnetKey(ip)
+	subnetConns := prune(limiter.subnets[subnet], now.Add(-window))
+	if max := limiter.config.GetInt("Limits.MaxConnectionsPerSubnet"); max > 0 && len(subnetConns) >= max {
+		limiter.subnets[subnet] = subnetConns
+		return fmt.Errorf("%s exceeded %d connections per %s", subnet, max, window)
+	}
+	if max := limiter.config.GetInt("Limits.MaxUnauthenticated"); max > 0 && limiter.unauthenticated >= max {
+		return fmt.Errorf("already %d unauthenticated connections", limiter.unauthenticated)
+	}
+	limiter.hosts[host] = append(hostConns, now)
+	limiter.subnets[subnet] = append(subnetConns, now)
+	limiter.unauthenticated++
+	log.WithFields(log.Fields{
+		"host":            host,
+		"subnet":          subnet,
+		"unauthenticated": limiter.unauthenticated,
+	}).Debugln("Admitted connection.")
+	return nil
+}
+
+// Authenticated marks a previously admitted connection as authenticated.
+func (limiter *Limiter) Authenticated(ip net.IP) {
+	log.WithField("ip", ip.String()).Traceln("--> server.Limiter.Authenticated")
+	if ip == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.unauthenticated > 0 {
+		limiter.unauthenticated--
+	}
+}
+
+// Release forgets about a previously admitted connection once it is gone.
+func (limiter *Limiter) Release(ip net.IP, authenticated bool) {
+	log.WithFields(log.Fields{
+		"ip":            ip.String(),
+		"authenticated": authenticated,
+	}).Traceln("--> server.Limiter.Release")
+	if !authenticated {
+		limiter.Authenticated(ip)
+	}
+}
+
+// Failed records an authentication failure and bans the address once it failed too often.
+func (limiter *Limiter) Failed(ip net.IP) {
+	log.WithField("ip", ip.String()).Traceln("--> server.Limiter.Failed")
+	if ip == nil {
+		return
+	}
+	configLock.RLock()
+	defer configLock.RUnlock()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := limiter.now()
+	host := hostKey(ip)
+	failures := append(prune(limiter.failures[host], now.Add(-limiter.seconds("Limits.FailureWindow"))), now)
+	limiter.failures[host] = failures
+	max := limiter.config.GetInt("Limits.BanAfterFailures")
+	if max <= 0 || len(failures) < max {
+		log.WithFields(log.Fields{
+			"host":     host,
+			"failures": len(failures),
+		}).Infoln("Recorded authentication failure.")
+		return
+	}
+	until := now.Add(limiter.seconds("Limits.BanTime"))
+	limiter.bans[host] = until
+	delete(limiter.failures, host)
+	log.WithFields(log.Fields{
+		"host":     host,
+		"failures": len(failures),
+		"until":    until.Format(time.RFC3339),
+	}).Warnln("Banned host after repeated authentication failures.")
+	limiter.saveBans()
+}
+
+// sweep drops the addresses and subnets without connections or failures in their windows and the expired bans, which
+// would otherwise pile up with clients changing their addresses. The caller has to hold the mutex.
+func (limiter *Limiter) sweep(now time.Time) {
+	log.Traceln("--> server.Limiter.sweep")
+	limiter.swept = now
+	rateSince := now.Add(-limiter.seconds("Limits.RateWindow"))
+	for _, conns := range []map[string][]time.Time{limiter.hosts, limiter.subnets} {
+		for key, times := range conns {
+			if len(prune(times, rateSince)) == 0 {
+				delete(conns, key)
+			}
+		}
+	}
+	failureSince := now.Add(-limiter.seconds("Limits.FailureWindow"))
+	for host, times := range limiter.failures {
+		if len(prune(times, failureSince)) == 0 {
+			delete(limiter.failures, host)
+		}
+	}
+	expired := false
+	for host, until := range limiter.bans {
+		if !now.Before(until) {
+			delete(limiter.bans, host)
+			expired = true
+		}
+	}
+	if expired {
+		limiter.saveBans()
+	}
+}
+
+// Banned reports whether the address is currently banned.
+func (limiter *Limiter) Banned(ip net.IP) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	until, ok := limiter.bans[hostKey(ip)]
+	return ok && limiter.now().Before(until)
+}
+
+func (limiter *Limiter) seconds(key string) time.Duration {
+	return time.Duration(limiter.config.GetInt(key)) * time.Second
+}
+
+func (limiter *Limiter) subnetKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		bits := limiter.config.GetInt("Limits.SubnetMaskIPv4")
+		return fmt.Sprintf("%s/%d", ip4.Mask(net.CIDRMask(bits, 32)).String(), bits)
+	}
+	bits := limiter.config.GetInt("Limits.SubnetMaskIPv6")
+	return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(bits, 128)).String(), bits)
+}
+
+func hostKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return ip.String()
+}
+
+// prune drops all the timestamps that lie before the given point in time.
+func prune(times []time.Time, since time.Time) []time.Time {
+	idx := 0
+	for idx < len(times) && times[idx].Before(since) {
+		idx++
+	}
+	return times[idx:]
+}
+
+func (limiter *Limiter) loadBans() error {
+	banFile := limiter.config.GetString("Limits.BanFile")
+	log.WithField("banFile", banFile).Traceln("--> server.Limiter.loadBans")
+	if banFile == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(banFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	bans := map[string]time.Time{}
+	if err := json.Unmarshal(content, &bans); err != nil {
+		return err
+	}
+	now := limiter.now()
+	for host, until := range bans {
+		if now.Before(until) {
+			limiter.bans[host] = until
+		}
+	}
+	log.WithField("bans", len(limiter.bans)).Infoln("Loaded ban list.")
+	return nil
+}
+
+// saveBans persists the ban list if a ban file is configured. The caller has to hold the mutex.
+func (limiter *Limiter) saveBans() {
+	banFile := limiter.config.GetString("Limits.BanFile")
+	log.WithField("banFile", banFile).Traceln("--> server.Limiter.saveBans")
+	if banFile == "" {
+		return
+	}
+	content, err := json.MarshalIndent(limiter.bans, "", "  ")
+	if err == nil {
+		tmpFile := path.Join(path.Dir(banFile), "."+path.Base(banFile)+".tmp")
+		if err = ioutil.WriteFile(tmpFile, content, 0600); err == nil {
+			err = os.Rename(tmpFile, banFile)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("banFile", banFile).Errorln("Failed to save ban list.")
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	config := LoadConfig("")
+	config.Set("Limits.BanFile", path.Join(t.TempDir(), "bans.json"))
+	limiter := NewLimiter(config)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestLimiter_Admit_PerHost(t *testing.T) {
+	limiter, now := newTestLimiter(t)
+	limiter.config.Set("Limits.MaxConnectionsPerHost", 2)
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 2; i++ {
+		if err := limiter.Admit(ip); err != nil {
+			t.Error(err)
+		}
+		limiter.Release(ip, false)
+	}
+	if err := limiter.Admit(ip); err == nil {
+		t.Error("Third connection within the rate window was admitted.")
+	}
+	if err := limiter.Admit(net.ParseIP("198.51.100.1")); err != nil {
+		t.Error("Connection from another host was refused: " + err.Error())
+	}
+	*now = now.Add(61 * time.Second)
+	if err := limiter.Admit(ip); err != nil {
+		t.Error("Connection after the rate window was refused: " + err.Error())
+	}
+}
+
+func TestLimiter_Admit_PerSubnet(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	limiter.config.Set("Limits.MaxConnectionsPerSubnet", 2)
+	if err := limiter.Admit(net.ParseIP("2001:db8::1")); err != nil {
+		t.Error(err)
+	}
+	if err := limiter.Admit(net.ParseIP("2001:db8::2")); err != nil {
+		t.Error(err)
+	}
+	if err := limiter.Admit(net.ParseIP("2001:db8::3")); err == nil {
+		t.Error("Third connection from the same subnet was admitted.")
+	}
+	if err := limiter.Admit(net.ParseIP("2001:db8:1::1")); err != nil {
+		t.Error("Connection from another subnet was refused: " + err.Error())
+	}
+}
+
+func TestLimiter_Admit_Unauthenticated(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	limiter.config.Set("Limits.MaxUnauthenticated", 1)
+	first := net.ParseIP("192.0.2.1")
+	second := net.ParseIP("198.51.100.1")
+	if err := limiter.Admit(first); err != nil {
+		t.Error(err)
+	}
+	if err := limiter.Admit(second); err == nil {
+		t.Error("Second unauthenticated connection was admitted.")
+	}
+	limiter.Authenticated(first)
+	if err := limiter.Admit(second); err != nil {
+		t.Error("Connection was refused after the first one authenticated: " + err.Error())
+	}
+}
+
+func TestLimiter_Failed_Ban(t *testing.T) {
+	limiter, now := newTestLimiter(t)
+	limiter.config.Set("Limits.BanAfterFailures", 3)
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 3; i++ {
+		limiter.Failed(ip)
+	}
+	if !limiter.Banned(ip) {
+		t.Fatal("Host was not banned after repeated failures.")
+	}
+	if err := limiter.Admit(ip); err == nil {
+		t.Error("Banned host was admitted.")
+	}
+
+	restored := NewLimiter(limiter.config)
+	restored.now = limiter.now
+	if !restored.Banned(ip) {
+		t.Error("Ban was not restored from the ban file.")
+	}
+
+	*now = now.Add(time.Hour + time.Second)
+	if err := limiter.Admit(ip); err != nil {
+		t.Error("Host was still refused after the ban expired: " + err.Error())
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	limiter, now := newTestLimiter(t)
+	limiter.config.Set("Limits.BanAfterFailures", 1)
+	// A client rotating through the addresses of its subnet.
+	for i := 1; i <= 100; i++ {
+		ip := net.ParseIP(fmt.Sprintf("2001:db8::%x", i))
+		if err := limiter.Admit(ip); err == nil {
+			limiter.Release(ip, false)
+		}
+	}
+	limiter.Failed(net.ParseIP("192.0.2.1"))
+	*now = now.Add(time.Hour + time.Second)
+	if err := limiter.Admit(net.ParseIP("198.51.100.1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(limiter.hosts) != 1 || len(limiter.subnets) != 1 || len(limiter.failures) != 0 || len(limiter.bans) != 0 {
+		t.Errorf("Kept %d hosts, %d subnets, %d failures and %d bans.", len(limiter.hosts), len(limiter.subnets),
+			len(limiter.failures), len(limiter.bans))
+	}
+}
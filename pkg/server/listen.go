@@ -0,0 +1,211 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A listenAddress is an address goshd listens on. Only the wildcard address of the tcp protocol accepts both IPv4
+// and IPv6 connections on the same socket.
+type listenAddress struct {
+	sockAddr unix.Sockaddr
+	v6Only   bool
+}
+
+func (address listenAddress) String() string {
+	tcpAddr, err := tcpAddrFromSockaddr(address.sockAddr)
+	if err != nil {
+		return err.Error()
+	}
+	return tcpAddr.String()
+}
+
+// listenAddresses resolves the entries of Serve.ListenAddress for Serve.Protocol. Entries may carry their own port,
+// otherwise Serve.Port is used. Without any entries, goshd listens on the wildcard address.
+func listenAddresses(config *viper.Viper) ([]listenAddress, error) {
+	log.WithField("config", config).Traceln("--> server.listenAddresses")
+	protocol := config.GetString("Serve.Protocol")
+	port := strconv.Itoa(config.GetInt("Serve.Port"))
+	entries := config.GetStringSlice("Serve.ListenAddress")
+	if len(entries) == 0 {
+		switch protocol {
+		case common.TCP4:
+			entries = []string{"0.0.0.0"}
+		default:
+			entries = []string{"::"}
+		}
+	}
+	var addresses []listenAddress
+	for _, entry := range entries {
+		hostPort := entry
+		if _, _, err := net.SplitHostPort(entry); err != nil {
+			hostPort = net.JoinHostPort(strings.Trim(entry, "[]"), port)
+		}
+		tcpAddr, err := net.ResolveTCPAddr(protocol, hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("listen address %q: %s", entry, err.Error())
+		}
+		address := listenAddress{v6Only: true}
+		if ip4 := tcpAddr.IP.To4(); ip4 != nil && protocol != common.TCP6 {
+			sockAddr := &unix.SockaddrInet4{Port: tcpAddr.Port}
+			copy(sockAddr.Addr[:], ip4)
+			address.sockAddr = sockAddr
+		} else {
+			sockAddr := &unix.SockaddrInet6{Port: tcpAddr.Port}
+			copy(sockAddr.Addr[:], tcpAddr.IP.To16())
+			if tcpAddr.Zone != "" {
+				if iface, err := net.InterfaceByName(tcpAddr.Zone); err == nil {
+					sockAddr.ZoneId = uint32(iface.Index)
+				}
+			}
+			address.sockAddr = sockAddr
+			address.v6Only = protocol == common.TCP6 || len(config.GetStringSlice("Serve.ListenAddress")) > 0
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// listenSocket creates a socket listening on the address.
+func listenSocket(address listenAddress, backlog int) (int, error) {
+	log.WithFields(log.Fields{
+		"address": address.String(),
+		"backlog": backlog,
+	}).Traceln("--> server.listenSocket")
+	domain := unix.AF_INET
+	if _, ok := address.sockAddr.(*unix.SockaddrInet6); ok {
+		domain = unix.AF_INET6
+	}
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to create socket.")
+		return -1, err
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err == nil && domain == unix.AF_INET6 {
+		v6Only := 0
+		if address.v6Only {
+			v6Only = 1
+		}
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, v6Only)
+	}
+	if err != nil {
+		log.WithError(err).Errorln("Failed to set socket options.")
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	if err = unix.Bind(fd, address.sockAddr); err != nil {
+		log.WithError(err).WithField("address", address.String()).Errorln("Failed to bind socket.")
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	if err = unix.Listen(fd, backlog); err != nil {
+		log.WithError(err).Errorln("Failed to listen on socket.")
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	log.WithFields(log.Fields{
+		"fd":      fd,
+		"address": address.String(),
+		"backlog": backlog,
+	}).Infoln("Listening on socket.")
+	return fd, nil
+}
+
+// tcpAddrFromSockaddr converts the address of a peer, no matter the address family.
+func tcpAddrFromSockaddr(sockAddr unix.Sockaddr) (*net.TCPAddr, error) {
+	switch addr := sockAddr.(type) {
+	case *unix.SockaddrInet4:
+		return &net.TCPAddr{
+			IP:   net.IPv4(addr.Addr[0], addr.Addr[1], addr.Addr[2], addr.Addr[3]),
+			Port: addr.Port,
+		}, nil
+	case *unix.SockaddrInet6:
+		tcpAddr := &net.TCPAddr{
+			IP:   make(net.IP, net.IPv6len),
+			Port: addr.Port,
+		}
+		copy(tcpAddr.IP, addr.Addr[:])
+		if addr.ZoneId != 0 {
+			if iface, err := net.InterfaceByIndex(int(addr.ZoneId)); err == nil {
+				tcpAddr.Zone = iface.Name
+			}
+		}
+		return tcpAddr, nil
+	}
+	return nil, errors.New("unsupported address family")
+}
+
+// unixListenSocket creates a Unix socket listening at the path with the given permissions. A stale socket left
+// behind at the path gets replaced.
+func unixListenSocket(socketPath string, mode uint32, backlog int) (int, error) {
+	log.WithFields(log.Fields{
+		"socketPath": socketPath,
+		"mode":       fmt.Sprintf("%#o", mode),
+		"backlog":    backlog,
+	}).Traceln("--> server.unixListenSocket")
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return -1, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			log.WithError(err).Errorln("Failed to remove stale socket.")
+			return -1, err
+		}
+	}
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to create socket.")
+		return -1, err
+	}
+	if err = unix.Bind(fd, &unix.SockaddrUnix{Name: socketPath}); err == nil {
+		if err = os.Chmod(socketPath, os.FileMode(mode)); err == nil {
+			err = unix.Listen(fd, backlog)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("socketPath", socketPath).Errorln("Failed to listen on Unix socket.")
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	log.WithFields(log.Fields{
+		"fd":         fd,
+		"socketPath": socketPath,
+	}).Infoln("Listening on Unix socket.")
+	return fd, nil
+}
+
+// peerAddr converts the address of a peer accepted on the listening socket fd. Peers on Unix sockets come with their
+// credentials, since their address tells nothing about them.
+func peerAddr(fd int, socketFd int, sockAddr unix.Sockaddr) (net.Addr, *unix.Ucred, error) {
+	if _, ok := sockAddr.(*unix.SockaddrUnix); !ok {
+		tcpAddr, err := tcpAddrFromSockaddr(sockAddr)
+		return tcpAddr, nil, err
+	}
+	cred, err := unix.GetsockoptUcred(socketFd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return nil, nil, err
+	}
+	unixAddr := &net.UnixAddr{Net: common.UNIX}
+	if local, err := unix.Getsockname(fd); err == nil {
+		if localUnix, ok := local.(*unix.SockaddrUnix); ok {
+			unixAddr.Name = localUnix.Name
+		}
+	}
+	return unixAddr, cred, nil
+}
+
+// peerIP returns the IP address of a peer or nil if it has none.
+func peerIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
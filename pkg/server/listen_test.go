@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestListenAddresses_Wildcard(t *testing.T) {
+	config := LoadConfig("")
+	for protocol, v6 := range map[string]bool{"tcp": true, "tcp4": false, "tcp6": true} {
+		config.Set("Serve.Protocol", protocol)
+		addresses, err := listenAddresses(config)
+		if err != nil || len(addresses) != 1 {
+			t.Fatal(protocol, err)
+		}
+		_, isV6 := addresses[0].sockAddr.(*unix.SockaddrInet6)
+		if isV6 != v6 {
+			t.Error("Wrong address family for protocol " + protocol)
+		}
+		if protocol == "tcp" && addresses[0].v6Only {
+			t.Error("Wildcard address of tcp does not accept IPv4 connections.")
+		}
+	}
+}
+
+func TestListenAddresses_Explicit(t *testing.T) {
+	config := LoadConfig("")
+	config.Set("Serve.ListenAddress", []string{"127.0.0.1", "::1", "[::1]:2223"})
+	addresses, err := listenAddresses(config)
+	if err != nil || len(addresses) != 3 {
+		t.Fatal(err)
+	}
+	if address := addresses[0].String(); address != "127.0.0.1:2222" {
+		t.Error("Unexpected address " + address)
+	}
+	if address := addresses[1].String(); address != "[::1]:2222" {
+		t.Error("Unexpected address " + address)
+	}
+	if address := addresses[2].String(); address != "[::1]:2223" {
+		t.Error("Unexpected address " + address)
+	}
+
+	config.Set("Serve.Protocol", "tcp4")
+	if _, err := listenAddresses(config); err == nil {
+		t.Error("IPv6 address was accepted for tcp4.")
+	}
+}
+
+func TestUnixListenSocket(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "goshd.sock")
+	for i := 0; i < 2; i++ {
+		// The second round replaces the socket the first one left behind.
+		fd, err := unixListenSocket(socketPath, 0600, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(fd)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal("Unix socket has the wrong permissions.", err)
+	}
+	conn, err := net.Dial(common.UNIX, socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+}
+
+func TestPeerAddr_Unix(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "goshd.sock")
+	fd, err := unixListenSocket(socketPath, 0600, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	conn, err := net.Dial(common.UNIX, socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	socketFd, peer, err := unix.Accept(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(socketFd)
+	rAddr, cred, err := peerAddr(fd, socketFd, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rAddr.String() != socketPath || peerIP(rAddr) != nil {
+		t.Error("Unexpected peer address " + rAddr.String())
+	}
+	if cred == nil || int(cred.Uid) != os.Getuid() || int(cred.Pid) != os.Getpid() {
+		t.Error("Unexpected peer credentials.")
+	}
+}
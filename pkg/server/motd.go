@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/accounting"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/connection"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Users with this file in their home directory log in quietly.
+const hushLoginFile = ".hushlogin"
+
+// Scripts in the update-motd directory must have names run-parts(8) would run.
+var motdScriptPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// sendBanner sends the file configured in Login.Banner to the client before anything else happens. Legal notices
+// usually go there.
+func (host Host) sendBanner() error {
+	log.Traceln("--> server.Host.sendBanner")
+	bannerFile := host.config.GetString("Login.Banner")
+	if bannerFile == "" {
+		return nil
+	}
+	banner, err := ioutil.ReadFile(bannerFile)
+	if err != nil {
+		log.WithError(err).WithField("bannerFile", bannerFile).Warnln("Failed to read banner.")
+		return nil
+	}
+	if len(banner) > connection.MaxBannerLength {
+		log.WithField("bannerFile", bannerFile).Warnln("Banner is too long. Truncating it.")
+		banner = banner[:connection.MaxBannerLength]
+	}
+	if _, err := fmt.Fprint(host.conn, connection.BannerPacket{Length: len(banner)}.String()); err != nil {
+		log.WithError(err).Errorln("Failed to send banner packet.")
+		return err
+	}
+	if _, err := host.conn.Write(banner); err != nil {
+		log.WithError(err).Errorln("Failed to send banner.")
+		return err
+	}
+	return nil
+}
+
+// greet shows the message of the day and the previous login to a user who just logged in, unless the user has a
+// ~/.hushlogin file.
+func (host Host) greet(pwd *passwd.PassWd, lastLogin *accounting.LastLogin) {
+	log.WithField("user", pwd.Name).Traceln("--> server.Host.greet")
+	if _, err := os.Lstat(path.Join(pwd.HomeDir, hushLoginFile)); err == nil {
+		log.WithField("user", pwd.Name).Debugln("User logs in quietly.")
+		return
+	}
+	if host.config.GetBool("Login.PrintMotd") {
+		if host.config.GetBool("Login.UpdateMotd") {
+			host.writeText(runMotdScripts(host.config.GetString("Login.UpdateMotdDir"),
+				time.Duration(host.config.GetInt("Login.UpdateMotdTimeout"))*time.Second))
+		}
+		if motd, err := ioutil.ReadFile(host.config.GetString("Login.MotdFile")); err == nil {
+			host.writeText(motd)
+		} else if !os.IsNotExist(err) {
+			log.WithError(err).Warnln("Failed to read message of the day.")
+		}
+	}
+	if host.config.GetBool("Login.PrintLastLog") && lastLogin != nil {
+		from := " on " + lastLogin.Line
+		if lastLogin.Host != "" {
+			from = " from " + lastLogin.Host
+		}
+		host.writeLine("Last login: " + lastLogin.Time.Format(time.ANSIC) + from)
+	}
+}
+
+// runMotdScripts runs the executables in the directory in lexical order and returns what they print. All of them
+// together get the timeout to finish.
+func runMotdScripts(dir string, timeout time.Duration) []byte {
+	log.WithFields(log.Fields{
+		"dir":     dir,
+		"timeout": timeout,
+	}).Traceln("--> server.runMotdScripts")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warnln("Failed to list message of the day scripts.")
+		}
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output := &bytes.Buffer{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || entry.Mode().Perm()&0111 == 0 || !motdScriptPattern.MatchString(entry.Name()) {
+			continue
+		}
+		script := exec.CommandContext(ctx, path.Join(dir, entry.Name()))
+		script.Env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+		script.Stdout = output
+		// Children of a killed script could keep its output open.
+		script.WaitDelay = time.Second
+		if err := script.Run(); err != nil {
+			log.WithError(err).WithField("script", script.Path).Warnln("Message of the day script failed.")
+		}
+		if ctx.Err() != nil {
+			log.WithField("dir", dir).Warnln("Message of the day scripts timed out.")
+			break
+		}
+	}
+	return output.Bytes()
+}
+
+// writeText writes text to the client's terminal, which is in raw mode once the transfer stopped.
+func (host Host) writeText(text []byte) {
+	log.WithField("len", len(text)).Traceln("--> host.Host.writeText")
+	if len(text) == 0 {
+		return
+	}
+	text = bytes.ReplaceAll(bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	if _, err := host.terminal().Write(text); err != nil {
+		log.WithError(err).Warnln("Failed to write to client.")
+	}
+}
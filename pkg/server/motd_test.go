@@ -0,0 +1,80 @@
+package server
+
+import (
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/accounting"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// greetOutput returns what a user sees when logging in.
+func greetOutput(t *testing.T, host Host, pwd *passwd.PassWd, lastLogin *accounting.LastLogin) string {
+	conn, client := net.Pipe()
+	host.conn = conn
+	go func() {
+		host.greet(pwd, lastLogin)
+		_ = conn.Close()
+	}()
+	output, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestHost_Greet(t *testing.T) {
+	dir := t.TempDir()
+	motdFile := path.Join(dir, "motd")
+	if err := ioutil.WriteFile(motdFile, []byte("Welcome\nto gosh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scriptDir := path.Join(dir, "update-motd.d")
+	if err := os.Mkdir(scriptDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"10-header":     "#!/bin/sh\necho Header\n",
+		"20-skipped.sh": "#!/bin/sh\necho Skipped\n",
+	} {
+		if err := ioutil.WriteFile(path.Join(scriptDir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host := NewHost(LoadConfig(""))
+	host.config.Set("Login.MotdFile", motdFile)
+	host.config.Set("Login.UpdateMotd", true)
+	host.config.Set("Login.UpdateMotdDir", scriptDir)
+	pwd := &passwd.PassWd{Name: "alice", HomeDir: dir}
+	lastLogin := &accounting.LastLogin{Time: time.Date(2020, 9, 13, 12, 26, 40, 0, time.Local), Host: "192.0.2.1"}
+
+	expected := "Header\r\nWelcome\r\nto gosh\r\nLast login: Sun Sep 13 12:26:40 2020 from 192.0.2.1\r\n"
+	if output := greetOutput(t, host, pwd, lastLogin); output != expected {
+		t.Errorf("Unexpected greeting %q.", output)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, hushLoginFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output := greetOutput(t, host, pwd, lastLogin); output != "" {
+		t.Errorf("User with a .hushlogin got greeted with %q.", output)
+	}
+}
+
+func TestRunMotdScripts_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(dir, "50-slow"), []byte("#!/bin/sh\necho Slow\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	output := runMotdScripts(dir, 200*time.Millisecond)
+	if time.Since(started) > 5*time.Second {
+		t.Error("Slow script was not stopped.")
+	}
+	if string(output) != "Slow\n" {
+		t.Errorf("Unexpected output %q.", output)
+	}
+}
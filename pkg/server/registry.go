@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const drainPollInterval = 100 * time.Millisecond
+
+// A Session is a goshh process serving a single connection.
+type Session struct {
+	Pid        int
+	Started    time.Time
+	RemoteAddr net.Addr
+	User       string
+	Finished   time.Time
+	Status     *os.ProcessState
+	cmd        *exec.Cmd
+}
+
+func (session Session) String() string {
+	return fmt.Sprintf("pid %d, user %q, peer %s", session.Pid, session.User, session.RemoteAddr)
+}
+
+// The Registry keeps track of the live goshh processes. Accepted connections reserve a slot before their goshh
+// process is started, so that the session limits also account for processes still being set up.
+type Registry struct {
+	mutex    sync.Mutex
+	pending  int
+	sessions map[int]*Session
+}
+
+func NewRegistry() *Registry {
+	log.Traceln("--> server.NewRegistry")
+	return &Registry{sessions: map[int]*Session{}}
+}
+
+// Reserve reserves a slot for a new session unless there are already max sessions. A max of 0 means no limit.
+func (registry *Registry) Reserve(max int) error {
+	log.WithField("max", max).Traceln("--> server.Registry.Reserve")
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	total := registry.pending + len(registry.sessions)
+	if max > 0 && total >= max {
+		return fmt.Errorf("too many sessions (%d)", total)
+	}
+	registry.pending++
+	return nil
+}
+
+// Cancel gives back a reserved slot that never got a goshh process.
+func (registry *Registry) Cancel() {
+	log.Traceln("--> server.Registry.Cancel")
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.pending > 0 {
+		registry.pending--
+	}
+}
+
+// Add turns a reserved slot into a session for the started goshh process.
+func (registry *Registry) Add(cmd *exec.Cmd, rAddr net.Addr) *Session {
+	log.WithFields(log.Fields{
+		"pid":   cmd.Process.Pid,
+		"rAddr": rAddr.String(),
+	}).Traceln("--> server.Registry.Add")
+	session := &Session{
+		Pid:        cmd.Process.Pid,
+		Started:    time.Now(),
+		RemoteAddr: rAddr,
+		cmd:        cmd,
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.pending > 0 {
+		registry.pending--
+	}
+	registry.sessions[session.Pid] = session
+	log.WithFields(log.Fields{
+		"session":  session,
+		"sessions": len(registry.sessions),
+	}).Debugln("Registered session.")
+	return session
+}
+
+// Authenticate assigns the session to a user unless the user already has max sessions.
+func (registry *Registry) Authenticate(session *Session, user string, max int) error {
+	log.WithFields(log.Fields{
+		"session": session,
+		"user":    user,
+		"max":     max,
+	}).Traceln("--> server.Registry.Authenticate")
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	count := 0
+	for _, other := range registry.sessions {
+		if other != session && other.User == user {
+			count++
+		}
+	}
+	if max > 0 && count >= max {
+		return fmt.Errorf("too many sessions for user %s (%d)", user, count)
+	}
+	session.User = user
+	return nil
+}
+
+// Reap waits for the goshh process of the session to exit, records how it went and stops tracking it.
+func (registry *Registry) Reap(session *Session) {
+	log.WithField("session", session).Traceln("--> server.Registry.Reap")
+	err := session.cmd.Wait()
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	session.Finished = time.Now()
+	session.Status = session.cmd.ProcessState
+	delete(registry.sessions, session.Pid)
+	entry := log.WithFields(log.Fields{
+		"pid":      session.Pid,
+		"user":     session.User,
+		"rAddr":    session.RemoteAddr.String(),
+		"started":  session.Started.Format(time.RFC3339),
+		"duration": session.Finished.Sub(session.Started).Round(time.Second).String(),
+		"status":   session.Status.String(),
+		"sessions": len(registry.sessions),
+	})
+	if err != nil {
+		entry.WithError(err).Warnln("Child exited.")
+	} else {
+		entry.Infoln("Child exited.")
+	}
+}
+
+// Signal sends the signal to all the goshh processes that have not been reaped yet.
+func (registry *Registry) Signal(sig os.Signal) {
+	log.WithField("sig", sig.String()).Traceln("--> server.Registry.Signal")
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for _, session := range registry.sessions {
+		if err := session.cmd.Process.Signal(sig); err != nil {
+			log.WithError(err).WithField("session", session).Warnln("Failed to signal child.")
+		} else {
+			log.WithField("session", session).Infoln("Signalled child.")
+		}
+	}
+}
+
+// Drain waits up to the timeout for all the sessions to finish and reports whether they did.
+func (registry *Registry) Drain(timeout time.Duration) bool {
+	log.WithField("timeout", timeout.String()).Traceln("--> server.Registry.Drain")
+	deadline := time.Now().Add(timeout)
+	for {
+		registry.mutex.Lock()
+		sessions := len(registry.sessions)
+		registry.mutex.Unlock()
+		if sessions == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// Sessions returns a snapshot of the live sessions.
+func (registry *Registry) Sessions() []Session {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	sessions := make([]Session, 0, len(registry.sessions))
+	for _, session := range registry.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
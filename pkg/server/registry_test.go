@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"os/exec"
+	"testing"
+)
+
+func startTestSession(t *testing.T, registry *Registry) *Session {
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return registry.Add(cmd, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222})
+}
+
+func TestRegistry_Reserve(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Reserve(2); err != nil {
+		t.Error(err)
+	}
+	session := startTestSession(t, registry)
+	if err := registry.Reserve(2); err != nil {
+		t.Error(err)
+	}
+	if err := registry.Reserve(2); err == nil {
+		t.Error("Third session was reserved despite a limit of two.")
+	}
+	registry.Reap(session)
+	if session.Status == nil || !session.Status.Success() {
+		t.Error("Exit status of the reaped session was not recorded.")
+	}
+	if len(registry.Sessions()) != 0 {
+		t.Error("Reaped session is still tracked.")
+	}
+	if err := registry.Reserve(2); err != nil {
+		t.Error("Session could not be reserved after another one was reaped: " + err.Error())
+	}
+}
+
+func TestRegistry_Authenticate(t *testing.T) {
+	registry := NewRegistry()
+	first := startTestSession(t, registry)
+	second := startTestSession(t, registry)
+	third := startTestSession(t, registry)
+	if err := registry.Authenticate(first, "test", 1); err != nil {
+		t.Error(err)
+	}
+	if err := registry.Authenticate(second, "test", 1); err == nil {
+		t.Error("Second session of the same user was accepted despite a limit of one.")
+	}
+	if err := registry.Authenticate(third, "other", 1); err != nil {
+		t.Error("Session of another user was rejected: " + err.Error())
+	}
+	registry.Reap(first)
+	if err := registry.Authenticate(second, "test", 1); err != nil {
+		t.Error("Session could not be assigned after the user's other one was reaped: " + err.Error())
+	}
+	registry.Reap(second)
+	registry.Reap(third)
+}
@@ -0,0 +1,780 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a write to an attached client may take before the client counts as gone.
+	sessionWriteTimeout = 10 * time.Second
+	// How long a goshh resuming a session may take for the handshake.
+	sessionHandshakeTimeout = 10 * time.Second
+)
+
+var sessionIdPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// An outputBuffer keeps the latest output of a session, so that it can be replayed to a client that missed it. It
+// counts all the output ever written, which gives every byte an offset.
+type outputBuffer struct {
+	ring []byte
+	end  int64
+}
+
+func newOutputBuffer(size int) *outputBuffer {
+	return &outputBuffer{ring: make([]byte, size)}
+}
+
+func (buffer *outputBuffer) Write(p []byte) {
+	size := len(buffer.ring)
+	if len(p) > size {
+		buffer.end += int64(len(p) - size)
+		p = p[len(p)-size:]
+	}
+	pos := int(buffer.end % int64(size))
+	n := copy(buffer.ring[pos:], p)
+	copy(buffer.ring, p[n:])
+	buffer.end += int64(len(p))
+}
+
+// Start returns the offset of the oldest buffered output at or after the offset.
+func (buffer *outputBuffer) Start(offset int64) int64 {
+	if start := buffer.end - int64(len(buffer.ring)); offset < start {
+		offset = start
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > buffer.end {
+		offset = buffer.end
+	}
+	return offset
+}
+
+// Since returns the output after the offset and whether some of it is not buffered anymore.
+func (buffer *outputBuffer) Since(offset int64) ([]byte, bool) {
+	start := buffer.Start(offset)
+	lost := start > offset
+	offset = start
+	size := int64(len(buffer.ring))
+	output := make([]byte, buffer.end-offset)
+	n := copy(output, buffer.ring[offset%size:])
+	copy(output[n:], buffer.ring)
+	return output, lost
+}
+
+// A resumableSession outlives the connection of its client. While no client is attached, the output of the shell gets
+// buffered. Another goshh process can attach a client again through the session's Unix socket, either to resume the
+// session with its secret or to attach to it on behalf of the authenticated owner. The owner can also invite other
+// users, who join the session as guests. Without any attached client, the session expires after the timeout, unless
+// the timeout is 0.
+type resumableSession struct {
+	Id          string
+	secret      string
+	User        string
+	timeout     time.Duration
+	input       io.Writer
+	onExpire    func()
+	mutex       sync.Mutex
+	output      *outputBuffer
+	conn        net.Conn
+	detached    time.Time
+	invitations map[string]bool // Whether the invited users may write to the session.
+	guests      map[net.Conn]*sessionGuest
+	auditFile   string
+	expiry      *time.Timer
+	listener    net.Listener
+	socketPath  string
+}
+
+// A sessionGuest is a user who joined the session of another user.
+type sessionGuest struct {
+	User     string
+	Writable bool
+}
+
+// SessionInfo describes a session for listings.
+type SessionInfo struct {
+	Id       string
+	User     string
+	Attached bool
+	Detached time.Time // When the last client left, if none is attached.
+}
+
+// newResumableSession creates a session that forwards the input of its clients to input and listens for clients to
+// resume it in the directory.
+func newResumableSession(dir string, bufferSize int, timeout time.Duration, input io.Writer, onExpire func()) (*resumableSession, error) {
+	log.WithFields(log.Fields{
+		"dir":        dir,
+		"bufferSize": bufferSize,
+		"timeout":    timeout,
+	}).Traceln("--> server.newResumableSession")
+	ErrorMsg := "Failed to create resumable session."
+	id, err := randomHex(8)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	socketPath := path.Join(dir, id+".sock")
+	listener, err := net.Listen(common.UNIX, socketPath)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		_ = listener.Close()
+		log.WithError(err).Errorln(ErrorMsg)
+		return nil, err
+	}
+	session := &resumableSession{
+		Id:          id,
+		secret:      secret,
+		timeout:     timeout,
+		input:       input,
+		onExpire:    onExpire,
+		output:      newOutputBuffer(bufferSize),
+		detached:    time.Now(),
+		invitations: map[string]bool{},
+		guests:      map[net.Conn]*sessionGuest{},
+		listener:    listener,
+		socketPath:  socketPath,
+	}
+	go session.serveResumes(listener)
+	log.WithFields(log.Fields{
+		"id":         id,
+		"socketPath": socketPath,
+	}).Infoln("Created resumable session.")
+	return session, nil
+}
+
+// Write buffers the output of the shell and sends it to the attached clients. It never fails, a client that cannot
+// keep up gets detached.
+func (session *resumableSession) Write(p []byte) (int, error) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.writeLocked(p)
+	return len(p), nil
+}
+
+func (session *resumableSession) writeLocked(p []byte) {
+	session.output.Write(p)
+	if session.conn != nil {
+		_ = session.conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		if _, err := session.conn.Write(p); err != nil {
+			log.WithError(err).Warnln("Failed to write to client. Detaching it.")
+			session.detachLocked()
+		}
+	}
+	for conn, guest := range session.guests {
+		_ = conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		if _, err := conn.Write(p); err != nil {
+			log.WithError(err).WithField("user", guest.User).Warnln("Failed to write to guest. Detaching it.")
+			// Closing makes forwardGuestInput see the guest leave.
+			_ = conn.Close()
+		}
+	}
+}
+
+// noticeLocked shows a message to everyone in the session.
+func (session *resumableSession) noticeLocked(msg string) {
+	session.writeLocked([]byte("\r\ngosh: " + msg + "\r\n"))
+}
+
+// Attach makes conn the client of the session, replacing the former one. The client gets the output after the offset
+// replayed, as far as it is still buffered.
+func (session *resumableSession) Attach(conn net.Conn, offset int64) {
+	log.WithFields(log.Fields{
+		"id":     session.Id,
+		"offset": offset,
+	}).Traceln("--> server.resumableSession.Attach")
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.listener == nil {
+		log.WithField("id", session.Id).Warnln("Session is closed already.")
+		_ = conn.Close()
+		return
+	}
+	if session.conn != nil {
+		_ = session.conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		_, _ = fmt.Fprint(session.conn, "\r\ngosh: The session got attached elsewhere.\r\n")
+		session.detachLocked()
+	}
+	if session.expiry != nil {
+		session.expiry.Stop()
+		session.expiry = nil
+	}
+	replay, lost := session.output.Since(offset)
+	if lost {
+		log.WithField("id", session.Id).Warnln("Some output of the session is lost.")
+	}
+	if len(replay) > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		if _, err := conn.Write(replay); err != nil {
+			log.WithError(err).Warnln("Failed to replay output.")
+			_ = conn.Close()
+			session.startExpiry()
+			return
+		}
+	}
+	session.conn = conn
+	go session.forwardInput(conn)
+	log.WithField("id", session.Id).Infoln("Attached client to session.")
+	session.auditLocked("attached", session.User, true)
+}
+
+// AttachGuest lets an invited user join the session with conn. The guest gets the buffered output replayed.
+func (session *resumableSession) AttachGuest(conn net.Conn, user string) {
+	log.WithFields(log.Fields{
+		"id":   session.Id,
+		"user": user,
+	}).Traceln("--> server.resumableSession.AttachGuest")
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	writable, invited := session.invitations[user]
+	if session.listener == nil || !invited {
+		log.WithField("id", session.Id).Warnln("Guest cannot join the session anymore.")
+		_ = conn.Close()
+		return
+	}
+	if replay, _ := session.output.Since(0); len(replay) > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		if _, err := conn.Write(replay); err != nil {
+			log.WithError(err).Warnln("Failed to replay output.")
+			_ = conn.Close()
+			return
+		}
+	}
+	if session.expiry != nil {
+		session.expiry.Stop()
+		session.expiry = nil
+	}
+	guest := &sessionGuest{User: user, Writable: writable}
+	session.guests[conn] = guest
+	go session.forwardGuestInput(conn, guest)
+	session.auditLocked("joined", user, writable)
+	session.noticeLocked(fmt.Sprintf("%s joined the session (%s).", user, accessMode(writable)))
+}
+
+// forwardGuestInput forwards the input of a guest as long as the guest may write to the session.
+func (session *resumableSession) forwardGuestInput(conn net.Conn, guest *sessionGuest) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		session.mutex.Lock()
+		writable := guest.Writable
+		session.mutex.Unlock()
+		if n > 0 && writable {
+			if _, err := session.input.Write(buf[:n]); err != nil {
+				log.WithError(err).Warnln("Failed to forward input of guest.")
+			}
+		}
+		if err != nil {
+			log.WithError(err).Debugln("Stopped reading from guest.")
+			break
+		}
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if _, ok := session.guests[conn]; !ok {
+		return
+	}
+	_ = conn.Close()
+	delete(session.guests, conn)
+	session.auditLocked("left", guest.User, guest.Writable)
+	session.noticeLocked(guest.User + " left the session.")
+	session.startExpiry()
+}
+
+// Invite lets the user join the session, with write access if writable. Inviting a guest again changes its access.
+func (session *resumableSession) Invite(user string, writable bool) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.invitations[user] = writable
+	for _, guest := range session.guests {
+		if guest.User == user {
+			guest.Writable = writable
+		}
+	}
+	session.auditLocked("invited", user, writable)
+}
+
+// Uninvite withdraws the invitation of the user and disconnects the user's guests.
+func (session *resumableSession) Uninvite(user string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	delete(session.invitations, user)
+	for conn, guest := range session.guests {
+		if guest.User == user {
+			// Closing makes forwardGuestInput see the guest leave.
+			_ = conn.Close()
+		}
+	}
+	session.auditLocked("uninvited", user, false)
+}
+
+// SetAuditFile sets the file the participants of the session get recorded in.
+func (session *resumableSession) SetAuditFile(auditFile string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.auditFile = auditFile
+}
+
+// auditLocked records what a participant did in the log and in the audit file, if there is one.
+func (session *resumableSession) auditLocked(event string, user string, writable bool) {
+	log.WithFields(log.Fields{
+		"id":    session.Id,
+		"owner": session.User,
+		"event": event,
+		"user":  user,
+		"mode":  accessMode(writable),
+	}).Infoln("Session participant changed.")
+	if session.auditFile == "" {
+		return
+	}
+	file, err := os.OpenFile(session.auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to open audit file.")
+		return
+	}
+	defer func() { _ = file.Close() }()
+	if _, err := fmt.Fprintf(file, "%s session=%s owner=%s event=%s user=%s mode=%s\n", time.Now().Format(time.RFC3339),
+		session.Id, session.User, event, user, accessMode(writable)); err != nil {
+		log.WithError(err).Errorln("Failed to write audit file.")
+	}
+}
+
+func accessMode(writable bool) string {
+	if writable {
+		return "read-write"
+	}
+	return "read-only"
+}
+
+func (session *resumableSession) forwardInput(conn net.Conn) {
+	if _, err := io.Copy(session.input, conn); err != nil {
+		log.WithError(err).Debugln("Stopped reading from client.")
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.conn == conn {
+		log.WithField("id", session.Id).Infoln("Client left the session.")
+		session.auditLocked("detached", session.User, true)
+		session.detachLocked()
+	}
+}
+
+func (session *resumableSession) detachLocked() {
+	_ = session.conn.Close()
+	session.conn = nil
+	session.detached = time.Now()
+	session.startExpiry()
+}
+
+func (session *resumableSession) startExpiry() {
+	if session.conn != nil || len(session.guests) > 0 {
+		return
+	}
+	if session.expiry == nil && session.listener != nil && session.timeout > 0 {
+		session.expiry = time.AfterFunc(session.timeout, func() {
+			log.WithField("id", session.Id).Warnln("Session expired without a client.")
+			session.onExpire()
+		})
+	}
+}
+
+// serveResumes lets goshh processes of reconnected clients resume the session through the socket.
+func (session *resumableSession) serveResumes(listener net.Listener) {
+	log.WithField("id", session.Id).Traceln("--> server.resumableSession.serveResumes")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.WithError(err).Debugln("Stopped accepting resumes.")
+			return
+		}
+		go session.handshake(conn)
+	}
+}
+
+// handshake answers the request of another goshh. It either asks for information about the session, sends the secret
+// along with the offset its client got to, names the owner on whose behalf it attaches or invites others, or names
+// the invited user on whose behalf it joins. Only the sockets directory keeps others from connecting, so the users are
+// trusted to be authenticated. The client gets attached once the other goshh confirms that it got authorized.
+func (session *resumableSession) handshake(conn net.Conn) {
+	log.WithField("id", session.Id).Traceln("--> server.resumableSession.handshake")
+	_ = conn.SetDeadline(time.Now().Add(sessionHandshakeTimeout))
+	line, err := readLine(conn)
+	fields := strings.Fields(line)
+	if err != nil || len(fields) == 0 {
+		log.WithError(err).Warnln("Got a malformed session request.")
+		_ = conn.Close()
+		return
+	}
+	session.mutex.Lock()
+	info := session.info()
+	session.mutex.Unlock()
+	var offset int64
+	guest := ""
+	switch {
+	case fields[0] == "info" && len(fields) == 1:
+		_, _ = fmt.Fprintf(conn, "info %s %t %d\n", info.User, info.Attached, info.Detached.Unix())
+		_ = conn.Close()
+		return
+	case fields[0] == "resume" && len(fields) == 3:
+		if subtle.ConstantTimeCompare([]byte(fields[1]), []byte(session.secret)) != 1 {
+			log.WithField("id", session.Id).Warnln("Refused to resume session with a wrong secret.")
+			refuse(conn, "wrong secret")
+			return
+		}
+		if info.User == "" {
+			log.WithField("id", session.Id).Warnln("Refused to resume a session before its user logged in.")
+			refuse(conn, "not logged in yet")
+			return
+		}
+		if offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			refuse(conn, "malformed offset")
+			return
+		}
+	case fields[0] == "owner" && len(fields) == 2:
+		if fields[1] != info.User {
+			log.WithFields(log.Fields{
+				"id":   session.Id,
+				"user": fields[1],
+			}).Warnln("Refused to attach a user to a session of another user.")
+			refuse(conn, "session belongs to another user")
+			return
+		}
+	case fields[0] == "invite" && len(fields) == 4:
+		if fields[1] != info.User || info.User == "" {
+			log.WithFields(log.Fields{
+				"id":   session.Id,
+				"user": fields[1],
+			}).Warnln("Refused invitation to a session of another user.")
+			refuse(conn, "session belongs to another user")
+			return
+		}
+		switch fields[3] {
+		case "ro", "rw":
+			session.Invite(fields[2], fields[3] == "rw")
+		case "none":
+			session.Uninvite(fields[2])
+		default:
+			refuse(conn, "unknown access mode")
+			return
+		}
+		_, _ = fmt.Fprintln(conn, "ok")
+		_ = conn.Close()
+		return
+	case fields[0] == "join" && len(fields) == 2:
+		session.mutex.Lock()
+		_, invited := session.invitations[fields[1]]
+		session.mutex.Unlock()
+		if !invited {
+			log.WithFields(log.Fields{
+				"id":   session.Id,
+				"user": fields[1],
+			}).Warnln("Refused uninvited user to join the session.")
+			refuse(conn, "not invited")
+			return
+		}
+		guest = fields[1]
+	default:
+		log.WithField("line", line).Warnln("Got a malformed session request.")
+		refuse(conn, "malformed request")
+		return
+	}
+	if info.User == "" {
+		// The user has not logged in yet.
+		refuse(conn, "session is not logged in")
+		return
+	}
+	session.mutex.Lock()
+	offset = session.output.Start(offset)
+	session.mutex.Unlock()
+	// Guests must not learn the secret, with it they could take the session over.
+	secret := session.secret
+	if guest != "" {
+		secret = "-"
+	}
+	if _, err := fmt.Fprintf(conn, "ok %s %s %d\n", info.User, secret, offset); err != nil {
+		_ = conn.Close()
+		return
+	}
+	// The input of the client follows right after the confirmation, so it must not be read ahead.
+	if line, err := readLine(conn); err != nil || line != "attach" {
+		log.WithError(err).WithField("id", session.Id).Infoln("Attaching got called off.")
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if guest != "" {
+		session.AttachGuest(conn, guest)
+	} else {
+		session.Attach(conn, offset)
+	}
+}
+
+func refuse(conn net.Conn, reason string) {
+	_, _ = fmt.Fprintf(conn, "error %s\n", reason)
+	_ = conn.Close()
+}
+
+func (session *resumableSession) info() SessionInfo {
+	info := SessionInfo{Id: session.Id, User: session.User, Attached: session.conn != nil}
+	if !info.Attached {
+		info.Detached = session.detached
+	}
+	return info
+}
+
+// SetUser sets the user the session belongs to once it is known.
+func (session *resumableSession) SetUser(user string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.User = user
+}
+
+// Close detaches the client and stops accepting resumes.
+func (session *resumableSession) Close() {
+	log.WithField("id", session.Id).Traceln("--> server.resumableSession.Close")
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	listener := session.listener
+	session.listener = nil
+	if session.expiry != nil {
+		session.expiry.Stop()
+	}
+	if session.conn != nil {
+		_ = session.conn.Close()
+		session.conn = nil
+	}
+	for conn := range session.guests {
+		_ = conn.Close()
+	}
+	if listener != nil {
+		_ = listener.Close()
+		_ = os.Remove(session.socketPath)
+	}
+}
+
+// Token is what the client needs to resume the session.
+func (session *resumableSession) Token() string {
+	return session.Id + ":" + session.secret
+}
+
+// A sessionAttachment is the connection of a goshh to a session of another goshh, before the client gets attached.
+type sessionAttachment struct {
+	Conn   net.Conn
+	User   string // The user the session belongs to.
+	Token  string // What the client needs to resume the session.
+	Offset int64  // Where the output the session replays starts.
+}
+
+// resumeSession connects to the session the token names. The token is made of the session id, its secret and the
+// offset of the output the client got to. Once the client got authorized, confirmAttachment attaches it.
+func resumeSession(dir string, token string) (*sessionAttachment, error) {
+	log.WithField("dir", dir).Traceln("--> server.resumeSession")
+	parts := strings.Split(token, ":")
+	if len(parts) != 3 || !sessionIdPattern.MatchString(parts[0]) {
+		return nil, errors.New("malformed session token")
+	}
+	if _, err := strconv.ParseInt(parts[2], 10, 64); err != nil {
+		return nil, errors.New("malformed session token")
+	}
+	return requestSession(dir, parts[0], fmt.Sprintf("resume %s %s", parts[1], parts[2]))
+}
+
+// attachSession connects to the session with the id on behalf of its authenticated owner. Once the client got
+// authorized, confirmAttachment attaches it.
+func attachSession(dir string, id string, user string) (*sessionAttachment, error) {
+	log.WithFields(log.Fields{
+		"dir":  dir,
+		"id":   id,
+		"user": user,
+	}).Traceln("--> server.attachSession")
+	if !sessionIdPattern.MatchString(id) {
+		return nil, errors.New("malformed session id")
+	}
+	return requestSession(dir, id, "owner "+user)
+}
+
+func requestSession(dir string, id string, request string) (*sessionAttachment, error) {
+	conn, err := net.DialTimeout(common.UNIX, path.Join(dir, id+".sock"), sessionHandshakeTimeout)
+	if err != nil {
+		return nil, errors.New("session does not exist anymore")
+	}
+	_ = conn.SetDeadline(time.Now().Add(sessionHandshakeTimeout))
+	if _, err := fmt.Fprintln(conn, request); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	answer, err := readLine(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	fields := strings.Fields(answer)
+	if len(fields) == 0 || fields[0] != "ok" {
+		_ = conn.Close()
+		return nil, errors.New(strings.TrimPrefix(answer, "error "))
+	}
+	var offset int64
+	if len(fields) == 4 {
+		offset, err = strconv.ParseInt(fields[3], 10, 64)
+	}
+	if len(fields) != 4 || err != nil {
+		_ = conn.Close()
+		return nil, errors.New("malformed answer from session")
+	}
+	return &sessionAttachment{Conn: conn, User: fields[1], Token: id + ":" + fields[2], Offset: offset}, nil
+}
+
+// joinSessionAs connects to the session with the id on behalf of an invited user. Once the client got authorized,
+// confirmAttachment attaches it as a guest.
+func joinSessionAs(dir string, id string, user string) (*sessionAttachment, error) {
+	log.WithFields(log.Fields{
+		"dir":  dir,
+		"id":   id,
+		"user": user,
+	}).Traceln("--> server.joinSessionAs")
+	if !sessionIdPattern.MatchString(id) {
+		return nil, errors.New("malformed session id")
+	}
+	return requestSession(dir, id, "join "+user)
+}
+
+// inviteToSession changes the access of the user to the session with the id on behalf of its owner. The mode is one
+// of ro, rw and none.
+func inviteToSession(dir string, id string, owner string, user string, mode string) error {
+	log.WithFields(log.Fields{
+		"dir":   dir,
+		"id":    id,
+		"owner": owner,
+		"user":  user,
+		"mode":  mode,
+	}).Traceln("--> server.inviteToSession")
+	if !sessionIdPattern.MatchString(id) {
+		return errors.New("malformed session id")
+	}
+	conn, err := net.DialTimeout(common.UNIX, path.Join(dir, id+".sock"), sessionHandshakeTimeout)
+	if err != nil {
+		return errors.New("session does not exist anymore")
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(sessionHandshakeTimeout))
+	if _, err := fmt.Fprintf(conn, "invite %s %s %s\n", owner, user, mode); err != nil {
+		return err
+	}
+	answer, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	if answer != "ok" {
+		return errors.New(strings.TrimPrefix(answer, "error "))
+	}
+	return nil
+}
+
+func confirmAttachment(attachment *sessionAttachment) error {
+	log.Traceln("--> server.confirmAttachment")
+	_, err := fmt.Fprintln(attachment.Conn, "attach")
+	_ = attachment.Conn.SetDeadline(time.Time{})
+	return err
+}
+
+// listSessions returns information about the sessions in the directory, skipping the ones that do not answer.
+func listSessions(dir string) []SessionInfo {
+	log.WithField("dir", dir).Traceln("--> server.listSessions")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warnln("Failed to list sessions.")
+		}
+		return nil
+	}
+	var sessions []SessionInfo
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".sock")
+		if !sessionIdPattern.MatchString(id) || entry.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		info, err := querySession(dir, id)
+		if err != nil {
+			log.WithError(err).WithField("id", id).Debugln("Session did not answer.")
+			continue
+		}
+		sessions = append(sessions, *info)
+	}
+	return sessions
+}
+
+func querySession(dir string, id string) (*SessionInfo, error) {
+	conn, err := net.DialTimeout(common.UNIX, path.Join(dir, id+".sock"), sessionHandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(sessionHandshakeTimeout))
+	if _, err := fmt.Fprintln(conn, "info"); err != nil {
+		return nil, err
+	}
+	answer, err := readLine(conn)
+	if err != nil {
+		return nil, err
+	}
+	info := &SessionInfo{Id: id}
+	var detached int64
+	if _, err := fmt.Sscanf(answer, "info %s %t %d", &info.User, &info.Attached, &detached); err != nil {
+		return nil, err
+	}
+	if !info.Attached {
+		info.Detached = time.Unix(detached, 0)
+	}
+	return info, nil
+}
+
+func readLine(in io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 256 {
+		if _, err := io.ReadFull(in, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("line too long")
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutputBuffer_Since(t *testing.T) {
+	buffer := newOutputBuffer(8)
+	buffer.Write([]byte("hello"))
+	if output, lost := buffer.Since(0); string(output) != "hello" || lost {
+		t.Errorf("Got %q, lost %t.", output, lost)
+	}
+	buffer.Write([]byte(" world"))
+	if output, lost := buffer.Since(0); string(output) != "lo world" || !lost {
+		t.Errorf("Got %q, lost %t.", output, lost)
+	}
+	if output, lost := buffer.Since(7); string(output) != "orld" || lost {
+		t.Errorf("Got %q, lost %t.", output, lost)
+	}
+	if output, _ := buffer.Since(42); len(output) != 0 {
+		t.Errorf("Got %q past the end.", output)
+	}
+	buffer.Write([]byte("0123456789"))
+	if output, _ := buffer.Since(11); string(output) != "23456789" {
+		t.Errorf("Got %q after a long write.", output)
+	}
+}
+
+func TestResumableSession_Resume(t *testing.T) {
+	input := &lockedBuffer{}
+	session, err := newResumableSession(t.TempDir(), 64, time.Minute, input, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	_, _ = session.Write([]byte("before "))
+	_, _ = session.Write([]byte("after"))
+
+	token := session.Token() + ":7"
+	dir := path.Dir(session.socketPath)
+	// The token is handed out before login(1) authenticated the user.
+	if _, err := resumeSession(dir, token); err == nil {
+		t.Error("Resumed a session before its user logged in.")
+	}
+	session.SetUser("alice")
+	if _, err := resumeSession(dir, session.Id+":wrong:7"); err == nil {
+		t.Error("Resumed a session with a wrong secret.")
+	}
+	attachment, err := resumeSession(dir, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := attachment.Conn
+	defer conn.Close()
+	if attachment.User != "alice" || attachment.Offset != 7 {
+		t.Errorf("Session of %q resumes at %d.", attachment.User, attachment.Offset)
+	}
+	if err := confirmAttachment(attachment); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	replay := make([]byte, len("after"))
+	if _, err := io.ReadFull(conn, replay); err != nil || string(replay) != "after" {
+		t.Fatalf("Replayed %q: %v", replay, err)
+	}
+	if _, err := conn.Write([]byte("ls\n")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); input.String() != "ls\n"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session got input %q.", input.String())
+		}
+	}
+}
+
+func TestResumableSession_AttachOwner(t *testing.T) {
+	session, err := newResumableSession(t.TempDir(), 4, time.Minute, io.Discard, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	dir := path.Dir(session.socketPath)
+	if _, err := attachSession(dir, session.Id, "alice"); err == nil {
+		t.Error("Attached to a session nobody is logged in to.")
+	}
+	session.SetUser("alice")
+	_, _ = session.Write([]byte("output"))
+	if _, err := attachSession(dir, session.Id, "mallory"); err == nil {
+		t.Error("Attached to the session of another user.")
+	}
+	if sessions := listSessions(dir); len(sessions) != 1 || sessions[0].User != "alice" || sessions[0].Attached {
+		t.Errorf("Listed %+v.", sessions)
+	}
+	attachment, err := attachSession(dir, session.Id, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attachment.Conn.Close()
+	if attachment.Token != session.Token() || attachment.Offset != 2 {
+		t.Errorf("Attached with token %q at %d.", attachment.Token, attachment.Offset)
+	}
+	if err := confirmAttachment(attachment); err != nil {
+		t.Fatal(err)
+	}
+	replay := make([]byte, 4)
+	if _, err := io.ReadFull(attachment.Conn, replay); err != nil || string(replay) != "tput" {
+		t.Fatalf("Replayed %q: %v", replay, err)
+	}
+	if sessions := listSessions(dir); len(sessions) != 1 || !sessions[0].Attached {
+		t.Errorf("Listed %+v.", sessions)
+	}
+}
+
+func TestResumableSession_Expire(t *testing.T) {
+	expired := make(chan struct{})
+	session, err := newResumableSession(t.TempDir(), 64, 10*time.Millisecond, io.Discard, func() { close(expired) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	client, server := net.Pipe()
+	session.Attach(server, 0)
+	_ = client.Close()
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Error("Session did not expire after the client left.")
+	}
+}
+
+type lockedBuffer struct {
+	buffer bytes.Buffer
+	mutex  sync.Mutex
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+func TestResumableSession_Guests(t *testing.T) {
+	input := &lockedBuffer{}
+	session, err := newResumableSession(t.TempDir(), 1024, time.Minute, input, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	auditFile := path.Join(t.TempDir(), "audit.log")
+	session.SetAuditFile(auditFile)
+	session.SetUser("alice")
+	dir := path.Dir(session.socketPath)
+	if _, err := joinSessionAs(dir, session.Id, "bob"); err == nil {
+		t.Error("Joined without an invitation.")
+	}
+	if err := inviteToSession(dir, session.Id, "mallory", "bob", "rw"); err == nil {
+		t.Error("Invited to the session of another user.")
+	}
+	if err := inviteToSession(dir, session.Id, "alice", "bob", "ro"); err != nil {
+		t.Fatal(err)
+	}
+	attachment := joinAs(t, dir, session.Id, "bob")
+	defer attachment.Conn.Close()
+	if attachment.Token != session.Id+":-" {
+		t.Errorf("Guest got token %q.", attachment.Token)
+	}
+	expectOutput(t, attachment.Conn, "\r\ngosh: bob joined the session (read-only).\r\n")
+	_, _ = session.Write([]byte("output"))
+	expectOutput(t, attachment.Conn, "output")
+
+	// Input of a read-only guest is dropped.
+	if _, err := attachment.Conn.Write([]byte("rm -rf /\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = attachment.Conn.(*net.UnixConn).CloseWrite()
+	if _, err := io.ReadAll(attachment.Conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// A read-write guest co-drives.
+	if err := inviteToSession(dir, session.Id, "alice", "bob", "rw"); err != nil {
+		t.Fatal(err)
+	}
+	attachment = joinAs(t, dir, session.Id, "bob")
+	defer attachment.Conn.Close()
+	expectOutput(t, attachment.Conn, "\r\ngosh: bob joined the session (read-only).\r\noutput")
+	expectOutput(t, attachment.Conn, "\r\ngosh: bob left the session.\r\n")
+	expectOutput(t, attachment.Conn, "\r\ngosh: bob joined the session (read-write).\r\n")
+	if _, err := attachment.Conn.Write([]byte("ls\n")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); input.String() != "ls\n"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Session got input %q.", input.String())
+		}
+	}
+
+	if err := inviteToSession(dir, session.Id, "alice", "bob", "none"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(attachment.Conn); err != nil {
+		t.Errorf("Guest was not disconnected: %v", err)
+	}
+	audit, _ := ioutil.ReadFile(auditFile)
+	for _, event := range []string{"invited", "joined", "uninvited", "left"} {
+		if !strings.Contains(string(audit), "event="+event+" user=bob") {
+			t.Errorf("Audit file is missing the %s event:\n%s", event, audit)
+		}
+	}
+}
+
+func joinAs(t *testing.T, dir string, id string, user string) *sessionAttachment {
+	attachment, err := joinSessionAs(dir, id, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := confirmAttachment(attachment); err != nil {
+		t.Fatal(err)
+	}
+	_ = attachment.Conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return attachment
+}
+
+func expectOutput(t *testing.T, conn net.Conn, expected string) {
+	output := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, output); err != nil || string(output) != expected {
+		t.Fatalf("Got %q instead of %q: %v", output, expected, err)
+	}
+}
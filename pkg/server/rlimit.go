@@ -0,0 +1,270 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The resources that can be limited in the ResourceLimits config section. A limit of -1 means unlimited.
+var rlimitResources = map[string]int{
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"core":   unix.RLIMIT_CORE,
+}
+
+// resourceLimits collects the limits for a user from ResourceLimits.Default, ResourceLimits.Groups.<group> for each of
+// the user's groups and ResourceLimits.Users.<user>, with later ones overriding earlier ones. Resources without a
+// limit are inherited. User and group names have to match exactly.
+func resourceLimits(config *viper.Viper, userName string, groupNames []string) map[string]int64 {
+	log.WithFields(log.Fields{
+		"userName":   userName,
+		"groupNames": groupNames,
+	}).Traceln("--> server.resourceLimits")
+	limits := map[string]int64{}
+	for resource := range rlimitResources {
+		if key := "ResourceLimits.Default." + resource; config.IsSet(key) {
+			limits[resource] = config.GetInt64(key)
+		}
+	}
+	users, groups := namedResourceLimits(config)
+	for _, groupName := range groupNames {
+		for resource, limit := range groups[groupName] {
+			limits[resource] = limit
+		}
+	}
+	for resource, limit := range users[userName] {
+		limits[resource] = limit
+	}
+	return limits
+}
+
+// namedResourceLimits reads the Users and Groups tables of ResourceLimits from the config file by name. viper folds the
+// case of all keys and splits them at dots, so the names are taken from the file itself.
+func namedResourceLimits(config *viper.Viper) (users map[string]map[string]int64, groups map[string]map[string]int64) {
+	configFile := config.ConfigFileUsed()
+	if configFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to read resource limits.")
+		return nil, nil
+	}
+	var raw map[string]interface{}
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		log.WithError(err).Warnln("Failed to read resource limits.")
+		return nil, nil
+	}
+	section := tableOf(raw, "ResourceLimits")
+	return namedLimits(tableOf(section, "Users")), namedLimits(tableOf(section, "Groups"))
+}
+
+// tableOf merges the tables with the key of the config, which viper matches regardless of case.
+func tableOf(table map[string]interface{}, key string) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for name, value := range table {
+		if subTable, ok := value.(map[string]interface{}); ok && strings.EqualFold(name, key) {
+			for subName, subValue := range subTable {
+				merged[subName] = subValue
+			}
+		}
+	}
+	return merged
+}
+
+// namedLimits converts the tables of limits by user or group name. validResourceLimits already checked the values.
+func namedLimits(table map[string]interface{}) map[string]map[string]int64 {
+	named := map[string]map[string]int64{}
+	for name, value := range table {
+		resources, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		limits := map[string]int64{}
+		for resource, limit := range resources {
+			if number, err := castInt64(limit); err == nil {
+				limits[strings.ToLower(resource)] = number
+			}
+		}
+		named[name] = limits
+	}
+	return named
+}
+
+// limitCommand makes goshh run the command through itself with -limits, so that the limits are set before the user's
+// code runs. The helper still runs as root to raise hard limits, then drops to the credential of the command and
+// changes to its directory, as the home directory may not be accessible to root.
+func limitCommand(cmd *exec.Cmd, limits map[string]int64) error {
+	log.WithField("limits", limits).Traceln("--> server.limitCommand")
+	if len(limits) == 0 {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{executable, "-limits", formatLimits(limits)}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+		args = append(args, "-as", formatCredential(cmd.SysProcAttr.Credential))
+		cmd.SysProcAttr.Credential = nil
+	}
+	if cmd.Dir != "" {
+		args = append(args, "-dir", cmd.Dir)
+		cmd.Dir = ""
+	}
+	cmd.Args = append(append(args, "--", cmd.Path), cmd.Args...)
+	cmd.Path = executable
+	return nil
+}
+
+// ExecLimited sets the resource limits, switches to the credential and directory and executes args, the path of the
+// command followed by its arguments. It only returns if one of the steps failed.
+func ExecLimited(limits string, credential string, dir string, args []string, env []string) error {
+	log.WithFields(log.Fields{
+		"limits":     limits,
+		"credential": credential,
+		"dir":        dir,
+		"args":       args,
+	}).Traceln("--> server.ExecLimited")
+	if len(args) < 2 {
+		return errors.New("no command to execute")
+	}
+	parsed, err := parseLimits(limits)
+	if err != nil {
+		return err
+	}
+	if err := setResourceLimits(parsed); err != nil {
+		return err
+	}
+	if credential != "" {
+		if err := setCredential(credential); err != nil {
+			return err
+		}
+	}
+	if dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(args[0], args[1:], env)
+}
+
+// setResourceLimits sets the limits of the current process, which its children inherit.
+func setResourceLimits(limits map[string]int64) error {
+	for name, limit := range limits {
+		rlimit := unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}
+		if limit >= 0 {
+			rlimit = unix.Rlimit{Cur: uint64(limit), Max: uint64(limit)}
+		}
+		if err := unix.Setrlimit(rlimitResources[name], &rlimit); err != nil {
+			return fmt.Errorf("failed to limit %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setCredential switches the process to the groups, group and user of a credential from formatCredential.
+func setCredential(credential string) error {
+	parts := strings.Split(credential, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid credential %q", credential)
+	}
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return err
+	}
+	gids := []int{}
+	if parts[2] != "" {
+		for _, group := range strings.Split(parts[2], ",") {
+			groupId, err := strconv.Atoi(group)
+			if err != nil {
+				return err
+			}
+			gids = append(gids, groupId)
+		}
+	}
+	if err := syscall.Setgroups(gids); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
+
+// formatCredential encodes a credential as uid:gid:groups, with the groups separated by commas.
+func formatCredential(credential *syscall.Credential) string {
+	groups := make([]string, 0, len(credential.Groups))
+	for _, gid := range credential.Groups {
+		groups = append(groups, strconv.FormatUint(uint64(gid), 10))
+	}
+	return fmt.Sprintf("%d:%d:%s", credential.Uid, credential.Gid, strings.Join(groups, ","))
+}
+
+// formatLimits encodes the limits as resource=limit pairs separated by commas.
+func formatLimits(limits map[string]int64) string {
+	pairs := make([]string, 0, len(limits))
+	for resource, limit := range limits {
+		pairs = append(pairs, resource+"="+strconv.FormatInt(limit, 10))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseLimits decodes limits from formatLimits.
+func parseLimits(limits string) (map[string]int64, error) {
+	parsed := map[string]int64{}
+	for _, pair := range strings.Split(limits, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if _, known := rlimitResources[parts[0]]; len(parts) != 2 || !known {
+			return nil, fmt.Errorf("invalid limit %q", pair)
+		}
+		limit, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || limit < -1 {
+			return nil, fmt.Errorf("invalid limit %q", pair)
+		}
+		parsed[parts[0]] = limit
+	}
+	return parsed, nil
+}
+
+// validResourceLimits checks that the ResourceLimits section only holds known resources with valid limits.
+func validResourceLimits(config *viper.Viper) error {
+	for _, key := range config.AllKeys() {
+		if !strings.HasPrefix(key, "resourcelimits.") {
+			continue
+		}
+		resource := key[strings.LastIndex(key, ".")+1:]
+		if _, ok := rlimitResources[resource]; !ok {
+			return fmt.Errorf("%s: unknown resource %q", key, resource)
+		}
+		if limit, err := castInt64(config.Get(key)); err != nil || limit < -1 {
+			return fmt.Errorf("%s: invalid limit %v", key, config.Get(key))
+		}
+	}
+	return nil
+}
+
+func castInt64(value interface{}) (int64, error) {
+	switch number := value.(type) {
+	case int:
+		return int64(number), nil
+	case int64:
+		return number, nil
+	}
+	return 0, fmt.Errorf("%v is not an integer", value)
+}
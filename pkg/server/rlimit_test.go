@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestResourceLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "[ResourceLimits.Default]\nnofile = 1024\ncore = 0\n"+
+		"[ResourceLimits.Groups.developers]\ncore = -1\nnproc = 100\n"+
+		"[ResourceLimits.Users.alice]\nnproc = 200\n")
+	config := LoadConfig(dir)
+	limits := resourceLimits(config, "alice", []string{"users", "developers"})
+	if limits["nofile"] != 1024 || limits["core"] != -1 || limits["nproc"] != 200 {
+		t.Errorf("Unexpected limits %v.", limits)
+	}
+	limits = resourceLimits(config, "bob", []string{"users"})
+	if _, ok := limits["nproc"]; ok || limits["core"] != 0 {
+		t.Errorf("Unexpected limits %v.", limits)
+	}
+}
+
+func TestResourceLimits_Names(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "[resourcelimits.users.Bob]\nnofile = 100\n"+
+		"[ResourceLimits.Users.\"first.last\"]\nNofile = 200\n"+
+		"[ResourceLimits.Groups.Admins]\ncore = -1\n")
+	config := LoadConfig(dir)
+	if limits := resourceLimits(config, "Bob", nil); limits["nofile"] != 100 {
+		t.Errorf("Unexpected limits %v.", limits)
+	}
+	if limits := resourceLimits(config, "bob", []string{"admins"}); len(limits) != 0 {
+		t.Errorf("Names only differing in case matched: %v", limits)
+	}
+	limits := resourceLimits(config, "first.last", []string{"Admins"})
+	if limits["nofile"] != 200 || limits["core"] != -1 {
+		t.Errorf("Unexpected limits %v.", limits)
+	}
+}
+
+func TestValidResourceLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "[ResourceLimits.Default]\nstack = 10\n")
+	if err := validResourceLimits(LoadConfig(dir)); err == nil {
+		t.Error("Unknown resource was accepted.")
+	}
+	writeTestConfig(t, dir, "[ResourceLimits.Users.alice]\nnofile = -2\n")
+	if err := validResourceLimits(LoadConfig(dir)); err == nil {
+		t.Error("Invalid limit was accepted.")
+	}
+}
+
+func TestLimitCommand(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "ulimit -n")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:     true,
+		Credential: &syscall.Credential{Uid: 1000, Gid: 100, Groups: []uint32{100, 27}},
+	}
+	cmd.Dir = "/home/alice"
+	if err := limitCommand(cmd, map[string]int64{"nofile": 1024, "core": -1}); err != nil {
+		t.Fatal(err)
+	}
+	executable, _ := os.Executable()
+	expected := []string{executable, "-limits", "core=-1,nofile=1024", "-as", "1000:100:100,27", "-dir", "/home/alice",
+		"--", "/bin/sh", "/bin/sh", "-c", "ulimit -n"}
+	if cmd.Path != executable || !reflect.DeepEqual(cmd.Args, expected) {
+		t.Errorf("Got command %s %v.", cmd.Path, cmd.Args)
+	}
+	// goshh drops the credential itself after setting the limits.
+	if cmd.SysProcAttr.Credential != nil || cmd.Dir != "" || !cmd.SysProcAttr.Setsid {
+		t.Errorf("Got attributes %+v in %s.", cmd.SysProcAttr, cmd.Dir)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits := map[string]int64{"nofile": 1024, "nproc": 100, "core": -1}
+	if parsed, err := parseLimits(formatLimits(limits)); err != nil || !reflect.DeepEqual(parsed, limits) {
+		t.Errorf("Got limits %v: %v", parsed, err)
+	}
+	for _, invalid := range []string{"stack=10", "nofile", "nofile=-2", "nofile=many"} {
+		if _, err := parseLimits(invalid); err == nil {
+			t.Errorf("Accepted limits %q.", invalid)
+		}
+	}
+}
+
+func TestExecLimited(t *testing.T) {
+	if os.Getenv("GOSH_TEST_EXEC_LIMITED") != "" {
+		// The helper process, which ends up as the shell.
+		err := ExecLimited("nofile=64,core=0", "", "/", []string{"/bin/sh", "sh", "-c", "ulimit -n; ulimit -Hc; pwd"},
+			[]string{"PATH=/bin:/usr/bin"})
+		t.Fatal(err)
+	}
+	helper := exec.Command(os.Args[0], "-test.run", "^TestExecLimited$")
+	helper.Env = append(os.Environ(), "GOSH_TEST_EXEC_LIMITED=1")
+	output, err := helper.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "64\n0\n/\n" {
+		t.Errorf("Got output %q.", output)
+	}
+}
+
+func TestSetCredential_Invalid(t *testing.T) {
+	for _, invalid := range []string{"", "1000", "1000:x:", "1000:100:a"} {
+		if err := setCredential(invalid); err == nil {
+			t.Errorf("Accepted credential %q.", invalid)
+		}
+	}
+}
@@ -1,83 +1,400 @@
 package server
 
 import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	_ "github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/connection"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
 	"golang.org/x/sys/unix"
+	"io"
 	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rejectTimeout    = 10 * time.Second
+	interruptTimeout = 5 * time.Second
 )
 
 type Server struct {
-	config *viper.Viper
+	config      *viper.Viper
+	certificate *tls.Certificate
+	limiter     *Limiter
+	registry    *Registry
+	mutex       sync.Mutex
+	socketPath  string
+	stopping    chan struct{}
+	wakeFds     []int // A pipe that turns readable to wake up the accept loops once the server gets stopped.
 }
 
-func NewServer(config *viper.Viper) Server {
+func NewServer(config *viper.Viper) *Server {
 	log.WithField("config", config).Traceln("--> server.NewServer")
-	return Server{config: config}
+	return &Server{
+		config:   config,
+		limiter:  NewLimiter(config),
+		registry: NewRegistry(),
+		stopping: make(chan struct{}),
+	}
 }
 
-func (server Server) AwaitConnections(fdChan chan RemoteHandle) {
-	log.WithField("fdChan", fdChan).Traceln("--> server.AwaitConnections")
+// LoadCertKeyPair loads the certificate the server needs to tell clients why their connection got rejected.
+func (server *Server) LoadCertKeyPair(certPath string, keyFilePath string) error {
+	log.WithFields(log.Fields{
+		"certPath":    certPath,
+		"keyFilePath": keyFilePath,
+	}).Traceln("--> server.Server.LoadCertKeyPair")
+	cert, err := tls.LoadX509KeyPair(certPath, keyFilePath)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to load certificate key pair.")
+		return err
+	}
+	server.certificate = &cert
+	return nil
+}
 
-	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, unix.IPPROTO_TCP)
+// AwaitConnections accepts connections on all the listening sockets and sends them down fdChan until the server gets
+// stopped, upon which fdChan gets closed.
+func (server *Server) AwaitConnections(fdChan chan RemoteHandle) {
+	log.WithField("fdChan", fdChan).Traceln("--> server.AwaitConnections")
+	defer close(fdChan)
+	listeners, err := server.listen()
 	if err != nil {
-		log.WithError(err).Fatalln("Failed to create socket.")
-	} else {
-		log.WithField("fd", fd).Debugln("Created socket.")
+		log.WithError(err).Fatalln("Failed to listen.")
+	}
+	wakeFds := make([]int, 2)
+	if err := unix.Pipe2(wakeFds, unix.O_CLOEXEC); err != nil {
+		log.WithError(err).Fatalln("Failed to create wake up pipe.")
 	}
+	defer func() {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.wakeFds = nil
+		for _, fd := range wakeFds {
+			_ = unix.Close(fd)
+		}
+	}()
+	server.mutex.Lock()
+	server.wakeFds = wakeFds
+	server.mutex.Unlock()
+	waitGroup := sync.WaitGroup{}
+	for _, fd := range listeners {
+		waitGroup.Add(1)
+		go func(fd int) {
+			defer waitGroup.Done()
+			server.accept(fd, fdChan)
+		}(fd)
+	}
+	waitGroup.Wait()
+	log.Infoln("Stopped accepting connections.")
+}
 
-	sockAddr := &unix.SockaddrInet4{
-		Addr: [4]byte{},
-		Port: server.config.GetInt("Serve.Port"),
+// listen takes the listening sockets systemd passed in or otherwise creates one for every configured listen address.
+func (server *Server) listen() ([]int, error) {
+	log.Traceln("--> server.Server.listen")
+	listeners, err := activationListeners()
+	if err != nil || len(listeners) > 0 {
+		if len(server.config.GetStringSlice("Serve.ListenAddress")) > 0 {
+			log.Warnln("Ignoring the configured listen addresses in favor of the sockets passed by systemd.")
+		}
+		return listeners, err
 	}
-	err = unix.Bind(fd, sockAddr)
+	addresses, err := listenAddresses(server.config)
 	if err != nil {
-		log.WithError(err).Fatalln("Failed to bind socket.")
-	} else {
-		log.WithFields(log.Fields{
-			"fd":       fd,
-			"sockAddr": sockAddr,
-		}).Debugln("Bound socket.")
+		return nil, err
 	}
-	maxSessions := server.config.GetInt("Serve.MaxSessions")
-	err = unix.Listen(fd, maxSessions)
-	if err != nil {
-		log.WithError(err).Fatalln("Failed to listen on socket.")
-	} else {
-		log.WithFields(log.Fields{
-			"fd":          fd,
-			"maxSessions": maxSessions,
-		}).Infoln("Listening on socket.")
+	backlog := server.config.GetInt("Serve.ListenBacklog")
+	for _, address := range addresses {
+		fd, err := listenSocket(address, backlog)
+		if err == unix.EAFNOSUPPORT && !address.v6Only {
+			log.Warnln("IPv6 is not supported. Listening on IPv4 only.")
+			fd, err = listenSocket(listenAddress{sockAddr: &unix.SockaddrInet4{
+				Port: server.config.GetInt("Serve.Port"),
+			}}, backlog)
+		}
+		if err != nil {
+			for _, listener := range listeners {
+				_ = unix.Close(listener)
+			}
+			return nil, err
+		}
+		listeners = append(listeners, fd)
 	}
+	if socketPath := server.config.GetString("Serve.UnixSocket"); socketPath != "" {
+		mode, err := strconv.ParseUint(server.config.GetString("Serve.UnixSocketMode"), 8, 32)
+		if err == nil {
+			var fd int
+			if fd, err = unixListenSocket(socketPath, uint32(mode), backlog); err == nil {
+				server.socketPath = socketPath
+				listeners = append(listeners, fd)
+			}
+		}
+		if err != nil {
+			for _, listener := range listeners {
+				_ = unix.Close(listener)
+			}
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// accept accepts connections on the listening socket until the server gets stopped.
+func (server *Server) accept(fd int, fdChan chan RemoteHandle) {
+	log.WithField("fd", fd).Traceln("--> server.Server.accept")
+	defer server.closeListener(fd)
 	for {
-		socketFd, peer, err := unix.Accept(fd) // Can't use peer Sockaddr because Go...
+		if !server.awaitAcceptable(fd) {
+			return
+		}
+		socketFd, peer, err := unix.Accept4(fd, unix.SOCK_CLOEXEC)
 		if err != nil {
+			select {
+			case <-server.stopping:
+				return
+			default:
+			}
+			if err == unix.EINTR || err == unix.ECONNABORTED {
+				continue
+			}
 			log.WithError(err).Fatalln("Failed opening connection.")
-		} else {
-			peerInet4 := peer.(*unix.SockaddrInet4)
-			rAddr := net.TCPAddr{
-				IP: net.IPv4(
-					peerInet4.Addr[0],
-					peerInet4.Addr[1],
-					peerInet4.Addr[2],
-					peerInet4.Addr[3],
-				),
-				Port: peerInet4.Port,
+		}
+		rAddr, cred, err := peerAddr(fd, socketFd, peer)
+		if err != nil {
+			log.WithError(err).Errorln("Failed to identify peer.")
+			_ = unix.Close(socketFd)
+			continue
+		}
+		if cred == nil && server.config.GetBool("Serve.TCPKeepAlive") {
+			// Dead connections must be noticed, so that resumable sessions get detached from them.
+			if err := unix.SetsockoptInt(socketFd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+				log.WithError(err).Warnln("Failed to enable keepalive.")
+			}
+		}
+		configLock.RLock()
+		maxSessions := server.config.GetInt("Serve.MaxSessions")
+		err = permittedAddress(server.config, peerIP(rAddr))
+		configLock.RUnlock()
+		if err != nil {
+			log.WithError(err).WithField("rAddr", rAddr.String()).Warnln("Refused connection from peer.")
+			if err := unix.Close(socketFd); err != nil {
+				log.WithError(err).Errorln("Failed to close refused connection.")
+			}
+			continue
+		}
+		if err := server.limiter.Admit(peerIP(rAddr)); err != nil {
+			log.WithError(err).WithField("rAddr", rAddr.String()).Warnln("Refused connection from peer.")
+			if err := unix.Close(socketFd); err != nil {
+				log.WithError(err).Errorln("Failed to close refused connection.")
+			}
+			continue
+		}
+		if err := server.registry.Reserve(maxSessions); err != nil {
+			log.WithError(err).WithField("rAddr", rAddr.String()).Warnln("Rejected connection from peer.")
+			server.limiter.Release(peerIP(rAddr), false)
+			go server.reject(socketFd, err.Error())
+			continue
+		}
+		log.WithFields(log.Fields{
+			"socketFd": socketFd,
+			"rAddr":    rAddr.String(),
+			"cred":     cred,
+		}).Infoln("Accepted connection from peer.")
+		fdChan <- RemoteHandle{
+			Fd:         uintptr(socketFd),
+			RemoteAddr: rAddr,
+			Cred:       cred,
+		}
+	}
+}
+
+// awaitAcceptable waits until a connection is pending on the listening socket. It returns false once the server got
+// stopped. Waiting on the wake up pipe as well leaves the sockets untouched, which matters for the ones systemd passed
+// in, since they keep queueing connections while goshd restarts.
+func (server *Server) awaitAcceptable(fd int) bool {
+	for {
+		select {
+		case <-server.stopping:
+			return false
+		default:
+		}
+		pollFds := []unix.PollFd{
+			{Fd: int32(fd), Events: unix.POLLIN},
+			{Fd: int32(server.wakeFds[0]), Events: unix.POLLIN},
+		}
+		if _, err := unix.Poll(pollFds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
 			}
-			log.WithFields(log.Fields{
-				"socketFd": socketFd,
-				"rAddr":    rAddr.String(),
-			}).Infoln("Accepted connection from peer.")
-			fdChan <- RemoteHandle{
-				Fd:         uintptr(socketFd),
-				RemoteAddr: &rAddr,
+			log.WithError(err).Fatalln("Failed waiting for connections.")
+		}
+		if pollFds[1].Revents != 0 {
+			return false
+		}
+		if pollFds[0].Revents != 0 {
+			return true
+		}
+	}
+}
+
+// Stop makes AwaitConnections stop accepting connections.
+func (server *Server) Stop() {
+	log.Traceln("--> server.Server.Stop")
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	select {
+	case <-server.stopping:
+		return
+	default:
+		close(server.stopping)
+	}
+	if server.wakeFds != nil {
+		// The pipe stays readable, which wakes up all accept loops.
+		if _, err := unix.Write(server.wakeFds[1], []byte{0}); err != nil {
+			log.WithError(err).Warnln("Failed to wake up the accept loops.")
+		}
+	}
+}
+
+func (server *Server) closeListener(fd int) {
+	log.WithField("fd", fd).Traceln("--> server.Server.closeListener")
+	if local, err := unix.Getsockname(fd); err == nil && server.socketPath != "" {
+		if localUnix, ok := local.(*unix.SockaddrUnix); ok && localUnix.Name == server.socketPath {
+			if err := os.Remove(server.socketPath); err != nil {
+				log.WithError(err).Warnln("Failed to remove Unix socket.")
 			}
 		}
 	}
+	if err := unix.Close(fd); err != nil {
+		log.WithError(err).Warnln("Failed to close listening socket.")
+	}
+}
+
+// Shutdown asks the live goshh processes to wrap up their sessions by sending them SIGTERM and waits up to
+// Serve.ShutdownGraceTime seconds for them to finish. The remaining ones get interrupted.
+func (server *Server) Shutdown() {
+	log.Traceln("--> server.Server.Shutdown")
+	configLock.RLock()
+	grace := time.Duration(server.config.GetInt("Serve.ShutdownGraceTime")) * time.Second
+	configLock.RUnlock()
+	server.Stop()
+	server.registry.Signal(unix.SIGTERM)
+	if server.registry.Drain(grace) {
+		log.Infoln("All sessions finished.")
+		return
+	}
+	log.WithField("sessions", len(server.registry.Sessions())).Warnln("Grace period is over. Interrupting the remaining sessions.")
+	server.registry.Signal(unix.SIGINT)
+	if !server.registry.Drain(interruptTimeout) {
+		log.WithField("sessions", len(server.registry.Sessions())).Warnln("Some sessions did not finish.")
+	}
+}
+
+// reject tells the client on the other end of socketFd why it cannot have a session and hangs up.
+func (server *Server) reject(socketFd int, reason string) {
+	log.WithFields(log.Fields{
+		"socketFd": socketFd,
+		"reason":   reason,
+	}).Traceln("--> server.Server.reject")
+	if server.certificate == nil {
+		if err := unix.Close(socketFd); err != nil {
+			log.WithError(err).Errorln("Failed to close rejected connection.")
+		}
+		return
+	}
+	conn, err := utils.ConnFromFd(uintptr(socketFd), server.certificate)
+	if err != nil {
+		return
+	}
+	defer utils.CloseConn(conn)
+	if err := conn.SetDeadline(time.Now().Add(rejectTimeout)); err != nil {
+		log.WithError(err).Warnln("Failed to set deadline on rejected connection.")
+	}
+	if _, err := fmt.Fprint(conn, connection.RejectPacket{Reason: reason}.String()); err != nil {
+		log.WithError(err).Warnln("Failed to send reject packet.")
+	}
+}
+
+// Track registers the started goshh process serving the connection from rAddr, follows what it reports over the
+// control channel and reaps it once it exits.
+func (server *Server) Track(cmd *exec.Cmd, control io.ReadWriter, rAddr net.Addr) {
+	log.WithFields(log.Fields{
+		"pid":   cmd.Process.Pid,
+		"rAddr": rAddr.String(),
+	}).Traceln("--> server.Server.Track")
+	session := server.registry.Add(cmd, rAddr)
+	authenticated := server.monitor(session, control)
+	server.registry.Reap(session)
+	server.limiter.Release(peerIP(rAddr), authenticated)
+}
+
+// monitor follows the events a goshh process reports until the control channel gets closed, which happens once the
+// goshh process is gone. Authenticated users are checked against the session limits and the verdict is sent back
+// over the control channel.
+func (server *Server) monitor(session *Session, control io.ReadWriter) (authenticated bool) {
+	log.WithField("session", session).Traceln("--> server.Server.monitor")
+	ip := peerIP(session.RemoteAddr)
+	scanner := bufio.NewScanner(control)
+	for scanner.Scan() {
+		event, err := ParseEvent(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"event":   event,
+			"session": session,
+		}).Debugln("Received event from host.")
+		switch event.Type {
+		case EventAuthSucceeded:
+			if !authenticated {
+				authenticated = true
+				server.limiter.Authenticated(ip)
+			}
+			verdict := Event{Type: EventAccepted}
+			configLock.RLock()
+			maxSessions := server.config.GetInt("Serve.MaxSessionsPerUser")
+			err := permittedUser(server.config, event.Value)
+			configLock.RUnlock()
+			if err != nil {
+				log.WithError(err).WithField("session", session).Warnln("Rejected session.")
+				verdict = Event{Type: EventRejected, Value: err.Error()}
+			} else if err := server.registry.Authenticate(session, event.Value, maxSessions); err != nil {
+				log.WithError(err).WithField("session", session).Warnln("Rejected session.")
+				verdict = Event{Type: EventRejected, Value: err.Error()}
+			}
+			if _, err := fmt.Fprint(control, verdict.String()); err != nil {
+				log.WithError(err).Warnln("Failed to send verdict to host.")
+			}
+		case EventAuthFailed:
+			server.limiter.Failed(ip)
+		}
+	}
+	return
+}
+
+// Release gives back everything a connection from rAddr held that never made it to a goshh process.
+func (server *Server) Release(rAddr net.Addr) {
+	log.WithField("rAddr", rAddr.String()).Traceln("--> server.Server.Release")
+	server.limiter.Release(peerIP(rAddr), false)
+	server.registry.Cancel()
+}
+
+// Signal sends the signal to all the live goshh processes.
+func (server *Server) Signal(sig os.Signal) {
+	log.WithField("sig", sig.String()).Traceln("--> server.Server.Signal")
+	server.registry.Signal(sig)
 }
 
 type RemoteHandle struct {
 	Fd         uintptr
-	RemoteAddr *net.TCPAddr
+	RemoteAddr net.Addr
+	Cred       *unix.Ucred
 }
@@ -1 +1,57 @@
 package server
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer_Stop_ActivationListener(t *testing.T) {
+	fd, err := listenSocket(listenAddress{sockAddr: &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The copy of systemd, which shares the socket with goshd.
+	systemdFd, err := unix.Dup(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(systemdFd)
+	defer func(start int) { listenFdsStart = start }(listenFdsStart)
+	listenFdsStart = fd
+	_ = os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	_ = os.Setenv("LISTEN_FDS", "1")
+
+	server := NewServer(LoadConfig(""))
+	fdChan := make(chan RemoteHandle)
+	go server.AwaitConnections(fdChan)
+	time.Sleep(100 * time.Millisecond)
+	server.Stop()
+	select {
+	case <-fdChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Did not stop accepting connections.")
+	}
+
+	// The socket still queues connections for the next goshd.
+	if accepting, err := unix.GetsockoptInt(systemdFd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN); err != nil || accepting == 0 {
+		t.Fatalf("Socket stopped listening: %v", err)
+	}
+	local, err := unix.Getsockname(systemdFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(local.(*unix.SockaddrInet4).Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	socketFd, _, err := unix.Accept4(systemdFd, unix.SOCK_CLOEXEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = unix.Close(socketFd)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/utils"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// Values of Forwarding.StreamLocalBindUnlink, which decides what happens to a socket that already exists where a remote
+// forward should listen.
+const (
+	streamLocalBindUnlinkNo    = "no"    // Refuse to listen.
+	streamLocalBindUnlinkStale = "stale" // Remove it if nobody listens on it anymore.
+	streamLocalBindUnlinkYes   = "yes"   // Remove it in any case.
+)
+
+// permitStreamLocal checks whether the user may forward connections from or to the Unix socket at the path.
+func (host *Host) permitStreamLocal(path string) error {
+	log.WithField("path", path).Traceln("--> server.Host.permitStreamLocal")
+	allowed := host.config.GetBool("Forwarding.AllowStreamLocalForwarding")
+	if !allowed || host.keyOptions.noPortForwarding {
+		return errors.New("stream local forwarding is disabled")
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("socket path %q is not absolute", path)
+	}
+	return nil
+}
+
+// dialUnix connects to the Unix socket at the path with the permissions of the user.
+func (host *Host) dialUnix(path string) (net.Conn, error) {
+	log.WithField("path", path).Traceln("--> server.Host.dialUnix")
+	pwd, err := passwd.GetPwByName(host.user)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	err = asUser(pwd, func() error {
+		conn, err = net.DialTimeout(common.UNIX, path, forwardDialTimeout)
+		return err
+	})
+	return conn, err
+}
+
+// listenUnix listens on the Unix socket at the path with the permissions of the user, so that the socket belongs to
+// them. An existing socket gets removed according to Forwarding.StreamLocalBindUnlink, the new one gets the mode
+// Forwarding.StreamLocalBindMode.
+func (host *Host) listenUnix(path string) (net.Listener, error) {
+	log.WithField("path", path).Traceln("--> server.Host.listenUnix")
+	unlink := host.config.GetString("Forwarding.StreamLocalBindUnlink")
+	mode, _ := strconv.ParseUint(host.config.GetString("Forwarding.StreamLocalBindMode"), 8, 32)
+	pwd, err := passwd.GetPwByName(host.user)
+	if err != nil {
+		return nil, err
+	}
+	var listener net.Listener
+	err = asUser(pwd, func() error {
+		if err := removeSocket(path, unlink); err != nil {
+			return err
+		}
+		if listener, err = net.Listen(common.UNIX, path); err != nil {
+			return err
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			_ = listener.Close()
+			return err
+		}
+		return nil
+	})
+	return listener, err
+}
+
+// removeSocket removes an existing socket at the path according to the policy, a value of
+// Forwarding.StreamLocalBindUnlink. Anything but a socket never gets removed.
+func removeSocket(path string, policy string) error {
+	log.WithFields(log.Fields{
+		"path":   path,
+		"policy": policy,
+	}).Traceln("--> server.removeSocket")
+	switch policy {
+	case streamLocalBindUnlinkStale:
+		return utils.RemoveStaleSocket(path)
+	case streamLocalBindUnlinkYes:
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		return os.Remove(path)
+	default:
+		return nil
+	}
+}
+
+// asUser runs fn with the file system credentials and groups of the user, so that the files it creates belong to them
+// and it may only access what they may access. Only the current thread changes its credentials, which is locked to the
+// goroutine meanwhile. A thread that fails to restore its credentials stays locked, so that it ends along with the
+// goroutine.
+func asUser(pwd *passwd.PassWd, fn func() error) error {
+	log.WithField("user", pwd.Name).Traceln("--> server.asUser")
+	if unix.Geteuid() == int(pwd.Uid) {
+		return fn()
+	}
+	ErrorMsg := "Failed to switch to the credentials of the user."
+	groups, err := passwd.GetGroupList(pwd.Name, pwd.Gid)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	gids := make([]int, 0, len(groups))
+	for _, group := range groups {
+		gids = append(gids, int(group.Gid))
+	}
+
+	runtime.LockOSThread()
+	oldGids, err := unix.Getgroups()
+	if err != nil {
+		runtime.UnlockOSThread()
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	restore := func() {
+		_, _ = unix.SetfsuidRetUid(unix.Geteuid())
+		_, _ = unix.SetfsgidRetGid(unix.Getegid())
+		errGroups := unix.Setgroups(oldGids)
+		fsuid, _ := unix.SetfsuidRetUid(-1)
+		fsgid, _ := unix.SetfsgidRetGid(-1)
+		if errGroups != nil || fsuid != unix.Geteuid() || fsgid != unix.Getegid() {
+			log.WithError(errGroups).Errorln("Failed to restore the credentials of the thread.")
+			return
+		}
+		runtime.UnlockOSThread()
+	}
+	if err := unix.Setgroups(gids); err != nil {
+		restore()
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	_, _ = unix.SetfsgidRetGid(int(pwd.Gid))
+	_, _ = unix.SetfsuidRetUid(int(pwd.Uid))
+	// setfsuid(2) and setfsgid(2) do not fail, so the credentials get read back to tell whether they changed.
+	fsuid, _ := unix.SetfsuidRetUid(-1)
+	fsgid, _ := unix.SetfsgidRetGid(-1)
+	if fsuid != int(pwd.Uid) || fsgid != int(pwd.Gid) {
+		restore()
+		err := fmt.Errorf("file system credentials are %d:%d instead of %d:%d", fsuid, fsgid, pwd.Uid, pwd.Gid)
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	defer restore()
+	return fn()
+}
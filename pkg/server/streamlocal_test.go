@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
+	"net"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestRemoveSocket(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{streamLocalBindUnlinkStale, streamLocalBindUnlinkYes} {
+		if err := removeSocket(file, policy); err == nil {
+			t.Errorf("Removed a file that is no socket with policy %s.", policy)
+		}
+		if err := removeSocket(path.Join(dir, "missing"), policy); err != nil {
+			t.Errorf("Failed to remove a missing socket with policy %s: %v", policy, err)
+		}
+	}
+
+	socket := path.Join(dir, "socket")
+	listener, err := net.Listen(common.UNIX, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := removeSocket(socket, streamLocalBindUnlinkNo); err != nil {
+		t.Error(err)
+	}
+	if err := removeSocket(socket, streamLocalBindUnlinkStale); err == nil {
+		t.Error("Removed a socket in use.")
+	}
+	_ = listener.Close()
+	if err := removeSocket(socket, streamLocalBindUnlinkStale); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Lstat(socket); !os.IsNotExist(err) {
+		t.Errorf("Stale socket is still there: %v", err)
+	}
+}
+
+func TestHost_PermitStreamLocal(t *testing.T) {
+	host := NewHost(LoadConfig(""))
+	if err := host.permitStreamLocal("/run/app.sock"); err != nil {
+		t.Error(err)
+	}
+	if err := host.permitStreamLocal("app.sock"); err == nil {
+		t.Error("Permitted a relative socket path.")
+	}
+	host.config.Set("Forwarding.AllowStreamLocalForwarding", false)
+	if err := host.permitStreamLocal("/run/app.sock"); err == nil {
+		t.Error("Permitted a socket although stream local forwarding is disabled.")
+	}
+}
+
+func TestHost_ListenUnix(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Switching to another user requires root.")
+	}
+	host := NewHost(LoadConfig(""))
+	host.user = "nobody"
+	// The parent of t.TempDir() is accessible by root only.
+	dir, err := os.MkdirTemp("", "gosh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	socket := path.Join(dir, "forward.sock")
+	listener, err := host.listenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	info, err := os.Lstat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; uid == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Socket belongs to %d with mode %v.", uid, info.Mode().Perm())
+	}
+	if _, err := host.listenUnix(socket); err == nil {
+		t.Error("Listened on a socket in use.")
+	}
+	conn, err := host.dialUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	// The credentials of the server are back in place afterwards.
+	file := path.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(file); err != nil || info.Sys().(*syscall.Stat_t).Uid != 0 {
+		t.Errorf("File created afterwards does not belong to root: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/deltasync"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/passwd"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/remotefs"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/transfer"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// The subsystems goshh can run, each serving the connection on its stdin and stdout.
+var subsystems = map[string]func(conn io.ReadWriter) error{
+	transfer.Subsystem:  transfer.Serve,
+	remotefs.Subsystem:  remotefs.Serve,
+	deltasync.Subsystem: deltasync.Serve,
+}
+
+// RunSubsystem runs the subsystem with the name on in and out. goshh runs it as a process of its own with the
+// credentials of the user.
+func RunSubsystem(name string, in io.Reader, out io.Writer) error {
+	log.WithField("name", name).Traceln("--> server.RunSubsystem")
+	serve, ok := subsystems[name]
+	if !ok {
+		return fmt.Errorf("unknown subsystem %q", name)
+	}
+	return serve(struct {
+		io.Reader
+		io.Writer
+	}{in, out})
+}
+
+// serveSubsystem runs the subsystem the client asked for with the credentials of the user it authenticated as. As
+// there is no terminal to log in on, the user has to authenticate with keys or peer credentials.
+func (host *Host) serveSubsystem() error {
+	log.WithField("subsystem", host.subsystem).Traceln("--> server.Host.serveSubsystem")
+	ErrorMsg := "Failed to serve subsystem."
+	if err := permitSubsystem(host.config, host.subsystem); err != nil {
+		log.WithError(err).Warnln(ErrorMsg)
+		host.reject(err.Error())
+		return err
+	}
+	pwd := host.peerCredentialUser()
+	if pwd == nil && host.userName != "" {
+		err := host.authenticateWithKeys(host.userName)
+		if err == nil {
+			pwd, err = passwd.GetPwByName(host.userName)
+		} else if !os.IsNotExist(err) {
+			host.report(Event{Type: EventAuthFailed, Value: host.userName})
+		}
+		if err != nil {
+			log.WithError(err).Warnln(ErrorMsg)
+		}
+	}
+	if pwd == nil {
+		err := errors.New("subsystems require authentication with keys")
+		log.WithError(err).Warnln(ErrorMsg)
+		host.reject(err.Error())
+		return err
+	}
+	if err := host.authorize(pwd.Name); err != nil {
+		host.reject(err.Error())
+		return err
+	}
+	cmd, err := host.subsystemCommand(pwd)
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		host.reject("failed to start subsystem")
+		return err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	if err := host.stopTransfer(true); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	log.WithFields(log.Fields{
+		"user":      pwd.Name,
+		"subsystem": host.subsystem,
+		"pid":       cmd.Process.Pid,
+	}).Infoln("Started subsystem.")
+	go func() {
+		// The end of the input tells the subsystem that the client is done.
+		_, _ = io.Copy(stdin, host.conn)
+		_ = stdin.Close()
+	}()
+	if err := cmd.Wait(); err != nil {
+		log.WithError(err).Errorln("Subsystem exited with an error.")
+		return err
+	}
+	log.WithField("subsystem", host.subsystem).Infoln("Subsystem exited.")
+	return nil
+}
+
+// subsystemCommand prepares goshh to run the subsystem as the user within the user's resource limits, with the
+// connection as its stdout.
+func (host *Host) subsystemCommand(pwd *passwd.PassWd) (*exec.Cmd, error) {
+	log.WithField("user", pwd.Name).Traceln("--> server.Host.subsystemCommand")
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := passwd.GetGroupList(pwd.Name, pwd.Gid)
+	if err != nil {
+		return nil, err
+	}
+	gids := make([]uint32, 0, len(groups))
+	groupNames := make([]string, 0, len(groups))
+	for _, group := range groups {
+		gids = append(gids, group.Gid)
+		groupNames = append(groupNames, group.Name)
+	}
+	cmd := exec.Command(executable, "-subsystem", host.subsystem)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+		Credential: &syscall.Credential{
+			Uid:    pwd.Uid,
+			Gid:    pwd.Gid,
+			Groups: gids,
+		},
+	}
+	cmd.Dir = pwd.HomeDir
+	cmd.Env = loginEnvironment(host.config, pwd, host.userEnvs)
+	cmd.Stdout = host.conn
+	// Whatever the subsystem logs ends up with what goshh logs.
+	cmd.Stderr = os.Stderr
+	if err := limitCommand(cmd, resourceLimits(host.config, pwd.Name, groupNames)); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// permitSubsystem checks whether the subsystem is known and enabled in Subsystems.Enabled.
+func permitSubsystem(config *viper.Viper, name string) error {
+	if _, ok := subsystems[name]; !ok {
+		return fmt.Errorf("unknown subsystem %q", name)
+	}
+	for _, enabled := range config.GetStringSlice("Subsystems.Enabled") {
+		if enabled == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("subsystem %s is disabled", name)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bufio"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/deltasync"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/remotefs"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/transfer"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPermitSubsystem(t *testing.T) {
+	config := LoadConfig("")
+	for _, name := range []string{transfer.Subsystem, remotefs.Subsystem, deltasync.Subsystem} {
+		if err := permitSubsystem(config, name); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := permitSubsystem(config, "unknown"); err == nil {
+		t.Error("Permitted an unknown subsystem.")
+	}
+	config.Set("Subsystems.Enabled", []string{})
+	if err := permitSubsystem(config, transfer.Subsystem); err == nil {
+		t.Error("Permitted a disabled subsystem.")
+	}
+}
+
+func TestRunSubsystem(t *testing.T) {
+	conn, subsystemConn := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- RunSubsystem(transfer.Subsystem, subsystemConn, subsystemConn)
+	}()
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "source"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := transfer.Upload(conn, []string{path.Join(dir, "source")}, path.Join(dir, "target"), transfer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if data, err := os.ReadFile(path.Join(dir, "target")); err != nil || string(data) != "data" {
+		t.Errorf("Got %q: %v", data, err)
+	}
+	if err := RunSubsystem("unknown", subsystemConn, subsystemConn); err == nil {
+		t.Error("Ran an unknown subsystem.")
+	}
+}
+
+func TestHost_ServeSubsystem(t *testing.T) {
+	host := NewHost(LoadConfig(""))
+	host.subsystem = transfer.Subsystem
+	clientConn, serverConn := net.Pipe()
+	host.conn = serverConn
+	go func() {
+		_ = host.Serve()
+	}()
+	// Without keys, there is no way to authenticate.
+	line, err := bufio.NewReader(clientConn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "?R:") {
+		t.Errorf("Got %q instead of a rejection: %v", line, err)
+	}
+	_ = clientConn.Close()
+}
@@ -0,0 +1,68 @@
+package transfer
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io"
+	"path/filepath"
+)
+
+// Upload copies the local sources into the target on the server. With several sources, the target has to be a
+// directory. Failures of single files get reported to Options.Failed and make Upload return ErrIncomplete in the end.
+func Upload(conn io.ReadWriter, sources []string, target string, options Options) error {
+	log.WithFields(log.Fields{
+		"sources": sources,
+		"target":  target,
+	}).Traceln("--> transfer.Upload")
+	ErrorMsg := "Failed to upload."
+	peer := newPeer(conn)
+	if len(sources) > 1 {
+		options.directory = true
+	}
+	if err := peer.writeLine(commandUpload, options.flags(), target); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	if err := peer.readStatus(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	sender := &sender{peer: peer, options: options}
+	for _, source := range sources {
+		if err := sender.send(source, filepath.Base(filepath.Clean(source)), true); err != nil {
+			log.WithError(err).Errorln(ErrorMsg)
+			return err
+		}
+	}
+	if err := sender.quit(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	if sender.failures > 0 {
+		return ErrIncomplete
+	}
+	return nil
+}
+
+// Download copies the source on the server into the local target. Failures of single files get reported to
+// Options.Failed and make Download return ErrIncomplete in the end.
+func Download(conn io.ReadWriter, source string, target string, options Options) error {
+	log.WithFields(log.Fields{
+		"source": source,
+		"target": target,
+	}).Traceln("--> transfer.Download")
+	ErrorMsg := "Failed to download."
+	peer := newPeer(conn)
+	if err := peer.writeLine(commandDownload, options.flags(), source); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	receiver := &receiver{peer: peer, options: options, target: target}
+	if err := receiver.receive(); err != nil {
+		log.WithError(err).Errorln(ErrorMsg)
+		return err
+	}
+	if receiver.failures > 0 {
+		return ErrIncomplete
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/internal/pipetest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile writes the file with the mode and an mtime in the past.
+func writeFile(t *testing.T, path string, data string, mode os.FileMode) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1500000000, 0)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkFile checks the content, mode and mtime of a copied file.
+func checkFile(t *testing.T, path string, data string, mode os.FileMode) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(content) != data {
+		t.Errorf("%s contains %q instead of %q.", path, content, data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != mode || info.ModTime().Unix() != 1500000000 {
+		t.Errorf("%s has mode %v and mtime %v.", path, info.Mode().Perm(), info.ModTime())
+	}
+}
+
+func TestUpload(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(local, "tree", "a.txt"), "alpha", 0640)
+	writeFile(t, filepath.Join(local, "tree", "sub", "b.sh"), "#!/bin/sh\n", 0755)
+	writeFile(t, filepath.Join(local, "single"), "single", 0600)
+	conn := pipetest.Serve(t, Serve)
+
+	if err := Upload(conn, []string{filepath.Join(local, "tree")}, remote, Options{}); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("Uploaded a directory without recursion: %v", err)
+	}
+	var progress []int64
+	options := Options{
+		Recursive: true,
+		Progress: func(name string, done int64, size int64) {
+			progress = append(progress, done)
+		},
+	}
+	sources := []string{filepath.Join(local, "tree"), filepath.Join(local, "single")}
+	if err := Upload(conn, sources, remote, options); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(remote, "tree", "a.txt"), "alpha", 0640)
+	checkFile(t, filepath.Join(remote, "tree", "sub", "b.sh"), "#!/bin/sh\n", 0755)
+	checkFile(t, filepath.Join(remote, "single"), "single", 0600)
+	if len(progress) == 0 {
+		t.Error("Progress was not reported.")
+	}
+
+	// A single file may get another name.
+	single := []string{filepath.Join(local, "single")}
+	if err := Upload(conn, single, filepath.Join(remote, "renamed"), options); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(remote, "renamed"), "single", 0600)
+	// Several sources need a directory to go to.
+	if err := Upload(conn, sources, filepath.Join(remote, "missing"), options); err == nil {
+		t.Error("Uploaded several sources into a missing directory.")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(remote, "tree", "a.txt"), "alpha", 0644)
+	writeFile(t, filepath.Join(remote, "tree", "sub", "b.txt"), "beta", 0600)
+	conn := pipetest.Serve(t, Serve)
+
+	options := Options{Recursive: true}
+	if err := Download(conn, filepath.Join(remote, "tree"), filepath.Join(local, "copy"), options); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(local, "copy", "a.txt"), "alpha", 0644)
+	checkFile(t, filepath.Join(local, "copy", "sub", "b.txt"), "beta", 0600)
+
+	var failures []error
+	options = Options{Failed: func(err error) { failures = append(failures, err) }}
+	if err := Download(conn, filepath.Join(remote, "missing"), local, options); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("Downloaded a missing file: %v", err)
+	}
+	if len(failures) != 1 {
+		t.Errorf("Got failures %v.", failures)
+	}
+	// The connection is still usable after a failure.
+	if err := Download(conn, filepath.Join(remote, "tree", "a.txt"), local, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(local, "a.txt"), "alpha", 0644)
+}
+
+func TestDownload_Continue(t *testing.T) {
+	local, remote := t.TempDir(), t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	writeFile(t, filepath.Join(remote, "big"), string(data), 0644)
+	conn := pipetest.Serve(t, Serve)
+
+	// An interrupted transfer left the first part behind.
+	if err := os.WriteFile(filepath.Join(local, "big"), data[:30000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var start int64 = -1
+	options := Options{
+		Continue: true,
+		Progress: func(name string, done int64, size int64) {
+			if start < 0 {
+				start = done
+			}
+		},
+	}
+	if err := Download(conn, filepath.Join(remote, "big"), local, options); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(local, "big"), string(data), 0644)
+	if start != 30000 {
+		t.Errorf("Continued at %d.", start)
+	}
+
+	// What differs from the original gets transferred again.
+	if err := os.WriteFile(filepath.Join(local, "big"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start = -1
+	if err := Download(conn, filepath.Join(remote, "big"), local, options); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(local, "big"), string(data), 0644)
+	if start != 0 {
+		t.Errorf("Continued at %d.", start)
+	}
+}
@@ -0,0 +1,242 @@
+package transfer
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A receiver receives the files the other side sends into the target. Entries at the top of the transfer go into the
+// target if it is a directory, or replace it otherwise.
+type receiver struct {
+	peer        *peer
+	options     Options
+	target      string
+	directories []directory // The directories entered, innermost last.
+	failures    int
+}
+
+// A directory gets its mode and mtime once everything in it was received.
+type directory struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// receive receives files until the other side ends the transfer. The error is about the connection, failures of single
+// files get reported to the other side.
+func (receiver *receiver) receive() error {
+	log.WithField("target", receiver.target).Traceln("--> transfer.receiver.receive")
+	for {
+		fields, err := receiver.peer.readLine(2)
+		if err != nil {
+			return err
+		}
+		switch fields[0] {
+		case lineDirectory:
+			err = receiver.peer.writeStatus(receiver.enter(fields))
+		case lineEnd:
+			err = receiver.peer.writeStatus(receiver.leave())
+		case lineFile:
+			err = receiver.receiveFile(fields)
+		case lineWarning:
+			if len(fields) == 2 {
+				receiver.fail(&remoteError{fields[1]})
+			}
+			err = receiver.peer.writeStatus(nil)
+		case lineQuit:
+			return receiver.peer.writeStatus(nil)
+		default:
+			return fmt.Errorf("unexpected line %q", fields)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// path returns where the entry with the name goes.
+func (receiver *receiver) path(name string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+	if len(receiver.directories) > 0 {
+		return filepath.Join(receiver.directories[len(receiver.directories)-1].path, name), nil
+	}
+	if info, err := os.Stat(receiver.target); err == nil && info.IsDir() {
+		return filepath.Join(receiver.target, name), nil
+	}
+	return receiver.target, nil
+}
+
+// enter creates the directory announced by "D <mode> <mtime> <name>", unless it exists already.
+func (receiver *receiver) enter(fields []string) error {
+	mode, mtime, rest, err := parseHeader(fields, 1)
+	if err != nil {
+		return err
+	}
+	path, err := receiver.path(rest[0])
+	if err != nil {
+		receiver.fail(err)
+		return err
+	}
+	// The directory stays accessible until everything in it got received.
+	if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+		receiver.fail(err)
+		return err
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", path)
+		receiver.fail(err)
+		return err
+	}
+	receiver.directories = append(receiver.directories, directory{path: path, mode: mode, mtime: mtime})
+	return nil
+}
+
+// leave gives the directory it leaves its mode and mtime.
+func (receiver *receiver) leave() error {
+	if len(receiver.directories) == 0 {
+		return fmt.Errorf("not in a directory")
+	}
+	dir := receiver.directories[len(receiver.directories)-1]
+	receiver.directories = receiver.directories[:len(receiver.directories)-1]
+	if err := preserve(dir.path, dir.mode, dir.mtime); err != nil {
+		receiver.fail(err)
+		return err
+	}
+	return nil
+}
+
+// receiveFile receives the file announced by "F <mode> <mtime> <size> <name>".
+func (receiver *receiver) receiveFile(fields []string) error {
+	mode, mtime, rest, err := parseHeader(fields, 2)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size %q", rest[0])
+	}
+	path, err := receiver.path(rest[1])
+	var file *os.File
+	if err == nil {
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		receiver.fail(err)
+		return receiver.peer.writeStatus(err)
+	}
+	defer file.Close()
+
+	offset, sum := int64(0), flagNone
+	if receiver.options.Continue {
+		if info, err := file.Stat(); err == nil && info.Size() <= size {
+			// The file is open for writing only.
+			if existing, err := os.Open(path); err == nil {
+				if sum, err = checksum(existing, info.Size()); err == nil {
+					offset = info.Size()
+				} else {
+					sum = flagNone
+				}
+				_ = existing.Close()
+			}
+		}
+	}
+	if err := receiver.peer.writeLine(lineResume, strconv.FormatInt(offset, 10), sum); err != nil {
+		return err
+	}
+	fields, err = receiver.peer.readLine(2)
+	if err != nil {
+		return err
+	}
+	if fields[0] != lineContinue || len(fields) != 2 {
+		return fmt.Errorf("unexpected line %q", fields)
+	}
+	// The sender either continues where the file ends or starts over.
+	if fields[1] == "0" {
+		offset = 0
+	} else if fields[1] != strconv.FormatInt(offset, 10) {
+		return fmt.Errorf("invalid offset %q", fields[1])
+	}
+	log.WithFields(log.Fields{
+		"path":   path,
+		"size":   size,
+		"offset": offset,
+	}).Debugln("Receiving file.")
+
+	err = file.Truncate(offset)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	data := &io.LimitedReader{R: receiver.peer.in, N: size - offset}
+	out := &progressWriter{out: file, name: path, done: offset, size: size, options: receiver.options}
+	receiver.options.progress(path, offset, size)
+	if err == nil {
+		_, err = io.Copy(out, data)
+		if err == nil && data.N > 0 {
+			return io.ErrUnexpectedEOF
+		} else if err != nil && out.err == nil {
+			// The connection failed rather than the file.
+			return err
+		}
+	}
+	// Data that could not be written has to be read anyway.
+	if _, readErr := io.Copy(ioutil.Discard, data); readErr != nil {
+		return readErr
+	} else if data.N > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if statusErr := receiver.peer.readStatus(); statusErr != nil {
+		if _, ok := statusErr.(*remoteError); !ok {
+			return statusErr
+		}
+		err = statusErr
+	}
+	if err == nil {
+		err = preserve(path, mode, mtime)
+	}
+	if err != nil {
+		receiver.fail(err)
+	}
+	return receiver.peer.writeStatus(err)
+}
+
+func (receiver *receiver) fail(err error) {
+	receiver.failures++
+	receiver.options.failed(err)
+}
+
+// parseHeader parses the mode and mtime of a D or F line, followed by n more fields of which the last one is the name.
+func parseHeader(fields []string, n int) (os.FileMode, time.Time, []string, error) {
+	if len(fields) != 2 {
+		return 0, time.Time{}, nil, fmt.Errorf("unexpected line %q", fields)
+	}
+	header := strings.SplitN(fields[1], " ", n+2)
+	if len(header) != n+2 {
+		return 0, time.Time{}, nil, fmt.Errorf("invalid header %q", fields[1])
+	}
+	mode, err := strconv.ParseUint(header[0], 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, time.Time{}, nil, fmt.Errorf("invalid mode %q", header[0])
+	}
+	mtime, err := strconv.ParseInt(header[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, nil, fmt.Errorf("invalid mtime %q", header[1])
+	}
+	return os.FileMode(mode), time.Unix(mtime, 0), header[2:], nil
+}
+
+// preserve gives the file the mode and mtime of the original.
+func preserve(path string, mode os.FileMode, mtime time.Time) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
@@ -0,0 +1,216 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A sender sends local files to the receiver on the other side.
+type sender struct {
+	peer     *peer
+	options  Options
+	failures int
+}
+
+// send sends the file or directory at the path as name. Only the top of the transfer follows symbolic links, other
+// files that are neither regular files nor directories get skipped. The error is about the connection, failures of
+// single files get reported to the other side.
+func (sender *sender) send(path string, name string, top bool) error {
+	log.WithFields(log.Fields{
+		"path": path,
+		"name": name,
+	}).Traceln("--> transfer.sender.send")
+	stat := os.Lstat
+	if top {
+		stat = os.Stat
+	}
+	info, err := stat(path)
+	if err != nil {
+		return sender.warn(err)
+	}
+	switch {
+	case info.IsDir():
+		if !sender.options.Recursive {
+			return sender.warn(fmt.Errorf("%s is a directory", path))
+		}
+		return sender.sendDirectory(path, name, info)
+	case info.Mode().IsRegular():
+		return sender.sendFile(path, name, info)
+	default:
+		return sender.warn(fmt.Errorf("%s is not a regular file", path))
+	}
+}
+
+func (sender *sender) sendDirectory(path string, name string, info os.FileInfo) error {
+	if err := sender.peer.writeLine(lineDirectory, formatMode(info), formatMtime(info), name); err != nil {
+		return err
+	}
+	if err := sender.readStatus(path); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if err := sender.warn(err); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if err := sender.send(filepath.Join(path, entry.Name()), entry.Name(), false); err != nil {
+			return err
+		}
+	}
+	if err := sender.peer.writeLine(lineEnd); err != nil {
+		return err
+	}
+	return sender.readStatus(path)
+}
+
+func (sender *sender) sendFile(path string, name string, info os.FileInfo) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return sender.warn(err)
+	}
+	defer file.Close()
+	size := info.Size()
+	err = sender.peer.writeLine(lineFile, formatMode(info), formatMtime(info), strconv.FormatInt(size, 10), name)
+	if err != nil {
+		return err
+	}
+	fields, err := sender.peer.readLine(3)
+	if err != nil {
+		return err
+	}
+	if fields[0] == lineError && len(fields) == 2 {
+		sender.fail(fmt.Errorf("%s: %s", path, fields[1]))
+		return nil
+	}
+	if fields[0] != lineResume || len(fields) != 3 {
+		return fmt.Errorf("unexpected line %q", fields)
+	}
+	offset, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || offset < 0 {
+		return fmt.Errorf("invalid offset %q", fields[1])
+	}
+	if offset > 0 {
+		// Only what both sides have in common can be skipped.
+		if sum, err := checksum(file, offset); offset > size || err != nil || sum != fields[2] {
+			offset = 0
+		}
+	}
+	if err := sender.peer.writeLine(lineContinue, strconv.FormatInt(offset, 10)); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"path":   path,
+		"size":   size,
+		"offset": offset,
+	}).Debugln("Sending file.")
+	out := &progressWriter{out: sender.peer.out, name: path, done: offset, size: size, options: sender.options}
+	sender.options.progress(path, offset, size)
+	_, readErr := io.CopyN(out, io.NewSectionReader(file, offset, size-offset), size-offset)
+	if readErr != nil {
+		if out.err != nil {
+			return out.err
+		}
+		// The other side expects as much data as announced, which gets made up for.
+		if _, err := io.CopyN(sender.peer.out, zeros{}, size-out.done); err != nil {
+			return err
+		}
+		readErr = fmt.Errorf("failed to read %s: %s", path, readErr.Error())
+	}
+	if err := sender.peer.writeStatus(readErr); err != nil {
+		return err
+	}
+	if readErr != nil {
+		sender.fail(readErr)
+	}
+	return sender.readStatus(path)
+}
+
+// readStatus reads the answer of the receiver about the path. A failure for the path counts, but only a failure of the
+// connection is returned.
+func (sender *sender) readStatus(path string) error {
+	err := sender.peer.readStatus()
+	var remote *remoteError
+	if errors.As(err, &remote) {
+		sender.fail(fmt.Errorf("%s: %s", path, remote.msg))
+		return nil
+	}
+	return err
+}
+
+// warn reports a local failure to the other side.
+func (sender *sender) warn(err error) error {
+	sender.fail(err)
+	if err := sender.peer.writeLine(lineWarning, strings.ReplaceAll(err.Error(), "\n", " ")); err != nil {
+		return err
+	}
+	return sender.peer.readStatus()
+}
+
+func (sender *sender) fail(err error) {
+	sender.failures++
+	sender.options.failed(err)
+}
+
+// quit ends the transfer.
+func (sender *sender) quit() error {
+	if err := sender.peer.writeLine(lineQuit); err != nil {
+		return err
+	}
+	return sender.peer.readStatus()
+}
+
+func formatMode(info os.FileInfo) string {
+	return strconv.FormatUint(uint64(info.Mode().Perm()), 8)
+}
+
+func formatMtime(info os.FileInfo) string {
+	return strconv.FormatInt(info.ModTime().Unix(), 10)
+}
+
+// checksum returns the hex encoded SHA-256 of the first n bytes of the file.
+func checksum(file io.ReaderAt, n int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, io.NewSectionReader(file, 0, n), n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// progressWriter counts the data of a file and reports the progress. It remembers the error of the writer, to tell it
+// from the one of the reader.
+type progressWriter struct {
+	out     io.Writer
+	name    string
+	done    int64
+	size    int64
+	options Options
+	err     error
+}
+
+func (writer *progressWriter) Write(p []byte) (int, error) {
+	n, err := writer.out.Write(p)
+	writer.done += int64(n)
+	writer.err = err
+	writer.options.progress(writer.name, writer.done, writer.size)
+	return n, err
+}
+
+// zeros reads endless zeros.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
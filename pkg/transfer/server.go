@@ -0,0 +1,66 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Serve serves the commands of a client on conn until it goes away. Paths are relative to the working directory.
+func Serve(conn io.ReadWriter) error {
+	log.Traceln("--> transfer.Serve")
+	peer := newPeer(conn)
+	for {
+		fields, err := peer.readLine(3)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			log.WithError(err).Errorln("Failed to read command.")
+			return err
+		}
+		if len(fields) != 3 {
+			err := fmt.Errorf("invalid command %q", strings.Join(fields, " "))
+			log.WithError(err).Errorln("Failed to serve command.")
+			return err
+		}
+		options := parseFlags(fields[1])
+		log.WithFields(log.Fields{
+			"command": fields[0],
+			"flags":   fields[1],
+			"path":    fields[2],
+		}).Infoln("Serving command.")
+		switch fields[0] {
+		case commandUpload:
+			err = serveUpload(peer, fields[2], options)
+		case commandDownload:
+			sender := &sender{peer: peer, options: options}
+			if err = sender.send(fields[2], filepath.Base(filepath.Clean(fields[2])), true); err == nil {
+				err = sender.quit()
+			}
+		default:
+			err = fmt.Errorf("unknown command %q", fields[0])
+		}
+		if err != nil {
+			log.WithError(err).Errorln("Failed to serve command.")
+			return err
+		}
+	}
+}
+
+// serveUpload receives what the client sends into the target.
+func serveUpload(peer *peer, target string, options Options) error {
+	if options.directory {
+		if info, err := os.Stat(target); err != nil || !info.IsDir() {
+			return peer.writeStatus(fmt.Errorf("%s is not a directory", target))
+		}
+	}
+	if err := peer.writeStatus(nil); err != nil {
+		return err
+	}
+	receiver := &receiver{peer: peer, options: options, target: target}
+	return receiver.receive()
+}
@@ -0,0 +1,186 @@
+// Package transfer implements the gcp file transfer protocol, which copies files and directory trees in either
+// direction over a connection to the gcp subsystem.
+//
+// The client starts a transfer with a command line, "U <flags> <target>" to upload into the target or
+// "G <flags> <source>" to download the source. The flags are "-" or any of "r" for recursive, "c" to continue
+// interrupted transfers and "d" if the target has to be a directory. The server answers K or X <message>, except that
+// it starts sending right away for G. Then the side that has the files sends them to the other one, which answers every
+// line:
+//
+//	D <mode> <mtime> <name>         Enters a directory.        K or X <message>
+//	E                               Leaves the directory.      K or X <message>
+//	F <mode> <mtime> <size> <name>  Announces a file.          R <offset> <checksum> or X <message>
+//	C <offset>                      Followed by the data.      Nothing
+//	K or X <message>                Whether the data is valid. K or X <message>
+//	W <message>                     Reports a failure.         K
+//	Q                               Ends the transfer.         K
+//
+// Modes are octal, mtimes are seconds since the epoch. A receiver continuing an interrupted transfer answers with the
+// size of what it has already along with the SHA-256 of it. The sender only skips that much if it has the same at the
+// start of the file. Otherwise the offset is 0 and the checksum "-".
+package transfer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// The name of the subsystem serving the protocol.
+const Subsystem = "gcp"
+
+// Commands of the client.
+const (
+	commandUpload   = "U"
+	commandDownload = "G"
+)
+
+// Flags of a command.
+const (
+	flagRecursive = "r"
+	flagContinue  = "c"
+	flagDirectory = "d" // The target of an upload has to be a directory, since there are several sources.
+	flagNone      = "-"
+)
+
+// Lines of the sender.
+const (
+	lineDirectory = "D"
+	lineEnd       = "E"
+	lineFile      = "F"
+	lineContinue  = "C"
+	lineWarning   = "W"
+	lineQuit      = "Q"
+)
+
+// Lines of the receiver, and of the sender after the data.
+const (
+	lineOk     = "K"
+	lineError  = "X"
+	lineResume = "R"
+)
+
+// ErrIncomplete is returned when some of the files could not be transferred.
+var ErrIncomplete = errors.New("some files were not transferred")
+
+// Options of a transfer.
+type Options struct {
+	Recursive bool // Copy directories along with what they contain.
+	Continue  bool // Continue interrupted transfers instead of starting over.
+	// Progress gets called while the data of a file gets transferred, if set.
+	Progress func(name string, done int64, size int64)
+	// Failed gets called for every file that could not be transferred, if set.
+	Failed    func(err error)
+	directory bool // The target has to be a directory.
+}
+
+func (options Options) flags() string {
+	flags := ""
+	if options.Recursive {
+		flags += flagRecursive
+	}
+	if options.Continue {
+		flags += flagContinue
+	}
+	if options.directory {
+		flags += flagDirectory
+	}
+	if flags == "" {
+		return flagNone
+	}
+	return flags
+}
+
+func parseFlags(flags string) Options {
+	return Options{
+		Recursive: strings.Contains(flags, flagRecursive),
+		Continue:  strings.Contains(flags, flagContinue),
+		directory: strings.Contains(flags, flagDirectory),
+	}
+}
+
+func (options Options) progress(name string, done int64, size int64) {
+	if options.Progress != nil {
+		options.Progress(name, done, size)
+	}
+}
+
+func (options Options) failed(err error) {
+	log.WithError(err).Warnln("Failed to transfer file.")
+	if options.Failed != nil {
+		options.Failed(err)
+	}
+}
+
+// peer reads the lines and data of the other side of the connection.
+type peer struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPeer(conn io.ReadWriter) *peer {
+	return &peer{in: bufio.NewReader(conn), out: conn}
+}
+
+// writeLine writes a line made of the fields, of which only the last may contain spaces.
+func (peer *peer) writeLine(fields ...string) error {
+	line := strings.Join(fields, " ")
+	if strings.ContainsAny(line, "\n") {
+		return fmt.Errorf("line %q contains a newline", line)
+	}
+	_, err := io.WriteString(peer.out, line+"\n")
+	return err
+}
+
+// readLine reads a line and splits it into n fields at most, of which the first one is the type of the line.
+func (peer *peer) readLine(n int) ([]string, error) {
+	line, err := peer.in.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return strings.SplitN(strings.TrimSuffix(line, "\n"), " ", n), nil
+}
+
+// readStatus reads an answer that is either K or X <message>, which turns into an error.
+func (peer *peer) readStatus() error {
+	fields, err := peer.readLine(2)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fields[0] == lineOk:
+		return nil
+	case fields[0] == lineError && len(fields) == 2:
+		return &remoteError{fields[1]}
+	default:
+		return fmt.Errorf("unexpected line %q", strings.Join(fields, " "))
+	}
+}
+
+// writeStatus answers K, or X with the message of the error.
+func (peer *peer) writeStatus(err error) error {
+	if err == nil {
+		return peer.writeLine(lineOk)
+	}
+	return peer.writeLine(lineError, strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// A remoteError is a failure the other side reported. Only those for single files leave the connection usable.
+type remoteError struct {
+	msg string
+}
+
+func (err *remoteError) Error() string {
+	return err.msg
+}
+
+// validName checks that the name of a directory entry from the other side stays within the directory.
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
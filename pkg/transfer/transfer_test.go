@@ -0,0 +1,28 @@
+package transfer
+
+import (
+	"testing"
+)
+
+func TestOptions_Flags(t *testing.T) {
+	for _, options := range []Options{{}, {Recursive: true}, {Continue: true, directory: true}} {
+		parsed := parseFlags(options.flags())
+		if parsed.Recursive != options.Recursive || parsed.Continue != options.Continue ||
+			parsed.directory != options.directory {
+			t.Errorf("Flags %q of %+v got parsed as %+v.", options.flags(), options, parsed)
+		}
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"a.txt", "with space", "..hidden"} {
+		if err := validName(name); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../etc", "a/b", "nul\x00"} {
+		if err := validName(name); err == nil {
+			t.Errorf("Name %q is valid.", name)
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"crypto/tls"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.engineering.zhaw.ch/neut/oh-my-gosh/pkg/common"
 	"io"
 	"net"
 	"os"
+	"time"
 )
 
 func ConnFromFd(fd uintptr, certificate *tls.Certificate) (net.Conn, error) {
@@ -15,7 +17,11 @@ func ConnFromFd(fd uintptr, certificate *tls.Certificate) (net.Conn, error) {
 		"fd":          fd,
 		"certificate": &certificate,
 	}).Traceln("--> utils.ConnFromFd")
-	conn, err := net.FileConn(os.NewFile(fd, "conn"))
+	file := os.NewFile(fd, "conn")
+	conn, err := net.FileConn(file)
+	if closeErr := file.Close(); closeErr != nil {
+		log.WithError(closeErr).Warnln("Failed to close duplicated file descriptor.")
+	}
 	if err != nil {
 		log.WithError(err).Errorln("Failed to make a connection from file descriptor.")
 		return nil, err
@@ -70,3 +76,24 @@ func Forward(in io.Reader, out io.Writer, inStr string, outStr string) {
 		"n":         n,
 	}).Debugln(fmt.Sprintf("Wrote from %s to %s.", inStr, outStr))
 }
+
+// RemoveStaleSocket removes the Unix socket at the path if nobody listens on it anymore. It fails if the path is in use
+// or no socket. A path that does not exist is fine.
+func RemoveStaleSocket(path string) error {
+	log.WithField("path", path).Traceln("--> utils.RemoveStaleSocket")
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout(common.UNIX, path, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	log.WithField("path", path).Infoln("Removing stale socket.")
+	return os.Remove(path)
+}